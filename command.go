@@ -0,0 +1,443 @@
+package certmagicazureblob
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/caddyserver/certmagic"
+	"github.com/spf13/cobra"
+	"github.com/webedmj/certmagic-azureblob/storage"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "azureblob",
+		Short: "Inspects and manages the Azure Blob storage backend",
+		Long: `
+Commands for inspecting and managing the keys stored by the azureblob storage
+module. The storage settings are read from the storage block of the given
+Caddyfile or JSON config (--config), so the commands see exactly what Caddy sees.
+
+If --config is omitted, a Caddyfile in the current directory is used.
+`,
+		CobraFunc: func(cmd *cobra.Command) {
+			cmd.PersistentFlags().StringP("config", "c", "", "Configuration file containing the azureblob storage block")
+			cmd.PersistentFlags().StringP("adapter", "a", "", "Name of config adapter to apply")
+
+			cmd.AddCommand(&cobra.Command{
+				Use:   "ls [<prefix>]",
+				Short: "Lists keys under a prefix",
+				Args:  cobra.MaximumNArgs(1),
+				RunE:  caddycmd.WrapCommandFuncForCobra(cmdList),
+			})
+			cmd.AddCommand(&cobra.Command{
+				Use:   "cat <key>",
+				Short: "Writes the value of a key to stdout",
+				Args:  cobra.ExactArgs(1),
+				RunE:  caddycmd.WrapCommandFuncForCobra(cmdCat),
+			})
+			cmd.AddCommand(&cobra.Command{
+				Use:   "stat <key>",
+				Short: "Shows information about a key",
+				Args:  cobra.ExactArgs(1),
+				RunE:  caddycmd.WrapCommandFuncForCobra(cmdStat),
+			})
+			cmd.AddCommand(&cobra.Command{
+				Use:   "put <key> <file>",
+				Short: "Stores the contents of a file at a key",
+				Long: `
+Stores the contents of a file at a key, overwriting any existing value.
+Use - as the file to read from stdin.
+`,
+				Args: cobra.ExactArgs(2),
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdPut),
+			})
+			cmd.AddCommand(&cobra.Command{
+				Use:   "rm <key>",
+				Short: "Deletes a key, or every key under a prefix",
+				Args:  cobra.ExactArgs(1),
+				RunE:  caddycmd.WrapCommandFuncForCobra(cmdRemove),
+			})
+			migrateCmd := &cobra.Command{
+				Use:   "migrate --from file|azureblob [--root <path>] [--prefix <prefix>] [--dry-run] [--resume-after <key>]",
+				Short: "Copies keys between file system storage and Azure Blob storage",
+				Long: `
+Copies every key between Caddy's file system storage and the configured Azure
+Blob container. --from selects the source: "file" copies from the file system
+into Azure, "azureblob" copies from Azure onto the file system.
+
+--root is the file system storage root and defaults to Caddy's data directory.
+Modification times are preserved, lock files are not copied and keys that are
+already identical are skipped, so the command can safely be run again. After an
+interruption, --resume-after continues after the last key that was reported.
+`,
+				Args: cobra.NoArgs,
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdMigrate),
+			}
+			migrateCmd.Flags().String("from", "", "Source storage: file or azureblob (required)")
+			migrateCmd.Flags().String("root", caddy.AppDataDir(), "File system storage root")
+			migrateCmd.Flags().String("prefix", "", "Only migrate keys with this prefix")
+			migrateCmd.Flags().String("resume-after", "", "Skip keys up to and including this key")
+			migrateCmd.Flags().Bool("dry-run", false, "Report what would be copied without writing")
+			cmd.AddCommand(migrateCmd)
+			cmd.AddCommand(&cobra.Command{
+				Use:   "locks",
+				Short: "Lists lock blobs and their lease state",
+				Args:  cobra.NoArgs,
+				RunE:  caddycmd.WrapCommandFuncForCobra(cmdLocks),
+			})
+			cmd.AddCommand(&cobra.Command{
+				Use:   "unlock <key>",
+				Short: "Breaks the lock on a key, whichever instance holds it",
+				Long: `
+Breaks the lease on the lock blob of a key, as listed by the locks command,
+so that another instance can acquire the lock. Use it only for locks left
+behind by an instance that is gone or stuck, since the instance holding the
+lock is not notified.
+`,
+				Args: cobra.ExactArgs(1),
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdUnlock),
+			})
+			cmd.AddCommand(&cobra.Command{
+				Use:   "doctor",
+				Short: "Checks connectivity and permissions step by step",
+				Long: `
+Checks whether the configured storage works: that the credential can acquire
+a token, that the endpoint resolves and accepts connections, and that the
+container exists and allows listing, writing, reading, leasing and deleting a
+scratch key. Every step is reported as PASS, FAIL or SKIP; failed steps include
+a hint at the missing role, SAS permission or firewall rule.
+
+Unlike Caddy, doctor does not create the container, so it also works when
+startup fails with "could not create container".
+`,
+				Args: cobra.NoArgs,
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdDoctor),
+			})
+			cmd.AddCommand(&cobra.Command{
+				Use:   "posture",
+				Short: "Checks the security settings of the container and account",
+				Long: `
+Checks that the containers, including those of routes and the secondary, do
+not allow anonymous access, that their accounts reject shared key
+authorization if require_entra_auth is set, and that blob versioning and soft
+delete are enabled. Versioning and soft delete are detected from the
+container listing, or by writing and deleting a scratch key if
+posture_write_probe is set. Every check is reported as PASS, FAIL or UNKNOWN;
+the command fails if any check failed, so it can guard CI pipelines.
+`,
+				Args: cobra.NoArgs,
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdPosture),
+			})
+		},
+	})
+}
+
+// storageFromConfig is the storage portion of a Caddy JSON config.
+type storageFromConfig struct {
+	StorageRaw json.RawMessage `json:"storage,omitempty"`
+}
+
+// loadCommandStorage builds the storage from the azureblob storage module configured in
+// the config file given by the --config and --adapter flags.
+func loadCommandStorage(fl caddycmd.Flags) (*storage.Storage, error) {
+	mod, err := loadCommandModule(fl)
+	if err != nil {
+		return nil, err
+	}
+	return mod.newStorage()
+}
+
+// loadInspectStorage is loadCommandStorage for commands that do not modify keys, which
+// never create the container.
+func loadInspectStorage(fl caddycmd.Flags) (*storage.Storage, error) {
+	mod, err := loadCommandModule(fl)
+	if err != nil {
+		return nil, err
+	}
+	mod.CreateContainer = string(storage.CreateContainerNever)
+	return mod.newStorage()
+}
+
+// loadCommandModule decodes the azureblob storage module configured in the config file
+// given by the --config and --adapter flags. The module is decoded but not provisioned,
+// since provisioning needs a running Caddy config (for the events app, metrics and logs)
+// that the commands do not have.
+func loadCommandModule(fl caddycmd.Flags) (*CaddyStorageAzureBlob, error) {
+	cfg, _, _, err := caddycmd.LoadConfig(fl.String("config"), fl.String("adapter"))
+	if err != nil {
+		return nil, err
+	}
+
+	var storVal storageFromConfig
+	if err := json.Unmarshal(cfg, &storVal); err != nil {
+		return nil, fmt.Errorf("parsing config: %w", err)
+	}
+	if storVal.StorageRaw == nil {
+		return nil, errors.New("config does not define a storage module")
+	}
+
+	var module struct {
+		Name string `json:"module"`
+	}
+	if err := json.Unmarshal(storVal.StorageRaw, &module); err != nil {
+		return nil, fmt.Errorf("parsing storage module: %w", err)
+	}
+	if module.Name != "azureblob" {
+		return nil, fmt.Errorf("configured storage module is %q, not azureblob", module.Name)
+	}
+
+	mod := new(CaddyStorageAzureBlob)
+	if err := json.Unmarshal(storVal.StorageRaw, mod); err != nil {
+		return nil, fmt.Errorf("parsing azureblob storage module: %w", err)
+	}
+	if err := mod.Validate(); err != nil {
+		return nil, err
+	}
+	return mod, nil
+}
+
+// commandContext returns the context used for a single CLI storage operation.
+func commandContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), 5*time.Minute)
+}
+
+func cmdList(fl caddycmd.Flags) (int, error) {
+	stor, err := loadInspectStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	keys, err := stor.List(ctx, fl.Arg(0), true)
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdCat(fl caddycmd.Flags) (int, error) {
+	stor, err := loadInspectStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	value, err := stor.Load(ctx, fl.Arg(0))
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, fmt.Errorf("loading %s: %w", fl.Arg(0), err)
+	}
+	if _, err := os.Stdout.Write(value); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdStat(fl caddycmd.Flags) (int, error) {
+	stor, err := loadInspectStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	info, err := stor.Stat(ctx, fl.Arg(0))
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, fmt.Errorf("stat %s: %w", fl.Arg(0), err)
+	}
+	fmt.Printf("Key:      %s\n", info.Key)
+	fmt.Printf("Size:     %d\n", info.Size)
+	fmt.Printf("Modified: %s\n", info.Modified.Format(time.RFC3339))
+	fmt.Printf("Terminal: %t\n", info.IsTerminal)
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdPut(fl caddycmd.Flags) (int, error) {
+	var (
+		value []byte
+		err   error
+	)
+	if fl.Arg(1) == "-" {
+		value, err = io.ReadAll(os.Stdin)
+	} else {
+		value, err = os.ReadFile(fl.Arg(1))
+	}
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("reading input: %w", err)
+	}
+
+	stor, err := loadCommandStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := stor.Store(ctx, fl.Arg(0), value); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdRemove(fl caddycmd.Flags) (int, error) {
+	stor, err := loadCommandStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := stor.Delete(ctx, fl.Arg(0)); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdLocks(fl caddycmd.Flags) (int, error) {
+	stor, err := loadInspectStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	locks, err := stor.ListLocks(ctx)
+	if err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tHELD\tLEASE STATE\tMODIFIED")
+	for _, l := range locks {
+		fmt.Fprintf(w, "%s\t%t\t%s\t%s\n", l.Key, l.Held, l.LeaseState, l.Modified.Format(time.RFC3339))
+	}
+	if err := w.Flush(); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdUnlock(fl caddycmd.Flags) (int, error) {
+	stor, err := loadCommandStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	if err := stor.ForceUnlock(ctx, fl.Arg(0)); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdDoctor(fl caddycmd.Flags) (int, error) {
+	mod, err := loadCommandModule(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	diagnosis := storage.Diagnose(ctx, mod.storageConfig())
+	if err := writeDiagnosis(os.Stdout, diagnosis); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	if diagnosis.Failed() {
+		return caddy.ExitCodeFailedQuit, errors.New("some checks failed")
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// writeDiagnosis prints one line per diagnostic step, followed by the hint of failed steps.
+func writeDiagnosis(out io.Writer, diagnosis storage.Diagnosis) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, step := range diagnosis.Steps {
+		fmt.Fprintf(w, "%s\t%s\t%s", strings.ToUpper(string(step.Status)), step.Name, step.Detail)
+		if step.Status != storage.DiagnosticSkip {
+			fmt.Fprintf(w, " (%s)", step.Duration.Round(time.Millisecond))
+		}
+		fmt.Fprintln(w)
+		if step.Hint != "" {
+			fmt.Fprintf(w, "\t\thint: %s\n", step.Hint)
+		}
+	}
+	return w.Flush()
+}
+
+func cmdPosture(fl caddycmd.Flags) (int, error) {
+	stor, err := loadInspectStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+
+	posture := stor.CheckPosture(ctx)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, check := range posture.Checks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", strings.ToUpper(string(check.Status)), check.Container, check.Name, check.Detail)
+	}
+	if err := w.Flush(); err != nil {
+		return caddy.ExitCodeFailedQuit, err
+	}
+	if posture.Failed() {
+		return caddy.ExitCodeFailedQuit, errors.New("some checks failed")
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdMigrate(fl caddycmd.Flags) (int, error) {
+	stor, err := loadCommandStorage(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	fileStorage := &certmagic.FileStorage{Path: fl.String("root")}
+
+	var src, dst certmagic.Storage
+	switch fl.String("from") {
+	case "file":
+		src, dst = fileStorage, stor
+	case "azureblob":
+		src, dst = stor, fileStorage
+	default:
+		return caddy.ExitCodeFailedStartup, errors.New("--from must be file or azureblob")
+	}
+
+	dryRun := fl.Bool("dry-run")
+	report, err := storage.Migrate(context.Background(), src, dst, storage.MigrateOptions{
+		Prefix:      fl.String("prefix"),
+		ResumeAfter: fl.String("resume-after"),
+		DryRun:      dryRun,
+		Progress: func(key string, action storage.MigrateAction) {
+			if dryRun && action == storage.MigrateCopied {
+				fmt.Printf("would copy %s\n", key)
+				return
+			}
+			fmt.Printf("%s %s\n", action, key)
+		},
+	})
+	if err != nil {
+		if report.LastKey != "" {
+			err = fmt.Errorf("%w (resume with --resume-after %q)", err, report.LastKey)
+		}
+		return caddy.ExitCodeFailedQuit, err
+	}
+
+	fmt.Printf("%d copied, %d skipped\n", len(report.Copied), len(report.Skipped))
+	return caddy.ExitCodeSuccess, nil
+}
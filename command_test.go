@@ -0,0 +1,133 @@
+package certmagicazureblob
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webedmj/certmagic-azureblob/storage/storagetest"
+)
+
+// commandFlags returns a function that builds the flags of a command invocation with
+// args, for a config file whose azureblob storage uses server.
+func commandFlags(t *testing.T, server *storagetest.Server) func(args ...string) caddycmd.Flags {
+	t.Helper()
+	config, err := json.Marshal(map[string]any{
+		"storage": map[string]any{
+			"module":            "azureblob",
+			"account_name":      storagetest.AccountName,
+			"container_name":    "caddy",
+			"connection_string": server.ConnectionString(),
+		},
+	})
+	require.NoError(t, err)
+	configFile := filepath.Join(t.TempDir(), "caddy.json")
+	require.NoError(t, os.WriteFile(configFile, config, 0o600))
+
+	return func(args ...string) caddycmd.Flags {
+		fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		fs.String("config", configFile, "")
+		fs.String("adapter", "", "")
+		require.NoError(t, fs.Parse(args))
+		return caddycmd.Flags{FlagSet: fs}
+	}
+}
+
+// runCommand runs command with fl and returns its exit code and what it wrote to stdout.
+func runCommand(t *testing.T, command func(caddycmd.Flags) (int, error), fl caddycmd.Flags) (int, string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	code, cmdErr := command(fl)
+	os.Stdout = stdout
+	require.NoError(t, w.Close())
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return code, string(out), cmdErr
+}
+
+func TestCommands(t *testing.T) {
+	server := storagetest.NewServer()
+	t.Cleanup(server.Close)
+	flags := commandFlags(t, server)
+	key := "certificates/example.com/example.com.crt"
+	value := "certificate"
+	valueFile := filepath.Join(t.TempDir(), "value")
+	require.NoError(t, os.WriteFile(valueFile, []byte(value), 0o600))
+
+	code, _, err := runCommand(t, cmdPut, flags(key, valueFile))
+	require.NoError(t, err)
+	assert.Equal(t, caddy.ExitCodeSuccess, code)
+
+	_, out, err := runCommand(t, cmdList, flags("certificates/"))
+	require.NoError(t, err)
+	assert.Equal(t, key+"\n", out)
+
+	_, out, err = runCommand(t, cmdCat, flags(key))
+	require.NoError(t, err)
+	assert.Equal(t, value, out)
+
+	_, out, err = runCommand(t, cmdStat, flags(key))
+	require.NoError(t, err)
+	assert.Contains(t, out, "Key:      "+key+"\n")
+	assert.Contains(t, out, "Size:     11\n")
+	assert.Contains(t, out, "Terminal: true\n")
+
+	stor, err := loadCommandStorage(flags())
+	require.NoError(t, err)
+	require.NoError(t, stor.Lock(context.Background(), "issue_cert_example.com"))
+	t.Cleanup(func() { _ = stor.Unlock(context.Background(), "issue_cert_example.com") })
+	_, out, err = runCommand(t, cmdLocks, flags())
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"issue_cert_example.com", "true", "leased"}, strings.Fields(lines[1])[:3])
+
+	code, _, err = runCommand(t, cmdUnlock, flags("issue_cert_example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, caddy.ExitCodeSuccess, code)
+	_, out, err = runCommand(t, cmdLocks, flags())
+	require.NoError(t, err)
+	lines = strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, []string{"issue_cert_example.com", "false"}, strings.Fields(lines[1])[:2], "The lock should be broken")
+
+	code, _, err = runCommand(t, cmdRemove, flags("certificates/"))
+	require.NoError(t, err)
+	assert.Equal(t, caddy.ExitCodeSuccess, code)
+	code, _, err = runCommand(t, cmdCat, flags(key))
+	assert.Error(t, err, "A removed key should not be found")
+	assert.Equal(t, caddy.ExitCodeFailedQuit, code)
+}
+
+// Test that the commands that only read fail instead of creating a missing container.
+func TestInspectCommandsDoNotCreateContainer(t *testing.T) {
+	server := storagetest.NewServer()
+	t.Cleanup(server.Close)
+	flags := commandFlags(t, server)
+
+	for name, command := range map[string]func(caddycmd.Flags) (int, error){
+		"ls":      cmdList,
+		"cat":     cmdCat,
+		"stat":    cmdStat,
+		"locks":   cmdLocks,
+		"posture": cmdPosture,
+	} {
+		args := map[string][]string{"cat": {"key"}, "stat": {"key"}}[name]
+		code, _, err := runCommand(t, command, flags(args...))
+		require.Error(t, err, name)
+		assert.Contains(t, err.Error(), "does not exist", name)
+		assert.Equal(t, caddy.ExitCodeFailedStartup, code, name)
+	}
+}
@@ -2,8 +2,8 @@ package certmagicazureblob_test
 
 import (
 	"context"
-	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/webedmj/certmagic-azureblob/storage"
+	"github.com/webedmj/certmagic-azureblob/storage/storagetest"
 )
 
 func init() {
@@ -24,22 +25,19 @@ const (
 	testContainer = "test-container"
 )
 
-// getTestConnectionString returns the connection string for tests
+// fakeServer is the in-memory Blob service used when AZURE_STORAGE_ACCOUNT is not set.
+var fakeServer = sync.OnceValue(storagetest.NewServer)
 
-func getTestConnectionString() string {
-	connStr := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
-	// Connection string is optional - return empty string if not set
-	return connStr
-}
-
-// getTestAccountName returns the account name for tests
-// Requires AZURE_STORAGE_ACCOUNT environment variable or .env file
-func getTestAccountName() (string, error) {
-	accountName := os.Getenv("AZURE_STORAGE_ACCOUNT")
+// getTestAccount returns the account name and connection string for tests, from the
+// AZURE_STORAGE_ACCOUNT and AZURE_STORAGE_CONNECTION_STRING environment variables or a
+// .env file. The connection string is optional. Without an account name, the tests run
+// against the in-memory Blob service.
+func getTestAccount() (accountName, connectionString string) {
+	accountName = os.Getenv("AZURE_STORAGE_ACCOUNT")
 	if accountName == "" {
-		return "", fmt.Errorf("AZURE_STORAGE_ACCOUNT environment variable is required for tests. Set it in your shell or create a .env file (see .env.example)")
+		return storagetest.AccountName, fakeServer().ConnectionString()
 	}
-	return accountName, nil
+	return accountName, os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
 }
 
 func TestAzureBlobStorage(t *testing.T) {
@@ -51,12 +49,7 @@ func TestAzureBlobStorage(t *testing.T) {
 	ctx := context.Background()
 
 	// Get connection details from environment variables
-	connectionString := getTestConnectionString()
-
-	accountName, err := getTestAccountName()
-	if err != nil {
-		t.Skipf("Skipping test: %v", err)
-	}
+	accountName, connectionString := getTestAccount()
 
 	// Set up Azure Blob Storage with environment-configured credentials
 	// If connectionString is empty, will use Azure CLI/managed identity
@@ -106,11 +99,7 @@ func TestCertMagicDistributedLocking(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	connectionString := getTestConnectionString()
-	accountName, err := getTestAccountName()
-	if err != nil {
-		t.Skipf("Skipping test: %v", err)
-	}
+	accountName, connectionString := getTestAccount()
 
 	storageBackend, err := storage.NewStorage(ctx, storage.Config{
 		AccountName:      accountName,
@@ -198,11 +187,7 @@ func TestCertMagicLockLeaseRenewal(t *testing.T) {
 	}
 
 	ctx := context.Background()
-	connectionString := getTestConnectionString()
-	accountName, err := getTestAccountName()
-	if err != nil {
-		t.Skipf("Skipping test: %v", err)
-	}
+	accountName, connectionString := getTestAccount()
 
 	storageBackend, err := storage.NewStorage(ctx, storage.Config{
 		AccountName:      accountName,
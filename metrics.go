@@ -0,0 +1,135 @@
+package certmagicazureblob
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/webedmj/certmagic-azureblob/storage"
+)
+
+// metricsNamespace prefixes every metric exported by this module.
+const metricsNamespace = "caddy_storage_azureblob"
+
+// prometheusMetrics implements storage.Metrics with Prometheus collectors.
+type prometheusMetrics struct {
+	operations        *prometheus.CounterVec
+	operationDuration *prometheus.HistogramVec
+	lockAcquisitions  prometheus.Counter
+	lockWait          prometheus.Histogram
+	lockContention    prometheus.Counter
+	lockRenewals      *prometheus.CounterVec
+	locksHeld         prometheus.Gauge
+}
+
+var _ storage.Metrics = (*prometheusMetrics)(nil)
+
+// newPrometheusMetrics registers the storage metrics with registry. Collectors that are
+// already registered, e.g. by another azureblob module in the same config, are shared.
+func newPrometheusMetrics(registry prometheus.Registerer) (*prometheusMetrics, error) {
+	operationLabels := []string{"op", "outcome", "error_code"}
+	m := &prometheusMetrics{
+		operations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "operations_total",
+			Help:      "Counter of storage operations by operation, outcome and Azure error code.",
+		}, operationLabels),
+		operationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Histogram of storage operation latencies by operation, outcome and Azure error code.",
+			Buckets:   prometheus.DefBuckets,
+		}, operationLabels),
+		lockAcquisitions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lock_acquisitions_total",
+			Help:      "Counter of locks acquired.",
+		}),
+		lockWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "lock_wait_seconds",
+			Help:      "Histogram of the time spent waiting to acquire a lock.",
+			Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}),
+		lockContention: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lock_contention_retries_total",
+			Help:      "Counter of lock acquisition retries because the lock was held elsewhere.",
+		}),
+		lockRenewals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "lock_renewals_total",
+			Help:      "Counter of lock lease renewals by outcome.",
+		}, []string{"outcome"}),
+		locksHeld: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "locks_held",
+			Help:      "Number of locks currently held.",
+		}),
+	}
+
+	var err error
+	if m.operations, err = register(registry, m.operations); err != nil {
+		return nil, err
+	}
+	if m.operationDuration, err = register(registry, m.operationDuration); err != nil {
+		return nil, err
+	}
+	if m.lockAcquisitions, err = register(registry, m.lockAcquisitions); err != nil {
+		return nil, err
+	}
+	if m.lockWait, err = register(registry, m.lockWait); err != nil {
+		return nil, err
+	}
+	if m.lockContention, err = register(registry, m.lockContention); err != nil {
+		return nil, err
+	}
+	if m.lockRenewals, err = register(registry, m.lockRenewals); err != nil {
+		return nil, err
+	}
+	if m.locksHeld, err = register(registry, m.locksHeld); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers c, returning the existing collector if an identical one is
+// already registered.
+func register[C prometheus.Collector](registry prometheus.Registerer, c C) (C, error) {
+	if err := registry.Register(c); err != nil {
+		var already prometheus.AlreadyRegisteredError
+		if errors.As(err, &already) {
+			if existing, ok := already.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
+
+func (m *prometheusMetrics) ObserveOperation(op, outcome, errorCode string, duration time.Duration) {
+	m.operations.WithLabelValues(op, outcome, errorCode).Inc()
+	m.operationDuration.WithLabelValues(op, outcome, errorCode).Observe(duration.Seconds())
+}
+
+func (m *prometheusMetrics) ObserveLockAcquired(wait time.Duration) {
+	m.lockAcquisitions.Inc()
+	m.lockWait.Observe(wait.Seconds())
+}
+
+func (m *prometheusMetrics) LockContended() {
+	m.lockContention.Inc()
+}
+
+func (m *prometheusMetrics) ObserveLockRenewal(failed bool) {
+	outcome := storage.OutcomeSuccess
+	if failed {
+		outcome = storage.OutcomeError
+	}
+	m.lockRenewals.WithLabelValues(outcome).Inc()
+}
+
+func (m *prometheusMetrics) AddLocksHeld(delta int) {
+	m.locksHeld.Add(float64(delta))
+}
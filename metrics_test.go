@@ -0,0 +1,40 @@
+package certmagicazureblob
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webedmj/certmagic-azureblob/storage"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	m, err := newPrometheusMetrics(registry)
+	require.NoError(t, err)
+
+	m.ObserveOperation(storage.OpLoad, storage.OutcomeSuccess, "", 10*time.Millisecond)
+	m.ObserveOperation(storage.OpLoad, storage.OutcomeError, "AuthorizationFailure", 5*time.Millisecond)
+	m.ObserveLockAcquired(2 * time.Second)
+	m.LockContended()
+	m.ObserveLockRenewal(true)
+	m.AddLocksHeld(1)
+
+	assert.InDelta(t, 1, testutil.ToFloat64(m.operations.WithLabelValues(storage.OpLoad, storage.OutcomeError, "AuthorizationFailure")), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.lockAcquisitions), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.lockContention), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.lockRenewals.WithLabelValues(storage.OutcomeError)), 0)
+	assert.InDelta(t, 1, testutil.ToFloat64(m.locksHeld), 0)
+
+	// A second module in the same config shares the registered collectors.
+	other, err := newPrometheusMetrics(registry)
+	require.NoError(t, err)
+	other.AddLocksHeld(1)
+	assert.InDelta(t, 2, testutil.ToFloat64(m.locksHeld), 0)
+
+	_, err = registry.Gather()
+	require.NoError(t, err)
+}
@@ -3,13 +3,21 @@ package certmagicazureblob
 import (
 	"context"
 	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
 	"github.com/caddyserver/certmagic"
 	"github.com/webedmj/certmagic-azureblob/storage"
+	"go.uber.org/zap"
 )
 
 // Interface guards
@@ -29,16 +37,219 @@ type CaddyStorageAzureBlob struct {
 	ContainerName string `json:"container_name"`
 	// ConnectionString is the Azure Storage connection string (optional).
 	ConnectionString string `json:"connection_string,omitempty"`
+	// ConnectionStringFile is a file holding the connection string (optional).
+	ConnectionStringFile string `json:"connection_string_file,omitempty"`
+	// AccountKeyFile is a file holding the storage account key (optional).
+	AccountKeyFile string `json:"account_key_file,omitempty"`
+	// SASTokenFile is a file holding a SAS token for the container (optional).
+	SASTokenFile string `json:"sas_token_file,omitempty"`
+	// CreateContainer controls whether the container is created: auto (default), always
+	// or never.
+	CreateContainer string `json:"create_container,omitempty"`
+	// ContainerMetadata is set on the container when the module creates it (optional).
+	ContainerMetadata map[string]string `json:"container_metadata,omitempty"`
+	// ContainerPublicAccess is the public access level of the container when the module
+	// creates it: off (default), blob or container.
+	ContainerPublicAccess string `json:"container_public_access,omitempty"`
+	// SecretReloadInterval is how often secret files are re-read. Defaults to 1m.
+	SecretReloadInterval caddy.Duration `json:"secret_reload_interval,omitempty"`
 	// Credential can be used for authentication (managed identity, etc.)
 	Credential azcore.TokenCredential `json:"-"`
+	// TierPolicy periodically moves inactive blobs to a cooler access tier (optional).
+	TierPolicy *TierPolicyConfig `json:"tier_policy,omitempty"`
+	// Cache enables an in-memory read-through cache for Load, Stat and Exists (optional).
+	Cache *CacheConfig `json:"cache,omitempty"`
+	// MirrorDir is a local directory that mirrors stored keys and serves reads while
+	// Azure is unreachable (optional).
+	MirrorDir string `json:"mirror_dir,omitempty"`
+	// Secondary replicates writes to a second account that reads fall back to (optional).
+	Secondary *SecondaryConfig `json:"secondary,omitempty"`
+	// ReadSecondaryEndpoint reads from the account's RA-GRS secondary endpoint while the
+	// primary endpoint is unavailable (optional).
+	ReadSecondaryEndpoint bool `json:"read_secondary_endpoint,omitempty"`
+	// KeyEncoding maps keys to blob names: plain (default) or escaped.
+	KeyEncoding string `json:"key_encoding,omitempty"`
+	// Audit records every mutation in a journal of append blobs (optional).
+	Audit *AuditConfig `json:"audit,omitempty"`
+	// Routes store keys matching their prefixes in separate containers (optional).
+	Routes []RouteConfig `json:"routes,omitempty"`
+	// ReadOnly rejects Store, Delete and Lock, e.g. on edge nodes (optional).
+	ReadOnly bool `json:"read_only,omitempty"`
+	// ProtectedPrefixes are key prefixes whose keys can be created but not overwritten or
+	// deleted (optional).
+	ProtectedPrefixes []string `json:"protected_prefixes,omitempty"`
+	// Retry configures how failed Azure requests are retried (optional).
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Timeouts are deadlines for storage operations (optional).
+	Timeouts *TimeoutsConfig `json:"timeouts,omitempty"`
+	// Throttle limits concurrent Azure requests, adapting to throttling (optional).
+	Throttle *ThrottleConfig `json:"throttle,omitempty"`
+	// Transport customizes the HTTP client used for Azure requests (optional).
+	Transport *TransportConfig `json:"transport,omitempty"`
+	// RequireEntraAuth requires Microsoft Entra ID authentication: secrets such as
+	// connection strings are rejected, and the posture check requires that the account
+	// disallows shared key authorization (optional).
+	RequireEntraAuth bool `json:"require_entra_auth,omitempty"`
+	// PostureCheck checks the security posture of the containers at provision time:
+	// off (default), warn or fail.
+	PostureCheck string `json:"posture_check,omitempty"`
+	// PostureWriteProbe lets the posture check write and delete a scratch blob under
+	// .azureblob-posture/ to detect versioning and soft delete where the container's
+	// listing shows neither. Soft delete and versioning keep the deleted blob for their
+	// retention period (optional).
+	PostureWriteProbe bool `json:"posture_write_probe,omitempty"`
+	// InitTimeout bounds creating the storage, including the container. Defaults to 30s.
+	InitTimeout caddy.Duration `json:"init_timeout,omitempty"`
+
+	ctx     caddy.Context
+	logger  *zap.Logger
+	metrics storage.Metrics
+	events  *caddyevents.App
+	// eventQueue holds the storage events waiting to be emitted through events.
+	eventQueue chan storageEvent
+	// mu guards provisioned.
+	mu sync.Mutex
+	// provisioned is the storage that CertMagicStorage returns, created by the posture
+	// check or by the first call to CertMagicStorage.
+	provisioned *storage.Storage
+	// jobs starts the background jobs of provisioned once.
+	jobs sync.Once
+}
+
+// storageEvent is a storage event waiting in the event queue.
+type storageEvent struct {
+	data map[string]any
+	name string
+}
+
+// TierPolicyConfig configures the background access tier job.
+type TierPolicyConfig struct {
+	// Tier is the access tier to move inactive blobs to: hot, cool or cold.
+	Tier string `json:"tier"`
+	// Prefix limits the policy to keys with this prefix (optional).
+	Prefix string `json:"prefix,omitempty"`
+	// After is how long a blob must go unmodified before it is moved.
+	After caddy.Duration `json:"after"`
+	// Interval is how often the policy is applied. Defaults to 24h.
+	Interval caddy.Duration `json:"interval,omitempty"`
+}
+
+// CacheConfig configures the in-memory read-through cache.
+type CacheConfig struct {
+	// MaxEntries bounds the number of cached keys. Defaults to 1024.
+	MaxEntries int `json:"max_entries,omitempty"`
+	// TTL is how long entries are served before being revalidated. Defaults to 1m.
+	TTL caddy.Duration `json:"ttl,omitempty"`
+	// NegativeTTL is how long missing keys are remembered. Zero disables negative caching.
+	NegativeTTL caddy.Duration `json:"negative_ttl,omitempty"`
+}
+
+// AuditConfig configures the audit journal.
+type AuditConfig struct {
+	// Prefix is the key prefix of the journal blobs. Defaults to "audit/".
+	Prefix string `json:"prefix,omitempty"`
+	// Node identifies this instance in the journal. Defaults to the host name.
+	Node string `json:"node,omitempty"`
+}
+
+// RouteConfig stores the keys matching Prefixes in a separate container.
+type RouteConfig struct {
+	// Prefixes are the key prefixes routed to the container; they may contain wildcards.
+	Prefixes []string `json:"prefixes"`
+	// AccountName is the route's Azure Storage account name.
+	AccountName string `json:"account_name,omitempty"`
+	// ContainerName is the route's container name.
+	ContainerName string `json:"container_name"`
+	// ConnectionString is the route's connection string (optional).
+	ConnectionString string `json:"connection_string,omitempty"`
+}
+
+// SecondaryConfig configures dual-write replication to a secondary account.
+type SecondaryConfig struct {
+	// AccountName is the secondary Azure Storage account name.
+	AccountName string `json:"account_name,omitempty"`
+	// ContainerName is the secondary container name.
+	ContainerName string `json:"container_name"`
+	// ConnectionString is the secondary account's connection string (optional).
+	ConnectionString string `json:"connection_string,omitempty"`
+	// Async replicates writes in the background instead of as part of each write.
+	Async bool `json:"async,omitempty"`
+	// QueueSize bounds the number of pending async writes. Defaults to 1024.
+	QueueSize int `json:"queue_size,omitempty"`
+	// MaxAttempts is how often an async write is tried. Defaults to 5.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// RetryInterval is the wait between async attempts. Defaults to 5s.
+	RetryInterval caddy.Duration `json:"retry_interval,omitempty"`
+}
+
+// RetryConfig configures retries of failed Azure requests.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first try. Defaults to 3; -1
+	// disables retries.
+	MaxRetries int32 `json:"max_retries,omitempty"`
+	// Delay is the initial backoff between tries. Defaults to 800ms.
+	Delay caddy.Duration `json:"delay,omitempty"`
+	// MaxDelay caps the backoff between tries. Defaults to 60s.
+	MaxDelay caddy.Duration `json:"max_delay,omitempty"`
+	// TryTimeout bounds a single try of a request.
+	TryTimeout caddy.Duration `json:"try_timeout,omitempty"`
+}
+
+// TimeoutsConfig configures deadlines for storage operations that are applied when
+// Caddy does not set one itself.
+type TimeoutsConfig struct {
+	// Read bounds Load, Stat and Exists.
+	Read caddy.Duration `json:"read,omitempty"`
+	// Write bounds Store and Delete.
+	Write caddy.Duration `json:"write,omitempty"`
+	// List bounds List.
+	List caddy.Duration `json:"list,omitempty"`
+	// Lock bounds Lock, including waiting for another holder, Unlock and lease renewals.
+	Lock caddy.Duration `json:"lock,omitempty"`
+}
+
+// ThrottleConfig configures the adaptive limit on concurrent Azure requests.
+type ThrottleConfig struct {
+	// MaxConcurrency is the initial and largest number of concurrent requests.
+	MaxConcurrency int `json:"max_concurrency"`
+	// MinConcurrency is the smallest number of concurrent requests. Defaults to 1.
+	MinConcurrency int `json:"min_concurrency,omitempty"`
+}
+
+// TransportConfig configures the HTTP client used for Azure requests.
+type TransportConfig struct {
+	// ProxyURL is the HTTP(S) proxy to use. Defaults to the HTTPS_PROXY environment variable.
+	ProxyURL string `json:"proxy_url,omitempty"`
+	// CAFile is a PEM file of additional trusted CA certificates.
+	CAFile string `json:"ca_file,omitempty"`
+	// ClientCertFile is a PEM client certificate for mutual TLS.
+	ClientCertFile string `json:"client_cert_file,omitempty"`
+	// ClientKeyFile is the PEM key of ClientCertFile.
+	ClientKeyFile string `json:"client_key_file,omitempty"`
+	// MaxIdleConns bounds the idle connections kept open. Defaults to 100.
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+	// IdleConnTimeout is how long idle connections are kept open. Defaults to 90s.
+	IdleConnTimeout caddy.Duration `json:"idle_conn_timeout,omitempty"`
+	// DisableHTTP2 restricts requests to HTTP/1.1.
+	DisableHTTP2 bool `json:"disable_http2,omitempty"`
 }
 
+// defaultInitTimeout bounds creating the storage when no init timeout is configured.
+const defaultInitTimeout = 30 * time.Second
+
+// defaultTierInterval is how often the tier policy runs when no interval is configured.
+const defaultTierInterval = 24 * time.Hour
+
+// eventQueueSize bounds the storage events waiting to be emitted; further events are
+// dropped until the handlers catch up.
+const eventQueueSize = 256
+
 func init() {
-	caddy.RegisterModule(CaddyStorageAzureBlob{})
+	caddy.RegisterModule(new(CaddyStorageAzureBlob))
 }
 
 // CaddyModule returns the Caddy module information.
-func (CaddyStorageAzureBlob) CaddyModule() caddy.ModuleInfo {
+func (*CaddyStorageAzureBlob) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID: "caddy.storage.azureblob",
 		New: func() caddy.Module {
@@ -47,26 +258,280 @@ func (CaddyStorageAzureBlob) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
-// CertMagicStorage returns a cert-magic storage.
+// CertMagicStorage returns a cert-magic storage. Every call returns the same storage,
+// whose background jobs start with the first call.
 func (s *CaddyStorageAzureBlob) CertMagicStorage() (certmagic.Storage, error) {
-	config := storage.Config{
-		AccountName:      s.AccountName,
-		ContainerName:    s.ContainerName,
-		ConnectionString: s.ConnectionString,
-		Credential:       s.Credential,
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.provisioned == nil {
+		stor, err := s.newStorage()
+		if err != nil {
+			return nil, err
+		}
+		s.provisioned = stor
+	}
+	s.jobs.Do(func() { s.startJobs(s.provisioned) })
+	return s.provisioned, nil
+}
+
+// startJobs starts the background jobs of stor. They are bound to the module's
+// lifetime, so they only run once provisioned.
+func (s *CaddyStorageAzureBlob) startJobs(stor *storage.Storage) {
+	if s.ctx.Context == nil {
+		return
+	}
+	if s.TierPolicy != nil {
+		go s.runTierPolicy(stor)
 	}
+	if s.ConnectionStringFile != "" || s.AccountKeyFile != "" || s.SASTokenFile != "" {
+		go stor.WatchSecrets(s.ctx, time.Duration(s.SecretReloadInterval))
+	}
+	if s.Secondary != nil && s.Secondary.Async {
+		go stor.RunReplication(s.ctx)
+	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+// newStorage creates the underlying storage without starting any background jobs.
+func (s *CaddyStorageAzureBlob) newStorage() (*storage.Storage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.initTimeout())
 	defer cancel()
-	return storage.NewStorage(ctx, config)
+	return storage.NewStorage(ctx, s.storageConfig())
+}
+
+// initTimeout returns the configured init timeout or its default.
+func (s *CaddyStorageAzureBlob) initTimeout() time.Duration {
+	if s.InitTimeout <= 0 {
+		return defaultInitTimeout
+	}
+	return time.Duration(s.InitTimeout)
+}
+
+// storageConfig converts the module configuration to a storage.Config.
+func (s *CaddyStorageAzureBlob) storageConfig() storage.Config {
+	return storage.Config{
+		AccountName:           s.AccountName,
+		ContainerName:         s.ContainerName,
+		ConnectionString:      s.ConnectionString,
+		ConnectionStringFile:  s.ConnectionStringFile,
+		AccountKeyFile:        s.AccountKeyFile,
+		SASTokenFile:          s.SASTokenFile,
+		Credential:            s.Credential,
+		CreateContainer:       storage.ContainerCreation(strings.ToLower(s.CreateContainer)),
+		ContainerMetadata:     s.ContainerMetadata,
+		ContainerAccess:       containerAccess(s.ContainerPublicAccess),
+		Cache:                 s.Cache.storageConfig(),
+		MirrorDir:             s.MirrorDir,
+		Secondary:             s.Secondary.storageConfig(s.Credential),
+		ReadSecondaryEndpoint: s.ReadSecondaryEndpoint,
+		KeyCodec:              keyCodec(s.KeyEncoding),
+		Audit:                 s.Audit.storageConfig(),
+		Routes:                s.routes(),
+		ReadOnly:              s.ReadOnly,
+		ProtectedPrefixes:     s.ProtectedPrefixes,
+		RequireEntraAuth:      s.RequireEntraAuth,
+		PostureWriteProbe:     s.PostureWriteProbe,
+		Retry:                 s.Retry.storageConfig(),
+		Timeouts:              s.Timeouts.storageConfig(),
+		Transport:             s.Transport.storageConfig(),
+		Throttle:              s.Throttle.storageConfig(),
+		Logger:                s.logger,
+		Metrics:               s.metrics,
+		Events:                s.emitEvent,
+	}
+}
+
+// storageConfig converts the route configuration to a storage.RouteConfig.
+func (c RouteConfig) storageConfig(credential azcore.TokenCredential) storage.RouteConfig {
+	return storage.RouteConfig{
+		Prefixes:         c.Prefixes,
+		Credential:       credential,
+		AccountName:      c.AccountName,
+		ContainerName:    c.ContainerName,
+		ConnectionString: c.ConnectionString,
+	}
+}
+
+// storageConfig converts the audit configuration to a storage.AuditConfig.
+func (c *AuditConfig) storageConfig() *storage.AuditConfig {
+	if c == nil {
+		return nil
+	}
+	return &storage.AuditConfig{Prefix: c.Prefix, Node: c.Node}
+}
+
+// routes converts the route configurations to storage.RouteConfigs.
+func (s *CaddyStorageAzureBlob) routes() []storage.RouteConfig {
+	var routes []storage.RouteConfig
+	for _, route := range s.Routes {
+		routes = append(routes, route.storageConfig(s.Credential))
+	}
+	return routes
+}
+
+// storageConfig converts the secondary configuration to a storage.SecondaryConfig.
+func (c *SecondaryConfig) storageConfig(credential azcore.TokenCredential) *storage.SecondaryConfig {
+	if c == nil {
+		return nil
+	}
+	return &storage.SecondaryConfig{
+		Credential:       credential,
+		AccountName:      c.AccountName,
+		ContainerName:    c.ContainerName,
+		ConnectionString: c.ConnectionString,
+		Async:            c.Async,
+		QueueSize:        c.QueueSize,
+		MaxAttempts:      c.MaxAttempts,
+		RetryInterval:    time.Duration(c.RetryInterval),
+	}
+}
+
+// storageConfig converts the retry configuration to a storage.RetryConfig.
+func (c *RetryConfig) storageConfig() storage.RetryConfig {
+	if c == nil {
+		return storage.RetryConfig{}
+	}
+	return storage.RetryConfig{
+		MaxRetries:    c.MaxRetries,
+		RetryDelay:    time.Duration(c.Delay),
+		MaxRetryDelay: time.Duration(c.MaxDelay),
+		TryTimeout:    time.Duration(c.TryTimeout),
+	}
+}
+
+// storageConfig converts the timeouts configuration to storage.Timeouts.
+func (c *TimeoutsConfig) storageConfig() storage.Timeouts {
+	if c == nil {
+		return storage.Timeouts{}
+	}
+	return storage.Timeouts{
+		Read:  time.Duration(c.Read),
+		Write: time.Duration(c.Write),
+		List:  time.Duration(c.List),
+		Lock:  time.Duration(c.Lock),
+	}
+}
+
+// storageConfig converts the throttle configuration to a storage.ThrottleConfig.
+func (c *ThrottleConfig) storageConfig() *storage.ThrottleConfig {
+	if c == nil {
+		return nil
+	}
+	return &storage.ThrottleConfig{
+		MaxConcurrency: c.MaxConcurrency,
+		MinConcurrency: c.MinConcurrency,
+	}
+}
+
+// storageConfig converts the transport configuration to a storage.TransportConfig.
+func (c *TransportConfig) storageConfig() *storage.TransportConfig {
+	if c == nil {
+		return nil
+	}
+	return &storage.TransportConfig{
+		ProxyURL:        c.ProxyURL,
+		CAFile:          c.CAFile,
+		ClientCertFile:  c.ClientCertFile,
+		ClientKeyFile:   c.ClientKeyFile,
+		MaxIdleConns:    c.MaxIdleConns,
+		IdleConnTimeout: time.Duration(c.IdleConnTimeout),
+		DisableHTTP2:    c.DisableHTTP2,
+	}
+}
+
+// storageConfig converts the cache configuration to a storage.CacheConfig.
+func (c *CacheConfig) storageConfig() *storage.CacheConfig {
+	if c == nil {
+		return nil
+	}
+	return &storage.CacheConfig{
+		MaxEntries:  c.MaxEntries,
+		TTL:         time.Duration(c.TTL),
+		NegativeTTL: time.Duration(c.NegativeTTL),
+	}
 }
 
 // Provision sets up the Azure Blob Storage module and validates configuration.
-// The caddy.Context parameter is required by the caddy.Provisioner interface but is
-// intentionally unused here — Provision currently only validates static config fields.
-// If future changes need logging or module loading, rename _ back to ctx.
-func (s *CaddyStorageAzureBlob) Provision(_ caddy.Context) error {
-	return s.Validate()
+func (s *CaddyStorageAzureBlob) Provision(ctx caddy.Context) error {
+	s.ctx = ctx
+	s.logger = ctx.Logger()
+	if registry := ctx.GetMetricsRegistry(); registry != nil {
+		metrics, err := newPrometheusMetrics(registry)
+		if err != nil {
+			return fmt.Errorf("registering metrics: %w", err)
+		}
+		s.metrics = metrics
+	}
+	eventsApp, err := ctx.App("events")
+	if err != nil {
+		return fmt.Errorf("getting events app: %w", err)
+	}
+	s.events = eventsApp.(*caddyevents.App)
+	s.eventQueue = make(chan storageEvent, eventQueueSize)
+	go s.runEvents()
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	return s.checkPosture()
+}
+
+// checkPosture logs the failed and unknown checks of the storage's security posture if
+// posture_check is warn or fail, and returns an error for failed checks if it is fail.
+// The storage it checks is kept for CertMagicStorage.
+func (s *CaddyStorageAzureBlob) checkPosture() error {
+	mode := strings.ToLower(s.PostureCheck)
+	if mode == "" || mode == "off" {
+		return nil
+	}
+	stor, err := s.newStorage()
+	if err != nil {
+		if mode == "fail" {
+			return fmt.Errorf("storage posture check: %w", err)
+		}
+		s.logger.Warn("storage posture check could not run", zap.Error(err))
+		return nil
+	}
+	s.mu.Lock()
+	s.provisioned = stor
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(s.ctx, s.initTimeout())
+	defer cancel()
+	posture := stor.CheckPosture(ctx)
+	for _, check := range posture.Checks {
+		if check.Status != storage.PosturePass {
+			s.logger.Warn("storage posture check did not pass", zap.String("container", check.Container),
+				zap.String("check", check.Name), zap.String("status", string(check.Status)), zap.String("detail", check.Detail))
+		}
+	}
+	if mode == "fail" && posture.Failed() {
+		return fmt.Errorf("storage posture check: %w", posture.Err())
+	}
+	return nil
+}
+
+// emitEvent queues a storage event for Caddy's events app once the module is
+// provisioned. Event handlers run synchronously, so emitting the event directly would
+// hold up the storage operation; a full queue drops the event instead.
+func (s *CaddyStorageAzureBlob) emitEvent(name string, data map[string]any) {
+	if s.eventQueue == nil {
+		return
+	}
+	select {
+	case s.eventQueue <- storageEvent{name: name, data: data}:
+	default:
+		s.logger.Warn("storage event queue is full; dropping event", zap.String("event", name))
+	}
+}
+
+// runEvents emits the queued storage events in order until the module's context is done.
+func (s *CaddyStorageAzureBlob) runEvents() {
+	for {
+		select {
+		case event := <-s.eventQueue:
+			s.events.Emit(s.ctx, event.name, event.data)
+		case <-s.ctx.Done():
+			return
+		}
+	}
 }
 
 // Validate Azure Blob Storage configuration.
@@ -77,9 +542,178 @@ func (s *CaddyStorageAzureBlob) Validate() error {
 	if s.ContainerName == "" {
 		return fmt.Errorf("container name must be defined")
 	}
+	var secretSources int
+	for _, source := range []string{s.ConnectionString, s.ConnectionStringFile, s.AccountKeyFile, s.SASTokenFile} {
+		if source != "" {
+			secretSources++
+		}
+	}
+	if secretSources > 1 {
+		return fmt.Errorf("only one of connection_string, connection_string_file, account_key_file and sas_token_file may be defined")
+	}
+	if s.RequireEntraAuth && secretSources > 0 {
+		return fmt.Errorf("require_entra_auth cannot be used with connection_string, connection_string_file, account_key_file or sas_token_file")
+	}
+	switch strings.ToLower(s.PostureCheck) {
+	case "", "off", "warn", "fail":
+	default:
+		return fmt.Errorf("posture_check must be off, warn or fail, got '%s'", s.PostureCheck)
+	}
+	switch storage.ContainerCreation(strings.ToLower(s.CreateContainer)) {
+	case "", storage.CreateContainerAuto, storage.CreateContainerAlways, storage.CreateContainerNever:
+	default:
+		return fmt.Errorf("create_container must be auto, always or never, got '%s'", s.CreateContainer)
+	}
+	switch strings.ToLower(s.ContainerPublicAccess) {
+	case "", "off", "blob", "container":
+	default:
+		return fmt.Errorf("container_public_access must be off, blob or container, got '%s'", s.ContainerPublicAccess)
+	}
+	if s.Throttle != nil {
+		if s.Throttle.MaxConcurrency <= 0 {
+			return fmt.Errorf("max_concurrency must be positive")
+		}
+		if s.Throttle.MinConcurrency > s.Throttle.MaxConcurrency {
+			return fmt.Errorf("min_concurrency must not exceed max_concurrency")
+		}
+	}
+	if s.Transport != nil && (s.Transport.ClientCertFile == "") != (s.Transport.ClientKeyFile == "") {
+		return fmt.Errorf("client_cert_file and client_key_file must be defined together")
+	}
+	if keyCodec(s.KeyEncoding) == nil {
+		return fmt.Errorf("key_encoding must be plain or escaped, got '%s'", s.KeyEncoding)
+	}
+	if s.Secondary != nil {
+		if s.Secondary.AccountName == "" && s.Secondary.ConnectionString == "" {
+			return fmt.Errorf("secondary account name or connection string must be defined")
+		}
+		if s.Secondary.ContainerName == "" {
+			return fmt.Errorf("secondary container name must be defined")
+		}
+		if s.RequireEntraAuth && s.Secondary.ConnectionString != "" {
+			return fmt.Errorf("require_entra_auth cannot be used with secondary_connection_string")
+		}
+	}
+	if s.TierPolicy != nil {
+		if _, err := s.TierPolicy.policy(); err != nil {
+			return err
+		}
+		if s.ReadOnly {
+			return fmt.Errorf("tier_policy cannot be used with read_only")
+		}
+	}
+	for _, route := range s.Routes {
+		if len(route.Prefixes) == 0 {
+			return fmt.Errorf("route prefixes must be defined")
+		}
+		if route.ContainerName == "" {
+			return fmt.Errorf("route container name must be defined")
+		}
+		if route.AccountName == "" && route.ConnectionString == "" {
+			return fmt.Errorf("route account name or connection string must be defined")
+		}
+		if s.RequireEntraAuth && route.ConnectionString != "" {
+			return fmt.Errorf("require_entra_auth cannot be used with a route connection_string")
+		}
+		for _, prefix := range route.Prefixes {
+			if _, err := path.Match(prefix, ""); err != nil {
+				return fmt.Errorf("invalid route prefix '%s': %w", prefix, err)
+			}
+		}
+	}
+	for _, prefix := range s.ProtectedPrefixes {
+		if _, err := path.Match(prefix, ""); err != nil {
+			return fmt.Errorf("invalid protected prefix '%s': %w", prefix, err)
+		}
+	}
 	return nil
 }
 
+// keyCodec returns the codec for a key_encoding value, or nil if it is unknown.
+func keyCodec(encoding string) storage.KeyCodec {
+	switch strings.ToLower(encoding) {
+	case "", "plain":
+		return storage.PlainKeys{}
+	case "escaped":
+		return storage.EscapedKeys{}
+	default:
+		return nil
+	}
+}
+
+// containerAccess returns the public access type of a container_public_access value;
+// empty means private.
+func containerAccess(access string) container.PublicAccessType {
+	switch strings.ToLower(access) {
+	case "blob":
+		return container.PublicAccessTypeBlob
+	case "container":
+		return container.PublicAccessTypeContainer
+	default:
+		return ""
+	}
+}
+
+// policy converts the module configuration to a storage.TierPolicy.
+func (c *TierPolicyConfig) policy() (storage.TierPolicy, error) {
+	var tier blob.AccessTier
+	switch strings.ToLower(c.Tier) {
+	case "hot":
+		tier = blob.AccessTierHot
+	case "cool":
+		tier = blob.AccessTierCool
+	case "cold":
+		tier = blob.AccessTierCold
+	default:
+		return storage.TierPolicy{}, fmt.Errorf("tier must be one of hot, cool or cold, got '%s'", c.Tier)
+	}
+	p := storage.TierPolicy{
+		Tier:   tier,
+		Prefix: c.Prefix,
+		MinAge: time.Duration(c.After),
+	}
+	return p, p.Validate()
+}
+
+// runTierPolicy applies the configured tier policy immediately and then on every
+// interval until the module is unloaded.
+func (s *CaddyStorageAzureBlob) runTierPolicy(stor *storage.Storage) {
+	policy, err := s.TierPolicy.policy()
+	if err != nil {
+		s.logger.Error("invalid tier policy", zap.Error(err))
+		return
+	}
+	interval := time.Duration(s.TierPolicy.Interval)
+	if interval <= 0 {
+		interval = defaultTierInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := stor.ApplyTierPolicy(s.ctx, policy)
+		if err != nil {
+			s.logger.Error("applying tier policy", zap.String("prefix", policy.Prefix), zap.Error(err))
+		}
+		if len(report.Moved) > 0 {
+			s.logger.Info("moved inactive blobs to new access tier",
+				zap.String("tier", string(policy.Tier)),
+				zap.Int("count", len(report.Moved)))
+		}
+		if len(report.Archived) > 0 {
+			s.logger.Warn("blobs in archive tier cannot be loaded until rehydrated",
+				zap.Strings("keys", report.Archived))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
 // Unmarshall caddy file.
 func (s *CaddyStorageAzureBlob) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
@@ -97,9 +731,289 @@ func (s *CaddyStorageAzureBlob) UnmarshalCaddyfile(d *caddyfile.Dispenser) error
 			s.ContainerName = value
 		case "connection_string":
 			s.ConnectionString = value
+		case "connection_string_file":
+			s.ConnectionStringFile = value
+		case "account_key_file":
+			s.AccountKeyFile = value
+		case "sas_token_file":
+			s.SASTokenFile = value
+		case "create_container":
+			s.CreateContainer = value
+		case "container_metadata":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if s.ContainerMetadata == nil {
+				s.ContainerMetadata = map[string]string{}
+			}
+			s.ContainerMetadata[value] = d.Val()
+		case "container_public_access":
+			s.ContainerPublicAccess = value
+		case "secret_reload_interval":
+			if err := parseDuration(d, key, value, &s.SecretReloadInterval); err != nil {
+				return err
+			}
+		case "tier":
+			s.tierPolicy().Tier = value
+		case "tier_prefix":
+			s.tierPolicy().Prefix = value
+		case "tier_after":
+			if err := parseDuration(d, key, value, &s.tierPolicy().After); err != nil {
+				return err
+			}
+		case "tier_interval":
+			if err := parseDuration(d, key, value, &s.tierPolicy().Interval); err != nil {
+				return err
+			}
+		case "mirror_dir":
+			s.MirrorDir = value
+		case "max_retries":
+			n, err := strconv.ParseInt(value, 10, 32)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.retry().MaxRetries = int32(n)
+		case "retry_delay":
+			if err := parseDuration(d, key, value, &s.retry().Delay); err != nil {
+				return err
+			}
+		case "max_retry_delay":
+			if err := parseDuration(d, key, value, &s.retry().MaxDelay); err != nil {
+				return err
+			}
+		case "try_timeout":
+			if err := parseDuration(d, key, value, &s.retry().TryTimeout); err != nil {
+				return err
+			}
+		case "read_timeout":
+			if err := parseDuration(d, key, value, &s.timeouts().Read); err != nil {
+				return err
+			}
+		case "write_timeout":
+			if err := parseDuration(d, key, value, &s.timeouts().Write); err != nil {
+				return err
+			}
+		case "list_timeout":
+			if err := parseDuration(d, key, value, &s.timeouts().List); err != nil {
+				return err
+			}
+		case "lock_timeout":
+			if err := parseDuration(d, key, value, &s.timeouts().Lock); err != nil {
+				return err
+			}
+		case "max_concurrency":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.throttle().MaxConcurrency = n
+		case "min_concurrency":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.throttle().MinConcurrency = n
+		case "proxy_url":
+			s.transport().ProxyURL = value
+		case "ca_file":
+			s.transport().CAFile = value
+		case "client_cert_file":
+			s.transport().ClientCertFile = value
+		case "client_key_file":
+			s.transport().ClientKeyFile = value
+		case "max_idle_conns":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.transport().MaxIdleConns = n
+		case "idle_conn_timeout":
+			if err := parseDuration(d, key, value, &s.transport().IdleConnTimeout); err != nil {
+				return err
+			}
+		case "disable_http2":
+			disabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.transport().DisableHTTP2 = disabled
+		case "init_timeout":
+			if err := parseDuration(d, key, value, &s.InitTimeout); err != nil {
+				return err
+			}
+		case "key_encoding":
+			s.KeyEncoding = value
+		case "require_entra_auth":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.RequireEntraAuth = enabled
+		case "posture_check":
+			s.PostureCheck = value
+		case "posture_write_probe":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.PostureWriteProbe = enabled
+		case "read_only":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.ReadOnly = enabled
+		case "protected_prefix":
+			s.ProtectedPrefixes = append(s.ProtectedPrefixes, value)
+		case "audit_prefix":
+			s.audit().Prefix = value
+		case "audit_node":
+			s.audit().Node = value
+		case "route":
+			route := RouteConfig{Prefixes: append([]string{value}, d.RemainingArgs()...)}
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				option := d.Val()
+				var optionValue string
+				if !d.Args(&optionValue) {
+					return d.ArgErr()
+				}
+				switch option {
+				case "account_name":
+					route.AccountName = optionValue
+				case "container_name":
+					route.ContainerName = optionValue
+				case "connection_string":
+					route.ConnectionString = optionValue
+				default:
+					return d.Errf("unknown route option '%s'", option)
+				}
+			}
+			s.Routes = append(s.Routes, route)
+		case "read_secondary_endpoint":
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.ReadSecondaryEndpoint = enabled
+		case "secondary_account_name":
+			s.secondary().AccountName = value
+		case "secondary_container_name":
+			s.secondary().ContainerName = value
+		case "secondary_connection_string":
+			s.secondary().ConnectionString = value
+		case "secondary_async":
+			async, err := strconv.ParseBool(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.secondary().Async = async
+		case "secondary_queue_size":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.secondary().QueueSize = n
+		case "secondary_max_attempts":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.secondary().MaxAttempts = n
+		case "secondary_retry_interval":
+			if err := parseDuration(d, key, value, &s.secondary().RetryInterval); err != nil {
+				return err
+			}
+		case "cache_max_entries":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return d.Errf("parsing %s: %v", key, err)
+			}
+			s.cache().MaxEntries = n
+		case "cache_ttl":
+			if err := parseDuration(d, key, value, &s.cache().TTL); err != nil {
+				return err
+			}
+		case "cache_negative_ttl":
+			if err := parseDuration(d, key, value, &s.cache().NegativeTTL); err != nil {
+				return err
+			}
 		default:
 			return d.Errf("unrecognised option '%s'", key)
 		}
 	}
 	return nil
 }
+
+// tierPolicy returns the tier policy config, creating it on first use.
+func (s *CaddyStorageAzureBlob) tierPolicy() *TierPolicyConfig {
+	if s.TierPolicy == nil {
+		s.TierPolicy = new(TierPolicyConfig)
+	}
+	return s.TierPolicy
+}
+
+// audit returns the audit config, creating it on first use.
+func (s *CaddyStorageAzureBlob) audit() *AuditConfig {
+	if s.Audit == nil {
+		s.Audit = new(AuditConfig)
+	}
+	return s.Audit
+}
+
+// secondary returns the secondary config, creating it on first use.
+func (s *CaddyStorageAzureBlob) secondary() *SecondaryConfig {
+	if s.Secondary == nil {
+		s.Secondary = new(SecondaryConfig)
+	}
+	return s.Secondary
+}
+
+// retry returns the retry config, creating it on first use.
+func (s *CaddyStorageAzureBlob) retry() *RetryConfig {
+	if s.Retry == nil {
+		s.Retry = new(RetryConfig)
+	}
+	return s.Retry
+}
+
+// timeouts returns the timeouts config, creating it on first use.
+func (s *CaddyStorageAzureBlob) timeouts() *TimeoutsConfig {
+	if s.Timeouts == nil {
+		s.Timeouts = new(TimeoutsConfig)
+	}
+	return s.Timeouts
+}
+
+// throttle returns the throttle config, creating it on first use.
+func (s *CaddyStorageAzureBlob) throttle() *ThrottleConfig {
+	if s.Throttle == nil {
+		s.Throttle = new(ThrottleConfig)
+	}
+	return s.Throttle
+}
+
+// transport returns the transport config, creating it on first use.
+func (s *CaddyStorageAzureBlob) transport() *TransportConfig {
+	if s.Transport == nil {
+		s.Transport = new(TransportConfig)
+	}
+	return s.Transport
+}
+
+// cache returns the cache config, creating it on first use.
+func (s *CaddyStorageAzureBlob) cache() *CacheConfig {
+	if s.Cache == nil {
+		s.Cache = new(CacheConfig)
+	}
+	return s.Cache
+}
+
+// parseDuration parses the Caddyfile duration value of option key into dur.
+func parseDuration(d *caddyfile.Dispenser, key, value string, dur *caddy.Duration) error {
+	parsed, err := caddy.ParseDuration(value)
+	if err != nil {
+		return d.Errf("parsing %s duration: %v", key, err)
+	}
+	*dur = caddy.Duration(parsed)
+	return nil
+}
@@ -0,0 +1,475 @@
+package certmagicazureblob
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webedmj/certmagic-azureblob/storage"
+	"github.com/webedmj/certmagic-azureblob/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		connection_string "UseDevelopmentStorage=true"
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	assert.Equal(t, "myaccount", s.AccountName)
+	assert.Equal(t, "caddy-data", s.ContainerName)
+	assert.Equal(t, "UseDevelopmentStorage=true", s.ConnectionString)
+	assert.Nil(t, s.TierPolicy, "Tier policy should only be set when configured")
+	require.NoError(t, s.Validate())
+}
+
+func TestUnmarshalCaddyfileUnknownOption(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		not_an_option value
+	}`)
+
+	var s CaddyStorageAzureBlob
+	assert.Error(t, s.UnmarshalCaddyfile(d))
+}
+
+func TestUnmarshalCaddyfileTierPolicy(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		tier cool
+		tier_prefix certificates/
+		tier_after 30d
+		tier_interval 12h
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NotNil(t, s.TierPolicy)
+	assert.Equal(t, "cool", s.TierPolicy.Tier)
+	assert.Equal(t, "certificates/", s.TierPolicy.Prefix)
+	assert.Equal(t, caddy.Duration(30*24*time.Hour), s.TierPolicy.After)
+	assert.Equal(t, caddy.Duration(12*time.Hour), s.TierPolicy.Interval)
+	require.NoError(t, s.Validate())
+
+	s.TierPolicy.Tier = "archive"
+	assert.Error(t, s.Validate(), "Archive tier should be rejected")
+}
+
+func TestLoadCommandStorageRejectsOtherModules(t *testing.T) {
+	dir := t.TempDir()
+	cases := map[string]string{
+		"no storage":   `{"apps":{}}`,
+		"file storage": `{"storage":{"module":"file_system","root":"` + dir + `"}}`,
+		"invalid":      `{"storage":{"module":"azureblob","account_name":"myaccount"}}`,
+	}
+
+	for name, config := range cases {
+		t.Run(name, func(t *testing.T) {
+			configFile := filepath.Join(dir, "caddy.json")
+			require.NoError(t, os.WriteFile(configFile, []byte(config), 0o600))
+
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			fs.String("config", configFile, "")
+			fs.String("adapter", "", "")
+
+			_, err := loadCommandStorage(caddycmd.Flags{FlagSet: fs})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestUnmarshalCaddyfileCache(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		cache_max_entries 500
+		cache_ttl 30s
+		cache_negative_ttl 5s
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NotNil(t, s.Cache)
+
+	cache := s.storageConfig().Cache
+	require.NotNil(t, cache)
+	assert.Equal(t, 500, cache.MaxEntries)
+	assert.Equal(t, 30*time.Second, cache.TTL)
+	assert.Equal(t, 5*time.Second, cache.NegativeTTL)
+
+	d = caddyfile.NewTestDispenser(`azureblob {
+		cache_max_entries lots
+	}`)
+	assert.Error(t, s.UnmarshalCaddyfile(d))
+}
+
+func TestUnmarshalCaddyfileMirrorDir(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		mirror_dir /var/lib/caddy/azureblob-mirror
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	assert.Equal(t, "/var/lib/caddy/azureblob-mirror", s.storageConfig().MirrorDir)
+}
+
+func TestUnmarshalCaddyfileSecretFiles(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		sas_token_file /run/secrets/azure-sas
+		secret_reload_interval 5m
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	assert.Equal(t, "/run/secrets/azure-sas", s.storageConfig().SASTokenFile)
+	assert.Equal(t, caddy.Duration(5*time.Minute), s.SecretReloadInterval)
+	require.NoError(t, s.Validate())
+
+	s.ConnectionString = "UseDevelopmentStorage=true"
+	assert.Error(t, s.Validate(), "Only one credential source may be configured")
+}
+
+func TestUnmarshalCaddyfileSecondary(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		secondary_account_name mybackup
+		secondary_container_name caddy-data-dr
+		secondary_async true
+		secondary_retry_interval 10s
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+
+	secondary := s.storageConfig().Secondary
+	require.NotNil(t, secondary)
+	assert.Equal(t, "mybackup", secondary.AccountName)
+	assert.Equal(t, "caddy-data-dr", secondary.ContainerName)
+	assert.True(t, secondary.Async)
+	assert.Equal(t, 10*time.Second, secondary.RetryInterval)
+
+	s.Secondary.ContainerName = ""
+	assert.Error(t, s.Validate(), "The secondary container name is required")
+}
+
+func TestUnmarshalCaddyfileReadSecondaryEndpoint(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		read_secondary_endpoint true
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	assert.True(t, s.storageConfig().ReadSecondaryEndpoint)
+}
+
+func TestKeyEncoding(t *testing.T) {
+	s := CaddyStorageAzureBlob{AccountName: "myaccount", ContainerName: "caddy-data"}
+	assert.Equal(t, storage.PlainKeys{}, s.storageConfig().KeyCodec)
+
+	s.KeyEncoding = "escaped"
+	require.NoError(t, s.Validate())
+	assert.Equal(t, storage.EscapedKeys{}, s.storageConfig().KeyCodec)
+
+	s.KeyEncoding = "base64"
+	assert.Error(t, s.Validate())
+}
+
+func TestUnmarshalCaddyfileRetryAndTimeouts(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		max_retries 5
+		retry_delay 2s
+		try_timeout 20s
+		read_timeout 10s
+		lock_timeout 5m
+		init_timeout 1m
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	config := s.storageConfig()
+	assert.Equal(t, storage.RetryConfig{MaxRetries: 5, RetryDelay: 2 * time.Second, TryTimeout: 20 * time.Second}, config.Retry)
+	assert.Equal(t, storage.Timeouts{Read: 10 * time.Second, Lock: 5 * time.Minute}, config.Timeouts)
+	assert.Equal(t, caddy.Duration(time.Minute), s.InitTimeout)
+}
+
+func TestUnmarshalCaddyfileTransport(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		proxy_url http://proxy.internal:3128
+		ca_file /etc/ssl/private-ca.pem
+		max_idle_conns 20
+		disable_http2 true
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+	assert.Equal(t, &storage.TransportConfig{
+		ProxyURL:     "http://proxy.internal:3128",
+		CAFile:       "/etc/ssl/private-ca.pem",
+		MaxIdleConns: 20,
+		DisableHTTP2: true,
+	}, s.storageConfig().Transport)
+
+	s.Transport.ClientCertFile = "/etc/ssl/client.pem"
+	assert.Error(t, s.Validate(), "A client certificate requires a key")
+}
+
+func TestUnmarshalCaddyfileThrottle(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		max_concurrency 32
+		min_concurrency 4
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+	assert.Equal(t, &storage.ThrottleConfig{MaxConcurrency: 32, MinConcurrency: 4}, s.storageConfig().Throttle)
+
+	s.Throttle.MinConcurrency = 64
+	assert.Error(t, s.Validate())
+}
+
+func TestUnmarshalCaddyfileWriteProtection(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		read_only true
+		protected_prefix acme/*/users/
+		protected_prefix certificates/
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+	config := s.storageConfig()
+	assert.True(t, config.ReadOnly)
+	assert.Equal(t, []string{"acme/*/users/", "certificates/"}, config.ProtectedPrefixes)
+
+	s.ProtectedPrefixes = append(s.ProtectedPrefixes, "acme/[")
+	assert.Error(t, s.Validate())
+}
+
+func TestUnmarshalCaddyfileContainerCreation(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		create_container never
+		container_metadata owner platform-team
+		container_metadata purpose tls
+		container_public_access off
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+	config := s.storageConfig()
+	assert.Equal(t, storage.CreateContainerNever, config.CreateContainer)
+	assert.Equal(t, map[string]string{"owner": "platform-team", "purpose": "tls"}, config.ContainerMetadata)
+	assert.Empty(t, config.ContainerAccess)
+
+	s.ContainerPublicAccess = "blob"
+	require.NoError(t, s.Validate())
+	assert.Equal(t, container.PublicAccessTypeBlob, s.storageConfig().ContainerAccess)
+
+	s.CreateContainer = "sometimes"
+	assert.Error(t, s.Validate())
+	s.CreateContainer = "always"
+	s.ContainerPublicAccess = "everyone"
+	assert.Error(t, s.Validate())
+}
+
+func TestUnmarshalCaddyfilePosture(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		require_entra_auth true
+		posture_check fail
+		posture_write_probe true
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+	assert.Equal(t, "fail", s.PostureCheck)
+	assert.True(t, s.storageConfig().RequireEntraAuth)
+	assert.True(t, s.storageConfig().PostureWriteProbe)
+
+	s.SASTokenFile = "/run/secrets/sas"
+	assert.Error(t, s.Validate(), "A SAS token should not be allowed with require_entra_auth")
+	s.SASTokenFile = ""
+	s.PostureCheck = "strict"
+	assert.Error(t, s.Validate())
+}
+
+// Test that require_entra_auth also rejects the connection strings of routes and the
+// secondary, which would otherwise authenticate with a shared key.
+func TestRequireEntraAuthRejectsAccountConnectionStrings(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		require_entra_auth true
+		route acme/*/users/ {
+			container_name caddy-keys
+			connection_string "UseDevelopmentStorage=true"
+		}
+	}`)
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	assert.ErrorContains(t, s.Validate(), "require_entra_auth")
+	s.Routes[0].ConnectionString = ""
+	s.Routes[0].AccountName = "keysaccount"
+	require.NoError(t, s.Validate())
+
+	d = caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		require_entra_auth true
+		secondary_container_name caddy-replica
+		secondary_connection_string "UseDevelopmentStorage=true"
+	}`)
+	s = CaddyStorageAzureBlob{}
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	assert.ErrorContains(t, s.Validate(), "require_entra_auth")
+	s.Secondary.ConnectionString = ""
+	s.Secondary.AccountName = "replicaaccount"
+	require.NoError(t, s.Validate())
+}
+
+func TestUnmarshalCaddyfileRoutes(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-public
+		route acme/*/users/ certificates/*/*.key {
+			account_name keysaccount
+			container_name caddy-keys
+		}
+		read_only false
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+	assert.Equal(t, "caddy-public", s.ContainerName)
+	assert.Equal(t, []storage.RouteConfig{{
+		Prefixes:      []string{"acme/*/users/", "certificates/*/*.key"},
+		AccountName:   "keysaccount",
+		ContainerName: "caddy-keys",
+	}}, s.storageConfig().Routes)
+
+	s.Routes[0].ContainerName = ""
+	assert.Error(t, s.Validate())
+
+	d = caddyfile.NewTestDispenser(`azureblob {
+		route acme/ {
+			container caddy-keys
+		}
+	}`)
+	assert.Error(t, s.UnmarshalCaddyfile(d))
+}
+
+func TestUnmarshalCaddyfileAudit(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`azureblob {
+		account_name myaccount
+		container_name caddy-data
+		audit_prefix journal/
+		audit_node edge-1
+	}`)
+
+	var s CaddyStorageAzureBlob
+	require.NoError(t, s.UnmarshalCaddyfile(d))
+	require.NoError(t, s.Validate())
+	assert.Equal(t, &storage.AuditConfig{Prefix: "journal/", Node: "edge-1"}, s.storageConfig().Audit)
+}
+
+// Test that emitting an event never blocks the storage operation, even while the
+// event handlers are behind.
+func TestEmitEventDoesNotBlock(t *testing.T) {
+	s := &CaddyStorageAzureBlob{logger: zap.NewNop()}
+	s.emitEvent(storage.EventStored, map[string]any{"key": "unprovisioned"})
+
+	s.eventQueue = make(chan storageEvent, 1)
+	s.emitEvent(storage.EventStored, map[string]any{"key": "queued"})
+	s.emitEvent(storage.EventStored, map[string]any{"key": "dropped"})
+	require.Len(t, s.eventQueue, 1)
+	assert.Equal(t, "queued", (<-s.eventQueue).data["key"])
+}
+
+// Test that the storage checked at provision time is the one handed to certmagic, so
+// that the posture check does not create a second storage.
+func TestPostureCheckStorageIsReused(t *testing.T) {
+	server := storagetest.NewServer()
+	t.Cleanup(server.Close)
+	s := &CaddyStorageAzureBlob{
+		AccountName:      storagetest.AccountName,
+		ContainerName:    "caddy",
+		ConnectionString: server.ConnectionString(),
+		PostureCheck:     "warn",
+		ctx:              caddy.Context{Context: context.Background()},
+		logger:           zap.NewNop(),
+	}
+	require.NoError(t, s.checkPosture())
+	provisioned := s.provisioned
+	require.NotNil(t, provisioned)
+
+	stor, err := s.CertMagicStorage()
+	require.NoError(t, err)
+	assert.Same(t, provisioned, stor)
+	stor, err = s.CertMagicStorage()
+	require.NoError(t, err)
+	assert.Same(t, provisioned, stor, "Every call should return the provisioned storage")
+}
+
+// Test that the background jobs start once, however often certmagic asks for the storage.
+func TestCertMagicStorageStartsJobsOnce(t *testing.T) {
+	server := storagetest.NewServer()
+	t.Cleanup(server.Close)
+	file := filepath.Join(t.TempDir(), "connection-string")
+	require.NoError(t, os.WriteFile(file, []byte(server.ConnectionString()), 0o600))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s := &CaddyStorageAzureBlob{
+		AccountName:          storagetest.AccountName,
+		ContainerName:        "caddy",
+		ConnectionStringFile: file,
+		ctx:                  caddy.Context{Context: ctx},
+		logger:               zap.NewNop(),
+	}
+
+	first, err := s.CertMagicStorage()
+	require.NoError(t, err)
+	goroutines := runtime.NumGoroutine()
+	for range 10 {
+		stor, err := s.CertMagicStorage()
+		require.NoError(t, err)
+		assert.Same(t, first, stor)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), goroutines, "Later calls should not start more jobs")
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultAuditPrefix is the key prefix of audit journal blobs when AuditConfig.Prefix
+	// is empty.
+	DefaultAuditPrefix = "audit/"
+	// auditTimeout bounds writing one audit record, which also happens after the
+	// operation's context is done.
+	auditTimeout = 30 * time.Second
+)
+
+// Audit operations recorded in AuditRecord.Op.
+const (
+	AuditStore       = "store"
+	AuditDelete      = "delete"
+	AuditLock        = "lock"
+	AuditUnlock      = "unlock"
+	AuditForceUnlock = "force_unlock"
+	AuditUndelete    = "undelete"
+)
+
+// AuditConfig enables the audit journal: every Store, Delete, Undelete, Lock, Unlock
+// and ForceUnlock appends an AuditRecord as a JSON line to an append blob per UTC day,
+// named <Prefix><YYYY-MM-DD>.jsonl. When that blob reaches the append blob limit of
+// 50,000 blocks, the records continue in <Prefix><YYYY-MM-DD>.1.jsonl, .2.jsonl and so
+// on. Audit blobs cannot be stored over or deleted through the Storage. Nothing is
+// recorded in read-only mode.
+type AuditConfig struct {
+	// Prefix is the key prefix of the audit blobs. Defaults to DefaultAuditPrefix (optional)
+	Prefix string
+	// Node identifies this instance in the records. Defaults to the host name (optional)
+	Node string
+}
+
+// AuditRecord is one line of the audit journal.
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	Node string    `json:"node"`
+	Op   string    `json:"op"`
+	Key  string    `json:"key"`
+	// Keys are the keys actually deleted or restored when Key was a directory
+	Keys []string `json:"keys,omitempty"`
+	// Size and SHA256 describe the stored value
+	Size   int    `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Outcome is "success" or "failure"; Error holds the reason for a failure
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// audit is the audit journal of a Storage.
+type audit struct {
+	prefix string
+	node   string
+
+	// mu guards day and part, the journal blob that records are appended to.
+	mu   sync.Mutex
+	day  string
+	part int
+}
+
+func newAudit(config *AuditConfig) *audit {
+	a := &audit{prefix: config.Prefix, node: config.Node}
+	if a.prefix == "" {
+		a.prefix = DefaultAuditPrefix
+	}
+	if a.node == "" {
+		a.node, _ = os.Hostname()
+	}
+	return a
+}
+
+// isAuditKey reports whether key names an audit blob.
+func (s *Storage) isAuditKey(key string) bool {
+	return s.audit != nil && strings.HasPrefix(key, s.audit.prefix)
+}
+
+// checkAuditKey returns a WriteProtectedError if op would modify the audit journal.
+func (s *Storage) checkAuditKey(op, key string) error {
+	if s.isAuditKey(key) {
+		return &WriteProtectedError{Op: op, Key: key, Prefix: s.audit.prefix}
+	}
+	return nil
+}
+
+// record appends record, completed with the outcome of err, to the audit journal.
+// Failures to write it are logged but not returned.
+func (s *Storage) record(ctx context.Context, record AuditRecord, err error) {
+	if s.audit == nil || s.config.ReadOnly {
+		return
+	}
+	record.Time = time.Now().UTC()
+	record.Node = s.audit.node
+	record.Outcome = "success"
+	if err != nil {
+		record.Outcome = "failure"
+		record.Error = err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+	if err := s.appendAudit(ctx, record); err != nil {
+		s.logger.Error("writing audit record failed",
+			append(errorFields(err), zap.String("op", record.Op), zap.String("key", record.Key))...)
+	}
+}
+
+// key returns the key of part of the journal blobs of day.
+func (a *audit) key(day string, part int) string {
+	if part == 0 {
+		return a.prefix + day + ".jsonl"
+	}
+	return fmt.Sprintf("%s%s.%d.jsonl", a.prefix, day, part)
+}
+
+// current returns the part of the journal blobs of day that records are appended to.
+func (a *audit) current(day string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.day != day {
+		a.day, a.part = day, 0
+	}
+	return a.part
+}
+
+// full moves on from part of the journal blobs of day, which holds as many blocks as
+// an append blob can, to the next part, unless another record already did.
+func (a *audit) full(day string, part int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.day == day && a.part == part {
+		a.part++
+	}
+}
+
+// appendAudit appends record to the current journal blob of its day, moving on to the
+// next blob when it is full.
+func (s *Storage) appendAudit(ctx context.Context, record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	day := record.Time.Format(time.DateOnly)
+	for {
+		part := s.audit.current(day)
+		err := s.appendAuditBlock(ctx, s.audit.key(day, part), line)
+		var respErr *azcore.ResponseError
+		if !errors.As(err, &respErr) || respErr.ErrorCode != string(bloberror.BlockCountExceedsLimit) {
+			return err
+		}
+		// Instances start at the first blob of the day and skip the full ones here
+		s.audit.full(day, part)
+	}
+}
+
+// appendAuditBlock appends line to the audit blob of key, creating the blob first if
+// needed.
+func (s *Storage) appendAuditBlock(ctx context.Context, key string, line []byte) error {
+	client, name := s.clientFor(key), s.blobName(key)
+
+	_, err := client.AppendBlock(ctx, name, line)
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) || respErr.ErrorCode != string(bloberror.BlobNotFound) {
+		return err
+	}
+	// Another instance may create the day's blob at the same time; the condition keeps
+	// either from truncating the other's records
+	etagAny := azcore.ETagAny
+	_, err = client.CreateAppendBlob(ctx, name, &appendblob.CreateOptions{
+		AccessConditions: &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etagAny},
+		},
+	})
+	if err != nil && (!errors.As(err, &respErr) || respErr.ErrorCode != string(bloberror.BlobAlreadyExists)) {
+		return fmt.Errorf("creating audit blob %s: %w", key, err)
+	}
+	_, err = client.AppendBlock(ctx, name, line)
+	return err
+}
+
+// contentHash returns the hex SHA-256 hash of value.
+func contentHash(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
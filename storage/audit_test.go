@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// appendTransport fakes append blobs: appending to a blob fails until the blob is
+// created, and once it holds limit blocks if limit is set. It records the appended
+// blocks; other requests succeed.
+type appendTransport struct {
+	created map[string]bool
+	blocks  []string
+	limit   int
+	counts  map[string]int
+}
+
+func (t *appendTransport) Do(req *http.Request) (*http.Response, error) {
+	path := req.URL.EscapedPath()
+	switch {
+	case req.URL.Query().Get("comp") == "appendblock":
+		if !t.created[path] {
+			return statusTransport{status: http.StatusNotFound, errorCode: "BlobNotFound"}.Do(req)
+		}
+		if t.limit > 0 && t.counts[path] >= t.limit {
+			return statusTransport{status: http.StatusConflict, errorCode: "BlockCountExceedsLimit"}.Do(req)
+		}
+		if t.counts == nil {
+			t.counts = make(map[string]int)
+		}
+		t.counts[path]++
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		t.blocks = append(t.blocks, string(body))
+	// The SDK does not canonicalize its x-ms-* header names
+	case slices.Contains(req.Header["x-ms-blob-type"], "AppendBlob"):
+		if t.created[path] {
+			return statusTransport{status: http.StatusConflict, errorCode: "BlobAlreadyExists"}.Do(req)
+		}
+		t.created[path] = true
+	}
+	return statusTransport{status: http.StatusCreated}.Do(req)
+}
+
+// decodeRecords parses the JSON lines of an audit journal.
+func decodeRecords(t *testing.T, journal string) []AuditRecord {
+	t.Helper()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(strings.NewReader(journal))
+	for scanner.Scan() {
+		var record AuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditRecordsStore(t *testing.T) {
+	transport := &appendTransport{created: make(map[string]bool)}
+	s := newStorage(fakeContainer(t, "container", transport), Config{Audit: &AuditConfig{Node: "edge-1"}})
+	ctx := context.Background()
+
+	require.NoError(t, s.Store(ctx, "certificates/a.crt", []byte("cert")))
+	require.NoError(t, s.Store(ctx, "certificates/b.crt", []byte("cert")))
+	day := time.Now().UTC().Format(time.DateOnly)
+	assert.Equal(t, map[string]bool{"/container/audit%2F" + day + ".jsonl": true}, transport.created)
+
+	records := decodeRecords(t, strings.Join(transport.blocks, ""))
+	require.Len(t, records, 2)
+	assert.Equal(t, "edge-1", records[0].Node)
+	assert.Equal(t, AuditStore, records[0].Op)
+	assert.Equal(t, "certificates/a.crt", records[0].Key)
+	assert.Equal(t, 4, records[0].Size)
+	assert.Equal(t, contentHash([]byte("cert")), records[0].SHA256)
+	assert.Equal(t, "success", records[0].Outcome)
+	assert.WithinDuration(t, time.Now(), records[0].Time, time.Minute)
+}
+
+// Test that records continue in the next blob of the day once a blob is full, including
+// for an instance that starts after the first blob is full.
+func TestAuditRollsOverFullBlobs(t *testing.T) {
+	transport := &appendTransport{created: make(map[string]bool), limit: 2}
+	config := Config{Audit: &AuditConfig{Prefix: "journal/"}}
+	s := newStorage(fakeContainer(t, "container", transport), config)
+	ctx := context.Background()
+
+	for range 3 {
+		require.NoError(t, s.Store(ctx, "certificates/a.crt", []byte("cert")))
+	}
+	restarted := newStorage(fakeContainer(t, "container", transport), config)
+	require.NoError(t, restarted.Store(ctx, "certificates/a.crt", []byte("cert")))
+	require.NoError(t, restarted.Store(ctx, "certificates/a.crt", []byte("cert")))
+
+	day := time.Now().UTC().Format(time.DateOnly)
+	assert.Equal(t, map[string]int{
+		"/container/journal%2F" + day + ".jsonl":   2,
+		"/container/journal%2F" + day + ".1.jsonl": 2,
+		"/container/journal%2F" + day + ".2.jsonl": 1,
+	}, transport.counts)
+	assert.Len(t, decodeRecords(t, strings.Join(transport.blocks, "")), 5)
+}
+
+func TestAuditBlobsAreProtected(t *testing.T) {
+	transport := &appendTransport{created: make(map[string]bool)}
+	s := newStorage(fakeContainer(t, "container", transport), Config{Audit: &AuditConfig{Prefix: "journal/"}})
+	ctx := context.Background()
+
+	require.ErrorIs(t, s.Store(OverrideProtection(ctx), "journal/2026-01-01.jsonl", []byte("{}")), ErrProtectedKey)
+	assert.Empty(t, transport.blocks, "Rejected writes to the journal itself should not be recorded")
+}
+
+func TestAuditStorage(t *testing.T) {
+	prefix := fmt.Sprintf("audit-test/%d/", time.Now().UnixNano())
+	s := setupTestStorageWith(t, func(config *Config) {
+		config.Audit = &AuditConfig{Prefix: prefix + "journal/", Node: "test-node"}
+	})
+	ctx := context.Background()
+	key := prefix + "a.crt"
+
+	require.NoError(t, s.Store(ctx, key, []byte("cert")))
+	require.NoError(t, s.Lock(ctx, key))
+	require.NoError(t, s.Unlock(ctx, key))
+	require.NoError(t, s.ForceUnlock(ctx, key))
+	require.NoError(t, s.Delete(ctx, key))
+
+	journalKey := prefix + "journal/" + time.Now().UTC().Format(time.DateOnly) + ".jsonl"
+	require.ErrorIs(t, s.Delete(ctx, prefix), ErrProtectedKey)
+	journal, err := s.Load(ctx, journalKey)
+	require.NoError(t, err)
+
+	var ops []string
+	for _, record := range decodeRecords(t, string(journal)) {
+		assert.Equal(t, "test-node", record.Node)
+		ops = append(ops, record.Op+" "+record.Outcome)
+	}
+	assert.Equal(t, []string{
+		"store success", "lock success", "unlock success", "force_unlock success", "delete success", "delete failure",
+	}, ops)
+	assert.True(t, bytes.HasSuffix(journal, []byte("\n")))
+}
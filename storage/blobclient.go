@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/streaming"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/appendblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+)
+
+// blobClient is the Blob service API of one container that Storage is built on. Every
+// container of a Storage, including routes and the secondary account, is reached through
+// it; azureBlobs implements it with the Azure SDK. The methods mirror the SDK's, taking
+// blob names, and failed requests return the SDK's *azcore.ResponseError, so another
+// implementation reports status and error codes the way Azure does.
+type blobClient interface {
+	// URL returns the URL of the container.
+	URL() string
+	Create(ctx context.Context, options *container.CreateOptions) (container.CreateResponse, error)
+	GetProperties(ctx context.Context, options *container.GetPropertiesOptions) (container.GetPropertiesResponse, error)
+	NewListBlobsFlatPager(options *container.ListBlobsFlatOptions) *runtime.Pager[container.ListBlobsFlatResponse]
+
+	UploadBlob(ctx context.Context, name string, value []byte, options *blockblob.UploadBufferOptions) (blockblob.UploadBufferResponse, error)
+	DownloadBlob(ctx context.Context, name string, options *blob.DownloadStreamOptions) (blob.DownloadStreamResponse, error)
+	GetBlobProperties(ctx context.Context, name string, options *blob.GetPropertiesOptions) (blob.GetPropertiesResponse, error)
+	DeleteBlob(ctx context.Context, name string, options *blob.DeleteOptions) (blob.DeleteResponse, error)
+	// DeleteBlobVersion deletes a previous version of a blob on an account with versioning.
+	DeleteBlobVersion(ctx context.Context, name, versionID string) (blob.DeleteResponse, error)
+	UndeleteBlob(ctx context.Context, name string) (blob.UndeleteResponse, error)
+	SetBlobTier(ctx context.Context, name string, tier blob.AccessTier) (blob.SetTierResponse, error)
+	// NewBlobLeaseClient returns a client for the lease of a blob, which uses
+	// options.LeaseID or a random lease ID.
+	NewBlobLeaseClient(name string, options *lease.BlobClientOptions) (leaseClient, error)
+
+	CreateAppendBlob(ctx context.Context, name string, options *appendblob.CreateOptions) (appendblob.CreateResponse, error)
+	AppendBlock(ctx context.Context, name string, data []byte) (appendblob.AppendBlockResponse, error)
+}
+
+// leaseClient manages the lease of one blob. *lease.BlobClient implements it.
+type leaseClient interface {
+	LeaseID() *string
+	AcquireLease(ctx context.Context, duration int32, options *lease.BlobAcquireOptions) (lease.BlobAcquireResponse, error)
+	RenewLease(ctx context.Context, options *lease.BlobRenewOptions) (lease.BlobRenewResponse, error)
+	ReleaseLease(ctx context.Context, options *lease.BlobReleaseOptions) (lease.BlobReleaseResponse, error)
+	BreakLease(ctx context.Context, options *lease.BlobBreakOptions) (lease.BlobBreakResponse, error)
+}
+
+// azureBlobs is the blobClient of an Azure container.
+type azureBlobs struct {
+	*container.Client
+}
+
+var _ blobClient = azureBlobs{}
+
+func (c azureBlobs) UploadBlob(ctx context.Context, name string, value []byte, options *blockblob.UploadBufferOptions) (blockblob.UploadBufferResponse, error) {
+	return c.NewBlockBlobClient(name).UploadBuffer(ctx, value, options)
+}
+
+func (c azureBlobs) DownloadBlob(ctx context.Context, name string, options *blob.DownloadStreamOptions) (blob.DownloadStreamResponse, error) {
+	return c.NewBlobClient(name).DownloadStream(ctx, options)
+}
+
+func (c azureBlobs) GetBlobProperties(ctx context.Context, name string, options *blob.GetPropertiesOptions) (blob.GetPropertiesResponse, error) {
+	return c.NewBlobClient(name).GetProperties(ctx, options)
+}
+
+func (c azureBlobs) DeleteBlob(ctx context.Context, name string, options *blob.DeleteOptions) (blob.DeleteResponse, error) {
+	return c.NewBlobClient(name).Delete(ctx, options)
+}
+
+func (c azureBlobs) DeleteBlobVersion(ctx context.Context, name, versionID string) (blob.DeleteResponse, error) {
+	versionClient, err := c.NewBlobClient(name).WithVersionID(versionID)
+	if err != nil {
+		return blob.DeleteResponse{}, err
+	}
+	return versionClient.Delete(ctx, nil)
+}
+
+func (c azureBlobs) UndeleteBlob(ctx context.Context, name string) (blob.UndeleteResponse, error) {
+	return c.NewBlobClient(name).Undelete(ctx, nil)
+}
+
+func (c azureBlobs) SetBlobTier(ctx context.Context, name string, tier blob.AccessTier) (blob.SetTierResponse, error) {
+	return c.NewBlobClient(name).SetTier(ctx, tier, nil)
+}
+
+func (c azureBlobs) NewBlobLeaseClient(name string, options *lease.BlobClientOptions) (leaseClient, error) {
+	return lease.NewBlobClient(c.NewBlobClient(name), options)
+}
+
+func (c azureBlobs) CreateAppendBlob(ctx context.Context, name string, options *appendblob.CreateOptions) (appendblob.CreateResponse, error) {
+	return c.NewAppendBlobClient(name).Create(ctx, options)
+}
+
+func (c azureBlobs) AppendBlock(ctx context.Context, name string, data []byte) (appendblob.AppendBlockResponse, error) {
+	return c.NewAppendBlobClient(name).AppendBlock(ctx, streaming.NopCloser(bytes.NewReader(data)), nil)
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/caddyserver/certmagic"
+)
+
+const (
+	// DefaultCacheMaxEntries is the cache size used when CacheConfig.MaxEntries is zero.
+	DefaultCacheMaxEntries = 1024
+	// DefaultCacheTTL is the freshness period used when CacheConfig.TTL is zero.
+	DefaultCacheTTL = time.Minute
+)
+
+// CacheConfig configures the in-memory read-through cache.
+//
+// Fresh entries are served without contacting Azure. Once an entry is older than TTL it
+// is revalidated with a conditional request (If-None-Match), so an unchanged blob costs a
+// 304 response instead of a full download. Store, Delete and Undelete through the same
+// Storage invalidate affected entries immediately; writes made by other nodes become
+// visible once the entry's TTL has elapsed.
+type CacheConfig struct {
+	// MaxEntries bounds the number of cached keys; least recently used keys are evicted first.
+	MaxEntries int
+	// TTL is how long an entry is served without revalidation.
+	TTL time.Duration
+	// NegativeTTL is how long a missing key is remembered. Zero disables negative caching.
+	NegativeTTL time.Duration
+}
+
+// cacheEntry is the cached state of a single key.
+type cacheEntry struct {
+	expires time.Time
+	etag    azcore.ETag
+	key     string
+	info    certmagic.KeyInfo
+	value   []byte
+	// hasValue is false for entries populated by Stat, which only know the properties.
+	hasValue bool
+	// missing marks a negative entry for a key that does not exist.
+	missing bool
+}
+
+// keyCache is a bounded LRU cache of blob values and properties.
+type keyCache struct {
+	entries     map[string]*list.Element
+	lru         *list.List
+	maxEntries  int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	// generation is bumped on every invalidation so that a fetch which raced with a
+	// local write does not put the value it read before the write back into the cache.
+	generation uint64
+	mu         sync.Mutex
+}
+
+// newKeyCache returns a cache for config, or nil if config is nil.
+func newKeyCache(config *CacheConfig) *keyCache {
+	if config == nil {
+		return nil
+	}
+	c := &keyCache{
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		maxEntries:  config.MaxEntries,
+		ttl:         config.TTL,
+		negativeTTL: config.NegativeTTL,
+	}
+	if c.maxEntries <= 0 {
+		c.maxEntries = DefaultCacheMaxEntries
+	}
+	if c.ttl <= 0 {
+		c.ttl = DefaultCacheTTL
+	}
+	return c
+}
+
+// get returns a copy of the entry for key and marks it as recently used.
+func (c *keyCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return *elem.Value.(*cacheEntry), true
+}
+
+// currentGeneration returns the generation to pass to put for a fetch starting now.
+func (c *keyCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// put stores entry, evicting the least recently used entries beyond the size bound.
+// The entry is discarded if the cache was invalidated since generation was read.
+func (c *keyCache) put(entry cacheEntry, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[entry.key]; ok {
+		*elem.Value.(*cacheEntry) = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(&entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// drop removes a stale entry for key without invalidating concurrent fetches.
+func (c *keyCache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// remove invalidates the entry for key after a local mutation.
+func (c *keyCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// invalidateCached drops key from the cache after a local mutation.
+func (s *Storage) invalidateCached(key string) {
+	if s.cache != nil {
+		s.cache.remove(key)
+	}
+}
+
+// loadCached is Load backed by the cache.
+func (s *Storage) loadCached(ctx context.Context, key string) ([]byte, error) {
+	generation := s.cache.currentGeneration()
+	entry, ok := s.cache.get(key)
+	now := time.Now()
+
+	if ok && now.Before(entry.expires) {
+		if entry.missing {
+			return nil, fs.ErrNotExist
+		}
+		if entry.hasValue {
+			return bytes.Clone(entry.value), nil
+		}
+	}
+
+	// Revalidate stale entries that hold a value; anything else needs a full download.
+	var ifNoneMatch *azcore.ETag
+	if ok && entry.hasValue && entry.etag != "" {
+		ifNoneMatch = &entry.etag
+	}
+
+	blobVal, err := s.download(ctx, s.clientFor(key), key, ifNoneMatch)
+	switch {
+	case errors.Is(err, errNotModified):
+		entry.expires = now.Add(s.cache.ttl)
+		s.cache.put(entry, generation)
+		return bytes.Clone(entry.value), nil
+	case errors.Is(err, fs.ErrNotExist):
+		s.cacheMissing(key, generation)
+		return nil, err
+	case err != nil:
+		return nil, err
+	}
+
+	s.cache.put(cacheEntry{
+		expires:  now.Add(s.cache.ttl),
+		etag:     blobVal.etag,
+		key:      key,
+		info:     blobVal.info,
+		value:    blobVal.data,
+		hasValue: true,
+	}, generation)
+	return bytes.Clone(blobVal.data), nil
+}
+
+// statCached is Stat backed by the cache.
+func (s *Storage) statCached(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	generation := s.cache.currentGeneration()
+	entry, ok := s.cache.get(key)
+	now := time.Now()
+
+	if ok && now.Before(entry.expires) {
+		if entry.missing {
+			return certmagic.KeyInfo{}, fs.ErrNotExist
+		}
+		return entry.info, nil
+	}
+
+	var ifNoneMatch *azcore.ETag
+	if ok && !entry.missing && entry.etag != "" {
+		ifNoneMatch = &entry.etag
+	}
+
+	blobVal, err := s.getProperties(ctx, s.clientFor(key), key, ifNoneMatch)
+	switch {
+	case errors.Is(err, errNotModified):
+		entry.expires = now.Add(s.cache.ttl)
+		s.cache.put(entry, generation)
+		return entry.info, nil
+	case errors.Is(err, fs.ErrNotExist):
+		s.cacheMissing(key, generation)
+		return certmagic.KeyInfo{}, err
+	case err != nil:
+		return certmagic.KeyInfo{}, err
+	}
+
+	// Keep a cached value if the blob is unchanged, otherwise only remember the properties.
+	newEntry := cacheEntry{
+		expires: now.Add(s.cache.ttl),
+		etag:    blobVal.etag,
+		key:     key,
+		info:    blobVal.info,
+	}
+	if ok && entry.hasValue && entry.etag == blobVal.etag {
+		newEntry.value = entry.value
+		newEntry.hasValue = true
+	}
+	s.cache.put(newEntry, generation)
+	return blobVal.info, nil
+}
+
+// cacheMissing records a negative entry for key when negative caching is enabled,
+// and otherwise drops any stale entry for it.
+func (s *Storage) cacheMissing(key string, generation uint64) {
+	if s.cache.negativeTTL <= 0 {
+		s.cache.drop(key)
+		return
+	}
+	s.cache.put(cacheEntry{
+		expires: time.Now().Add(s.cache.negativeTTL),
+		key:     key,
+		missing: true,
+	}, generation)
+}
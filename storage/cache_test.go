@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newKeyCache(&CacheConfig{MaxEntries: 2})
+	gen := c.currentGeneration()
+
+	c.put(cacheEntry{key: "a"}, gen)
+	c.put(cacheEntry{key: "b"}, gen)
+
+	// Touch "a" so that "b" becomes the least recently used entry.
+	_, ok := c.get("a")
+	require.True(t, ok)
+
+	c.put(cacheEntry{key: "c"}, gen)
+
+	_, ok = c.get("a")
+	assert.True(t, ok, "Recently used entry should be kept")
+	_, ok = c.get("b")
+	assert.False(t, ok, "Least recently used entry should be evicted")
+	_, ok = c.get("c")
+	assert.True(t, ok)
+}
+
+func TestKeyCacheDiscardsPutAfterInvalidation(t *testing.T) {
+	c := newKeyCache(&CacheConfig{})
+	assert.Equal(t, DefaultCacheMaxEntries, c.maxEntries)
+	assert.Equal(t, DefaultCacheTTL, c.ttl)
+
+	// A fetch starts, then a local write invalidates the key before the fetch completes.
+	gen := c.currentGeneration()
+	c.remove("key")
+	c.put(cacheEntry{key: "key", value: []byte("stale")}, gen)
+
+	_, ok := c.get("key")
+	assert.False(t, ok, "A value read before a local write must not be cached")
+}
+
+// revalidateTransport serves a blob with a fixed ETag, answering conditional downloads
+// with an empty 304 like Azure does.
+type revalidateTransport struct {
+	downloads    int
+	notModifieds int
+}
+
+func (t *revalidateTransport) Do(req *http.Request) (*http.Response, error) {
+	const etag = `"0x8DC0000000000001"`
+	header := http.Header{}
+	header.Set("ETag", etag)
+	status, body := http.StatusOK, "original"
+	if req.Header.Get("If-None-Match") == etag {
+		t.notModifieds++
+		status, body = http.StatusNotModified, ""
+	} else {
+		t.downloads++
+		header.Set("Content-Length", fmt.Sprint(len(body)))
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// Test that a 304 answer to a revalidation keeps the cached value. The SDK reports it
+// as a successful download with an empty body.
+func TestCachedLoadKeepsValueWhenNotModified(t *testing.T) {
+	transport := &revalidateTransport{}
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: transport,
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	require.NoError(t, err)
+	s := newStorage(azureBlobs{containerClient}, Config{Cache: &CacheConfig{TTL: time.Nanosecond}})
+	ctx := context.Background()
+
+	for range 3 {
+		loaded, err := s.Load(ctx, "key.txt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("original"), loaded)
+	}
+	assert.Equal(t, 1, transport.downloads)
+	assert.Equal(t, 2, transport.notModifieds)
+}
+
+// Test that cached reads see local writes and deletes immediately.
+func TestCachedLoadSeesLocalWrites(t *testing.T) {
+	s := setupTestStorageWith(t, func(c *Config) {
+		c.Cache = &CacheConfig{TTL: time.Hour, NegativeTTL: time.Hour}
+	})
+	ctx := context.Background()
+	key := fmt.Sprintf("cache-test/%d.txt", time.Now().UnixNano())
+	t.Cleanup(func() { _ = s.Delete(context.Background(), key) })
+
+	// Negative entry for a missing key.
+	_, err := s.Load(ctx, key)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	assert.False(t, s.Exists(ctx, key))
+
+	require.NoError(t, s.Store(ctx, key, []byte("first")))
+	loaded, err := s.Load(ctx, key)
+	require.NoError(t, err, "Store must invalidate the negative cache entry")
+	assert.Equal(t, []byte("first"), loaded)
+
+	require.NoError(t, s.Store(ctx, key, []byte("second")))
+	loaded, err = s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("second"), loaded, "Store must invalidate the cached value")
+
+	info, err := s.Stat(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("second")), info.Size)
+
+	require.NoError(t, s.Delete(ctx, key))
+	assert.False(t, s.Exists(ctx, key), "Delete must invalidate the cached entry")
+}
+
+// Test that stale entries are revalidated and pick up writes made by another node.
+func TestCachedLoadRevalidatesStaleEntries(t *testing.T) {
+	s := setupTestStorageWith(t, func(c *Config) {
+		c.Cache = &CacheConfig{TTL: time.Second}
+	})
+	other := setupTestStorage(t)
+	ctx := context.Background()
+	key := fmt.Sprintf("cache-revalidate-test/%d.txt", time.Now().UnixNano())
+	t.Cleanup(func() { _ = other.Delete(context.Background(), key) })
+
+	require.NoError(t, other.Store(ctx, key, []byte("original")))
+	loaded, err := s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("original"), loaded)
+
+	// Unchanged blob: revalidation answers 304 and the cached value is returned.
+	time.Sleep(1500 * time.Millisecond)
+	loaded, err = s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("original"), loaded)
+
+	// Changed by another node: the new value appears once the entry is stale.
+	require.NoError(t, other.Store(ctx, key, []byte("changed")))
+	time.Sleep(1500 * time.Millisecond)
+	loaded, err = s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("changed"), loaded)
+}
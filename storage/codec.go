@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+// MaxBlobNameLength is the longest blob name Azure accepts. Longer names are shortened:
+// they keep their first part, followed by "~" and the SHA-256 of the whole name, and the
+// key is recorded in the blob's metadata.
+const MaxBlobNameLength = 1024
+
+const (
+	// keyMetadataKey is the blob metadata entry holding the key of a blob whose name
+	// was shortened, since the key cannot be decoded from the name.
+	keyMetadataKey = "certmagickey"
+	// hashSuffixLength is the length of the "~<sha256>" suffix of shortened names.
+	hashSuffixLength = 1 + 2*sha256.Size
+	// minShortenedPrefix is the shortest start of the original name that a shortened
+	// name keeps, including lock names and after backing off to a UTF-8 boundary.
+	minShortenedPrefix = MaxBlobNameLength - len(lockSuffix) - hashSuffixLength - (utf8.UTFMax - 1)
+)
+
+// KeyCodec maps certmagic keys to blob names and back. Encode is applied to every key
+// and List prefix sent to Azure, and Decode to every blob name read from a listing.
+// Decode(Encode(key)) must return key.
+type KeyCodec interface {
+	Encode(key string) string
+	Decode(name string) (string, error)
+}
+
+// PrefixEncoder is implemented by a KeyCodec whose encoding of a key's end depends on
+// whether more of the key follows, so that Encode(prefix) is not a prefix of the names
+// of all keys starting with prefix. EncodePrefix returns a blob name prefix that is;
+// listings then filter the decoded keys by prefix.
+type PrefixEncoder interface {
+	EncodePrefix(prefix string) string
+}
+
+// PlainKeys uses keys as blob names unchanged. It is the default.
+type PlainKeys struct{}
+
+func (PlainKeys) Encode(key string) string           { return key }
+func (PlainKeys) Decode(name string) (string, error) { return name, nil }
+
+// EscapedKeys percent-encodes the characters Azure does not store faithfully: control
+// characters, backslashes (which Azure turns into slashes), dots ending a path segment
+// and the percent sign itself. Keys without any of these are used unchanged, so blobs
+// written with PlainKeys remain readable.
+type EscapedKeys struct{}
+
+func (EscapedKeys) Encode(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c < 0x20, c == 0x7f, c == '\\', c == '%',
+			c == '.' && (i == len(key)-1 || key[i+1] == '/'):
+			fmt.Fprintf(&b, "%%%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// EncodePrefix encodes prefix without its trailing dot, which Encode escapes at the end
+// of a key but not where the key continues.
+func (c EscapedKeys) EncodePrefix(prefix string) string {
+	return c.Encode(strings.TrimSuffix(prefix, "."))
+}
+
+// Decode reverses Encode. Percent signs that do not start a valid escape are kept as
+// they are. Names that Encode would not produce, such as a blob named "a%41b" by
+// PlainKeys, are taken as keys unchanged by the storage.
+func (EscapedKeys) Decode(name string) (string, error) {
+	if !strings.Contains(name, "%") {
+		return name, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]) {
+			b.WriteByte(unhex(name[i+1])<<4 | unhex(name[i+2]))
+			i += 2
+			continue
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c <= 'F':
+		return c - 'A' + 10
+	default:
+		return c - 'a' + 10
+	}
+}
+
+// blobName returns the blob name of key.
+func (s *Storage) blobName(key string) string {
+	return s.encodeName(key, MaxBlobNameLength)
+}
+
+// legacyName returns the name key's blob had before its codec was configured, which is
+// the key itself, and reports whether it differs from the blob name. Reads fall back to
+// it when the blob name does not exist.
+func (s *Storage) legacyName(key string) (string, bool) {
+	if len(key) > MaxBlobNameLength || s.blobName(key) == key {
+		return "", false
+	}
+	return key, true
+}
+
+// encodeName returns the encoded key, shortened to limit bytes if it is longer.
+func (s *Storage) encodeName(key string, limit int) string {
+	name := s.codec.Encode(key)
+	if len(name) <= limit {
+		return name
+	}
+	cut := limit - hashSuffixLength
+	for cut > 0 && !utf8.RuneStart(name[cut]) {
+		cut--
+	}
+	sum := sha256.Sum256([]byte(name))
+	return name[:cut] + "~" + hex.EncodeToString(sum[:])
+}
+
+// isShortened reports whether name has the form of a shortened blob name.
+func isShortened(name string) bool {
+	if len(name) < hashSuffixLength || name[len(name)-hashSuffixLength] != '~' {
+		return false
+	}
+	_, err := hex.DecodeString(name[len(name)-hashSuffixLength+1:])
+	return err == nil
+}
+
+// keyMetadata returns metadata with key recorded in it if name, the blob name of key
+// without any lock suffix, was shortened, and metadata unchanged otherwise.
+func (s *Storage) keyMetadata(key, name string, metadata map[string]*string) map[string]*string {
+	if name == s.codec.Encode(key) {
+		return metadata
+	}
+	metadata = maps.Clone(metadata)
+	if metadata == nil {
+		metadata = make(map[string]*string, 1)
+	}
+	escaped := url.PathEscape(key)
+	metadata[keyMetadataKey] = &escaped
+	return metadata
+}
+
+// blobPrefix returns the prefix of the blob names of all keys starting with prefix.
+// Listings must filter the keys they decode by prefix, since other keys can share it.
+func (s *Storage) blobPrefix(prefix string) string {
+	blobPrefix := s.codec.Encode(prefix)
+	if encoder, ok := s.codec.(PrefixEncoder); ok {
+		blobPrefix = encoder.EncodePrefix(prefix)
+	}
+	// Shortened names keep only the start of the original name
+	if len(blobPrefix) > minShortenedPrefix {
+		cut := minShortenedPrefix
+		for cut > 0 && !utf8.RuneStart(blobPrefix[cut]) {
+			cut--
+		}
+		blobPrefix = blobPrefix[:cut]
+	}
+	return blobPrefix
+}
+
+// keyName returns the key of a listed blob with the given metadata. Names the codec
+// would not have written are legacy names and returned unchanged; names it cannot
+// decode are logged and reported as false.
+func (s *Storage) keyName(name string, metadata map[string]*string) (string, bool) {
+	if isShortened(name) {
+		if value, ok := metadataValue(metadata, keyMetadataKey); ok {
+			if key, err := url.PathUnescape(value); err == nil {
+				return key, true
+			}
+		}
+	}
+	key, err := s.codec.Decode(name)
+	if err != nil {
+		s.logger.Warn("skipping blob whose name cannot be decoded", zap.String("name", name), zap.Error(err))
+		return "", false
+	}
+	if s.codec.Encode(key) != name {
+		return name, true
+	}
+	return key, true
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscapedKeysRoundTrip(t *testing.T) {
+	codec := EscapedKeys{}
+	for _, key := range []string{
+		"certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt",
+		"ends.with.dot.",
+		"segment./with.dot./child",
+		`back\slash`,
+		"control\x00\t\nchars\x7f",
+		"100%/done",
+		"%41 is not an A",
+		"unicode/bücher.example",
+	} {
+		name := codec.Encode(key)
+		assert.NotContains(t, name, `\`)
+		assert.False(t, strings.HasSuffix(name, "."), "Blob names must not end in a dot: %q", name)
+		decoded, err := codec.Decode(name)
+		require.NoError(t, err)
+		assert.Equal(t, key, decoded)
+	}
+}
+
+func TestEscapedKeysLeavesPlainKeys(t *testing.T) {
+	codec := EscapedKeys{}
+	key := "certificates/acme-v02.api.letsencrypt.org-directory/*.example.com/*.example.com.json"
+	assert.Equal(t, key, codec.Encode(key))
+	assert.Equal(t, "ends.with.dot%2E", codec.Encode("ends.with.dot."))
+
+	// Names that are not valid escapes decode as they are
+	decoded, err := codec.Decode("50%-off/%zz")
+	require.NoError(t, err)
+	assert.Equal(t, "50%-off/%zz", decoded)
+}
+
+// pathTransport answers every request with 201 Created and records the request paths.
+type pathTransport struct {
+	paths []string
+}
+
+func (t *pathTransport) Do(req *http.Request) (*http.Response, error) {
+	t.paths = append(t.paths, req.URL.EscapedPath())
+	return statusTransport{status: http.StatusCreated}.Do(req)
+}
+
+func TestStoreEncodesKeys(t *testing.T) {
+	transport := &pathTransport{}
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: transport, Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+	s := newStorage(azureBlobs{containerClient}, Config{KeyCodec: EscapedKeys{}})
+	ctx := context.Background()
+
+	require.NoError(t, s.Store(ctx, `dir\file.`, []byte("value")))
+	assert.Equal(t, []string{"/container/dir%255Cfile%252E"}, transport.paths[:1])
+
+	require.NoError(t, s.Store(ctx, strings.Repeat("k", MaxBlobNameLength+1), []byte("value")))
+	require.Len(t, transport.paths, 2)
+	assert.Len(t, strings.TrimPrefix(transport.paths[1], "/container/"), MaxBlobNameLength, "Too long keys should be shortened")
+}
+
+func TestEscapedKeysPrefix(t *testing.T) {
+	codec := EscapedKeys{}
+	assert.Equal(t, "a", codec.EncodePrefix("a."))
+	assert.Equal(t, "a%2E/", codec.EncodePrefix("a./"))
+	assert.Equal(t, "a%25", codec.EncodePrefix("a%"))
+}
+
+// Test that listing a prefix ending in a dot finds the keys that continue after the dot
+// as well as the key ending there, whose dot is escaped.
+func TestListPrefixEndingInDot(t *testing.T) {
+	s := setupFakeStorage(t, func(config *Config) { config.KeyCodec = EscapedKeys{} })
+	ctx := context.Background()
+	for _, key := range []string{"dot/a.", "dot/a.b", "dot/a./c", "dot/ab"} {
+		require.NoError(t, s.Store(ctx, key, []byte(key)))
+	}
+
+	keys, err := s.List(ctx, "dot/a.", true)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"dot/a.", "dot/a.b", "dot/a./c"}, keys)
+}
+
+// Test that keys whose blob names are too long for Azure can be stored, listed, loaded,
+// locked and deleted under shortened names.
+func TestLongKeys(t *testing.T) {
+	for name, codec := range map[string]KeyCodec{"plain": PlainKeys{}, "escaped": EscapedKeys{}} {
+		t.Run(name, func(t *testing.T) {
+			s := setupFakeStorage(t, func(config *Config) { config.KeyCodec = codec })
+			ctx := context.Background()
+			prefix := "long/" + strings.Repeat("ü", MaxBlobNameLength/2)
+			first, second := prefix+"/first", prefix+"/second"
+			require.NoError(t, s.Store(ctx, first, []byte("first")))
+			require.NoError(t, s.Store(ctx, second, []byte("second")))
+			assert.LessOrEqual(t, len(s.blobName(first)), MaxBlobNameLength)
+			assert.NotEqual(t, s.blobName(first), s.blobName(second))
+
+			value, err := s.Load(ctx, first)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("first"), value)
+			keys, err := s.List(ctx, prefix+"/", false)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{first, second}, keys)
+
+			require.NoError(t, s.Lock(ctx, first))
+			locks, err := s.ListLocks(ctx)
+			require.NoError(t, err)
+			require.Len(t, locks, 1)
+			assert.Equal(t, first, locks[0].Key)
+			require.NoError(t, s.Unlock(ctx, first))
+
+			require.NoError(t, s.Delete(ctx, prefix))
+			assert.False(t, s.Exists(ctx, first))
+			assert.False(t, s.Exists(ctx, second))
+		})
+	}
+}
+
+func TestEscapedKeysStorage(t *testing.T) {
+	s := setupTestStorageWith(t, func(config *Config) {
+		config.KeyCodec = EscapedKeys{}
+	})
+	ctx := context.Background()
+	prefix := "codec/" + time.Now().Format("20060102150405.000000") + "/"
+	keys := []string{prefix + "ends.with.dot.", prefix + `back\slash`, prefix + "tab\there"}
+
+	for _, key := range keys {
+		require.NoError(t, s.Store(ctx, key, []byte(key)))
+		loaded, err := s.Load(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte(key), loaded)
+	}
+
+	listed, err := s.List(ctx, prefix, true)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, keys, listed)
+
+	require.NoError(t, s.Lock(ctx, keys[0]))
+	require.NoError(t, s.Unlock(ctx, keys[0]))
+
+	for _, key := range keys {
+		require.NoError(t, s.Delete(ctx, key))
+		assert.False(t, s.Exists(ctx, key))
+	}
+}
+
+// Test that blobs written before key_encoding escaped, whose names escaping would change,
+// keep their keys.
+func TestEscapedKeysReadsLegacyNames(t *testing.T) {
+	s := setupFakeStorage(t, func(config *Config) { config.KeyCodec = EscapedKeys{} })
+	ctx := context.Background()
+	key := "legacy/a%41b"
+	_, err := s.client().UploadBlob(ctx, key, []byte("legacy"), nil)
+	require.NoError(t, err)
+
+	value, err := s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("legacy"), value)
+	info, err := s.Stat(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, key, info.Key)
+	keys, err := s.List(ctx, "legacy/", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, keys)
+
+	require.NoError(t, s.Delete(ctx, key))
+	assert.False(t, s.Exists(ctx, key))
+}
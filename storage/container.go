@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"go.uber.org/zap"
+)
+
+// ContainerCreation controls whether NewStorage creates the container.
+type ContainerCreation string
+
+const (
+	// CreateContainerAuto creates the container if the identity is allowed to. If the
+	// create request is denied, e.g. because the identity only has a data role on an
+	// existing container or a SAS for the container, the container only has to exist.
+	CreateContainerAuto ContainerCreation = "auto"
+	// CreateContainerAlways creates the container and fails if that is denied.
+	CreateContainerAlways ContainerCreation = "always"
+	// CreateContainerNever only checks that the container exists.
+	CreateContainerNever ContainerCreation = "never"
+)
+
+// ensureContainer makes sure the container of client exists, creating it as
+// config.CreateContainer allows. Read-only storage never creates it.
+func ensureContainer(ctx context.Context, client blobClient, config Config) error {
+	mode := config.CreateContainer
+	if config.ReadOnly {
+		mode = CreateContainerNever
+	}
+	switch mode {
+	case "", CreateContainerAuto, CreateContainerAlways:
+	case CreateContainerNever:
+		return checkContainer(ctx, client, config)
+	default:
+		return fmt.Errorf("unknown container creation mode %q", mode)
+	}
+
+	options := &container.CreateOptions{}
+	if len(config.ContainerMetadata) > 0 {
+		options.Metadata = make(map[string]*string, len(config.ContainerMetadata))
+		for name, value := range config.ContainerMetadata {
+			options.Metadata[name] = &value
+		}
+	}
+	if config.ContainerAccess != "" {
+		options.Access = &config.ContainerAccess
+	}
+	_, err := client.Create(ctx, options)
+	if err == nil {
+		config.Logger.Info("created blob container", zap.String("container", config.ContainerName))
+		return nil
+	}
+	var respErr *azcore.ResponseError
+	switch {
+	case errors.As(err, &respErr) && respErr.ErrorCode == string(bloberror.ContainerAlreadyExists):
+		// Container already exists, which is fine - continue
+	case mode != CreateContainerAlways && errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden:
+		// The identity may not create containers; it only needs the existing one
+		config.Logger.Debug("not allowed to create blob container; checking that it exists",
+			append(errorFields(err), zap.String("container", config.ContainerName))...)
+		return checkContainer(ctx, client, config)
+	case config.MirrorDir != "" && isUnavailable(err):
+		// Start anyway so certificates can be served from the mirror during an outage
+		config.Logger.Warn("azure blob storage unavailable at startup; continuing with local mirror",
+			append(errorFields(err), zap.String("mirror", config.MirrorDir))...)
+	default:
+		return fmt.Errorf("could not create container: %w", err)
+	}
+	return nil
+}
+
+// checkContainer returns an error unless the container of client exists. It lists at
+// most one blob rather than reading the container properties, which a SAS for the
+// container does not allow.
+func checkContainer(ctx context.Context, client blobClient, config Config) error {
+	maxResults := int32(1)
+	_, err := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{MaxResults: &maxResults}).NextPage(ctx)
+	var respErr *azcore.ResponseError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &respErr) && respErr.ErrorCode == string(bloberror.ContainerNotFound):
+		return fmt.Errorf("container %s does not exist and is not created by this configuration", config.ContainerName)
+	case errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("not allowed to list blobs in container %s, which the storage needs: %w", config.ContainerName, err)
+	case config.MirrorDir != "" && isUnavailable(err):
+		config.Logger.Warn("azure blob storage unavailable at startup; continuing with local mirror",
+			append(errorFields(err), zap.String("mirror", config.MirrorDir))...)
+		return nil
+	default:
+		return fmt.Errorf("checking container %s: %w", config.ContainerName, err)
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// containerTransport answers container create (PUT) and list blobs (GET) requests with
+// the given error codes, or success if empty, and records the create request and the
+// query of the list request.
+type containerTransport struct {
+	createCode string
+	existsCode string
+	methods    []string
+	create     *http.Request
+	listQuery  url.Values
+}
+
+func (t *containerTransport) Do(req *http.Request) (*http.Response, error) {
+	t.methods = append(t.methods, req.Method)
+	status, code := http.StatusOK, t.existsCode
+	body := `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs/></EnumerationResults>`
+	if req.Method == http.MethodPut {
+		t.create = req
+		status, code, body = http.StatusCreated, t.createCode, ""
+	} else {
+		t.listQuery = req.URL.Query()
+	}
+	header := http.Header{}
+	switch code {
+	case "":
+	case "ContainerAlreadyExists":
+		status = http.StatusConflict
+	case "ContainerNotFound":
+		status = http.StatusNotFound
+	default:
+		status = http.StatusForbidden
+	}
+	if code != "" {
+		body = ""
+	}
+	header.Set(headerErrorCode, code)
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestEnsureContainer(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		mode       ContainerCreation
+		readOnly   bool
+		createCode string
+		existsCode string
+		methods    []string
+		wantErr    string
+	}{
+		{name: "created", methods: []string{"PUT"}},
+		{name: "exists", createCode: "ContainerAlreadyExists", methods: []string{"PUT"}},
+		{name: "create denied", createCode: "AuthorizationPermissionMismatch", methods: []string{"PUT", "GET"}},
+		{
+			name:       "create denied and missing",
+			createCode: "AuthorizationPermissionMismatch",
+			existsCode: "ContainerNotFound",
+			methods:    []string{"PUT", "GET"},
+			wantErr:    "does not exist",
+		},
+		{
+			name:       "always",
+			mode:       CreateContainerAlways,
+			createCode: "AuthorizationPermissionMismatch",
+			methods:    []string{"PUT"},
+			wantErr:    "could not create container",
+		},
+		{
+			name:       "create denied and list denied",
+			createCode: "AuthorizationPermissionMismatch",
+			existsCode: "AuthorizationPermissionMismatch",
+			methods:    []string{"PUT", "GET"},
+			wantErr:    "not allowed to list blobs",
+		},
+		{name: "never", mode: CreateContainerNever, methods: []string{"GET"}},
+		{name: "never missing", mode: CreateContainerNever, existsCode: "ContainerNotFound", methods: []string{"GET"}, wantErr: "does not exist"},
+		{name: "read-only", readOnly: true, methods: []string{"GET"}},
+		{name: "unknown mode", mode: "sometimes", wantErr: "unknown container creation mode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport := &containerTransport{createCode: tt.createCode, existsCode: tt.existsCode}
+			err := ensureContainer(ctx, fakeContainer(t, "container", transport), Config{
+				ContainerName:   "container",
+				CreateContainer: tt.mode,
+				ReadOnly:        tt.readOnly,
+				Logger:          zap.NewNop(),
+			})
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.methods, transport.methods)
+		})
+	}
+}
+
+// Test that the container is probed by listing a single blob, which a SAS for the
+// container allows, unlike reading the container properties.
+func TestCheckContainerListsOneBlob(t *testing.T) {
+	transport := &containerTransport{createCode: "AuthorizationFailure"}
+	err := ensureContainer(context.Background(), fakeContainer(t, "container", transport), Config{
+		ContainerName: "container",
+		Logger:        zap.NewNop(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "list", transport.listQuery.Get("comp"))
+	assert.Equal(t, "1", transport.listQuery.Get("maxresults"))
+}
+
+func TestEnsureContainerSetsMetadataAndAccess(t *testing.T) {
+	transport := &containerTransport{}
+	err := ensureContainer(context.Background(), fakeContainer(t, "container", transport), Config{
+		ContainerName:     "container",
+		ContainerMetadata: map[string]string{"owner": "caddy"},
+		ContainerAccess:   container.PublicAccessTypeBlob,
+		Logger:            zap.NewNop(),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, transport.create)
+	// The SDK sets x-ms-* headers without canonicalizing their names
+	assert.Equal(t, []string{"caddy"}, transport.create.Header["x-ms-meta-owner"])
+	assert.Equal(t, []string{"blob"}, transport.create.Header["x-ms-blob-public-access"])
+}
@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// storageScope is the OAuth scope of Azure Storage data plane tokens.
+const storageScope = "https://storage.azure.com/.default"
+
+// diagnoseKeyPrefix is the prefix of the scratch keys written by Diagnose.
+const diagnoseKeyPrefix = ".azureblob-doctor/"
+
+// DiagnosticStatus is the result of a single diagnostic step.
+type DiagnosticStatus string
+
+const (
+	// DiagnosticPass means the step succeeded.
+	DiagnosticPass DiagnosticStatus = "pass"
+	// DiagnosticFail means the step failed; see DiagnosticStep.Hint.
+	DiagnosticFail DiagnosticStatus = "fail"
+	// DiagnosticSkip means the step did not apply or depended on a failed step.
+	DiagnosticSkip DiagnosticStatus = "skip"
+)
+
+// Names of the diagnostic steps, in the order they run.
+const (
+	StepConfig     = "config"
+	StepCredential = "credential"
+	StepEndpoint   = "endpoint"
+	StepContainer  = "container"
+	StepList       = "list"
+	StepWrite      = "write"
+	StepRead       = "read"
+	StepLease      = "lease"
+	StepDelete     = "delete"
+)
+
+// DiagnosticStep is the outcome of one step of Diagnose.
+type DiagnosticStep struct {
+	Name   string
+	Status DiagnosticStatus
+	// Detail describes what was checked, or the error of a failed step
+	Detail string
+	// Hint suggests the missing role, permission or network rule for a failed step
+	Hint     string
+	Duration time.Duration
+}
+
+// Diagnosis is the result of Diagnose.
+type Diagnosis struct {
+	Steps []DiagnosticStep
+}
+
+// Failed reports whether any step failed.
+func (d Diagnosis) Failed() bool {
+	for _, step := range d.Steps {
+		if step.Status == DiagnosticFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Diagnose checks, step by step, whether config can be used: that its client and
+// credential can be created, that the endpoint resolves and accepts connections, and
+// that the container exists and allows listing, writing, reading, leasing and deleting
+// a scratch key. Unlike NewStorage it does not create the container, so it also
+// explains why NewStorage fails. Retries are disabled so failures are reported quickly.
+func Diagnose(ctx context.Context, config Config) Diagnosis {
+	var d Diagnosis
+	options := azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}}
+	start := time.Now()
+	if config.Transport != nil {
+		httpClient, err := newHTTPClient(config.Transport)
+		if err != nil {
+			d.fail(StepConfig, start, err, "check the proxy URL and the CA and client certificate files")
+			return d
+		}
+		options.Transport = httpClient
+	}
+	client, _, err := newContainerClient(config, options)
+	if err != nil {
+		d.fail(StepConfig, start, err, "check the account name, container name and secret settings")
+		return d
+	}
+	d.pass(StepConfig, start, "created client for "+redactURL(client.URL()))
+	d.run(ctx, config, azureBlobs{client}, options)
+	return d
+}
+
+// Diagnose checks, step by step, whether the storage's credential, endpoint and
+// container work, using a scratch key; see the Diagnose function.
+func (s *Storage) Diagnose(ctx context.Context) Diagnosis {
+	var d Diagnosis
+	d.run(ctx, s.config, s.client(), s.clientOptions)
+	return d
+}
+
+// run performs the steps after the client was created.
+func (d *Diagnosis) run(ctx context.Context, config Config, client blobClient, options azcore.ClientOptions) {
+	credentialOK := d.checkCredential(ctx, config, options)
+	endpointOK := d.checkEndpoint(ctx, config, client.URL())
+	if !credentialOK || !endpointOK {
+		for _, step := range []string{StepContainer, StepList, StepWrite, StepRead, StepLease, StepDelete} {
+			d.skip(step, "needs a working credential and endpoint")
+		}
+		return
+	}
+
+	// Like checkContainer, list one blob instead of reading the container's
+	// properties, which a container-scoped SAS token does not allow.
+	start := time.Now()
+	maxResults := int32(1)
+	pager := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{MaxResults: &maxResults})
+	_, err := pager.NextPage(ctx)
+	var respErr *azcore.ResponseError
+	switch {
+	case err == nil:
+		d.pass(StepContainer, start, "container exists")
+		d.pass(StepList, start, "listed blobs")
+	case errors.As(err, &respErr) && respErr.ErrorCode == string(bloberror.ContainerNotFound):
+		d.fail(StepContainer, start, err, diagnosticHint(StepContainer, err))
+		d.skip(StepList, "needs an existing container")
+	default:
+		d.fail(StepContainer, start, err, diagnosticHint(StepContainer, err))
+		d.fail(StepList, start, err, diagnosticHint(StepList, err))
+	}
+
+	if config.ReadOnly {
+		for _, step := range []string{StepWrite, StepRead, StepLease, StepDelete} {
+			d.skip(step, "storage is read-only")
+		}
+		return
+	}
+
+	node, _ := os.Hostname()
+	name := diagnoseKeyPrefix + node + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	start = time.Now()
+	if _, err := client.UploadBlob(ctx, name, []byte("azureblob doctor"), nil); err != nil {
+		d.fail(StepWrite, start, err, diagnosticHint(StepWrite, err))
+		for _, step := range []string{StepRead, StepLease, StepDelete} {
+			d.skip(step, "needs the scratch blob")
+		}
+		return
+	}
+	d.pass(StepWrite, start, "wrote scratch blob "+name)
+
+	start = time.Now()
+	response, err := client.DownloadBlob(ctx, name, nil)
+	if err == nil {
+		_, err = io.ReadAll(response.Body)
+		response.Body.Close()
+	}
+	if err != nil {
+		d.fail(StepRead, start, err, diagnosticHint(StepRead, err))
+	} else {
+		d.pass(StepRead, start, "read scratch blob")
+	}
+
+	start = time.Now()
+	leaseClient, err := client.NewBlobLeaseClient(name, nil)
+	if err == nil {
+		if _, err = leaseClient.AcquireLease(ctx, 15, nil); err == nil {
+			_, err = leaseClient.ReleaseLease(ctx, nil)
+		}
+	}
+	if err != nil {
+		d.fail(StepLease, start, err, diagnosticHint(StepLease, err))
+	} else {
+		d.pass(StepLease, start, "acquired and released a lease")
+	}
+
+	start = time.Now()
+	if _, err := client.DeleteBlob(ctx, name, nil); err != nil {
+		d.fail(StepDelete, start, err, diagnosticHint(StepDelete, err)+"; remove "+name+" manually")
+	} else {
+		d.pass(StepDelete, start, "deleted scratch blob")
+	}
+}
+
+// checkCredential acquires a storage token, unless config authenticates with a shared
+// key or SAS token, which the requests of the later steps check. It reports whether
+// the later steps can authenticate.
+func (d *Diagnosis) checkCredential(ctx context.Context, config Config, options azcore.ClientOptions) bool {
+	if config.ConnectionString != "" || config.secretFile() != "" {
+		d.skip(StepCredential, "uses a connection string, account key or SAS token")
+		return true
+	}
+	start := time.Now()
+	credential, err := tokenCredential(config, options)
+	if err == nil {
+		_, err = credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{storageScope}})
+	}
+	if err != nil {
+		d.fail(StepCredential, start, err, "sign in with az login, set the AZURE_CLIENT_ID, AZURE_TENANT_ID and "+
+			"AZURE_CLIENT_SECRET environment variables, or assign a managed identity to this host")
+		return false
+	}
+	d.pass(StepCredential, start, "acquired a Microsoft Entra ID token")
+	return true
+}
+
+// checkEndpoint resolves the host of containerURL and connects to it, and reports
+// whether the later steps can reach it. With a proxy, only the proxy is checked by
+// the later steps.
+func (d *Diagnosis) checkEndpoint(ctx context.Context, config Config, containerURL string) bool {
+	start := time.Now()
+	endpoint, err := url.Parse(containerURL)
+	if err != nil {
+		d.fail(StepEndpoint, start, err, "")
+		return false
+	}
+	var proxy *url.URL
+	if config.Transport != nil && config.Transport.ProxyURL != "" {
+		proxy, err = url.Parse(config.Transport.ProxyURL)
+	} else {
+		proxy, err = http.ProxyFromEnvironment(&http.Request{URL: endpoint})
+	}
+	if err == nil && proxy != nil {
+		d.skip(StepEndpoint, "requests go through proxy "+proxy.Host)
+		return true
+	}
+
+	host, port := endpoint.Hostname(), endpoint.Port()
+	if port == "" {
+		port = "443"
+		if endpoint.Scheme == "http" {
+			port = "80"
+		}
+	}
+	if net.ParseIP(host) == nil {
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			d.fail(StepEndpoint, start, err, "check DNS; with a private endpoint, the privatelink.blob.core.windows.net "+
+				"zone must be resolvable from this host")
+			return false
+		}
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		d.fail(StepEndpoint, start, err, "check that firewalls and network security groups allow outbound "+
+			"connections to "+net.JoinHostPort(host, port))
+		return false
+	}
+	conn.Close()
+	d.pass(StepEndpoint, start, "connected to "+net.JoinHostPort(host, port))
+	return true
+}
+
+// diagnosticHint suggests what is missing when step failed with err.
+func diagnosticHint(step string, err error) string {
+	var respErr *azcore.ResponseError
+	if !errors.As(err, &respErr) {
+		return "check that outbound HTTPS to the storage account is allowed by firewalls and proxies"
+	}
+	switch respErr.ErrorCode {
+	case "AuthorizationFailure":
+		return "the storage account's network rules reject this client: allow its IP address or virtual network " +
+			"in the account's firewall, or connect through a private endpoint"
+	case "AuthorizationPermissionMismatch":
+		role := "Storage Blob Data Contributor"
+		if step == StepContainer || step == StepList || step == StepRead {
+			role = "Storage Blob Data Reader (or Contributor)"
+		}
+		return fmt.Sprintf("the identity is not allowed to %s: assign it the %s role on the account or container, "+
+			"or issue a SAS token with that permission", step, role)
+	case "AuthorizationResourceTypeMismatch", "AuthorizationServiceMismatch", "AuthorizationProtocolMismatch",
+		"AuthorizationSourceIPMismatch":
+		return "the SAS token does not allow this request: issue a container or account SAS for the blob service " +
+			"over HTTPS with read, add, create, write, delete and list permissions"
+	case "AuthenticationFailed", "InvalidAuthenticationInfo", "NoAuthenticationInformation":
+		return "the account key, SAS token or connection string is invalid or expired"
+	case "KeyBasedAuthenticationNotPermitted":
+		return "shared key access is disabled on the account: authenticate with Microsoft Entra ID instead"
+	case "ContainerNotFound":
+		return "the container does not exist: create it, or let the module create it with an identity that has " +
+			"the Storage Blob Data Contributor role"
+	case "AccountIsDisabled":
+		return "the storage account is disabled"
+	}
+	if respErr.StatusCode >= 500 {
+		return "Azure Storage reported a server error; try again later"
+	}
+	return ""
+}
+
+// redactURL removes the query, which may hold a SAS token, from rawURL.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.RawQuery = ""
+	return u.String()
+}
+
+func (d *Diagnosis) pass(name string, start time.Time, detail string) {
+	d.Steps = append(d.Steps, DiagnosticStep{Name: name, Status: DiagnosticPass, Detail: detail, Duration: time.Since(start)})
+}
+
+func (d *Diagnosis) skip(name, detail string) {
+	d.Steps = append(d.Steps, DiagnosticStep{Name: name, Status: DiagnosticSkip, Detail: detail})
+}
+
+// fail records a failed step.
+func (d *Diagnosis) fail(name string, start time.Time, err error, hint string) {
+	d.Steps = append(d.Steps, DiagnosticStep{
+		Name:     name,
+		Status:   DiagnosticFail,
+		Detail:   diagnosticError(err),
+		Hint:     hint,
+		Duration: time.Since(start),
+	})
+}
+
+// diagnosticError returns a one-line description of err.
+func diagnosticError(err error) string {
+	var respErr *azcore.ResponseError
+	if errors.As(err, &respErr) {
+		return fmt.Sprintf("%d %s", respErr.StatusCode, respErr.ErrorCode)
+	}
+	return err.Error()
+}
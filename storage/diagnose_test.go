@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// statusesOf returns the status of every step of d by name.
+func statusesOf(d Diagnosis) map[string]DiagnosticStatus {
+	statuses := make(map[string]DiagnosticStatus)
+	for _, step := range d.Steps {
+		statuses[step.Name] = step.Status
+	}
+	return statuses
+}
+
+func TestDiagnoseReportsMissingWritePermission(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodHead:
+		case http.MethodGet:
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs/></EnumerationResults>`))
+		default:
+			w.Header().Set("x-ms-error-code", "AuthorizationPermissionMismatch")
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	d := Diagnose(context.Background(), Config{
+		ContainerName: "caddy",
+		ConnectionString: "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;" +
+			"AccountKey=" + base64.StdEncoding.EncodeToString([]byte("account-key")) + ";" +
+			"BlobEndpoint=" + server.URL + "/devstoreaccount1;",
+	})
+
+	assert.True(t, d.Failed())
+	assert.Equal(t, map[string]DiagnosticStatus{
+		StepConfig:     DiagnosticPass,
+		StepCredential: DiagnosticSkip,
+		StepEndpoint:   DiagnosticPass,
+		StepContainer:  DiagnosticPass,
+		StepList:       DiagnosticPass,
+		StepWrite:      DiagnosticFail,
+		StepRead:       DiagnosticSkip,
+		StepLease:      DiagnosticSkip,
+		StepDelete:     DiagnosticSkip,
+	}, statusesOf(d))
+	for _, step := range d.Steps {
+		if step.Name == StepWrite {
+			assert.Equal(t, "403 AuthorizationPermissionMismatch", step.Detail)
+			assert.Contains(t, step.Hint, "Storage Blob Data Contributor")
+		}
+	}
+}
+
+func TestDiagnoseWithContainerSAS(t *testing.T) {
+	// A container-scoped SAS token can list blobs but not read the container's properties.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("sig") == "" {
+			w.Header().Set("x-ms-error-code", "NoAuthenticationInformation")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if query.Get("restype") == "container" && query.Get("comp") != "list" {
+			w.Header().Set("x-ms-error-code", "AuthorizationResourceTypeMismatch")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs/></EnumerationResults>`))
+	}))
+	defer server.Close()
+
+	d := Diagnose(context.Background(), Config{
+		ContainerName:    "caddy",
+		ConnectionString: "BlobEndpoint=" + server.URL + "/devstoreaccount1;SharedAccessSignature=sv=2024-01-01&sr=c&sp=rl&sig=abc",
+		ReadOnly:         true,
+	})
+
+	require.False(t, d.Failed(), "%+v", d.Steps)
+	statuses := statusesOf(d)
+	assert.Equal(t, DiagnosticPass, statuses[StepContainer])
+	assert.Equal(t, DiagnosticPass, statuses[StepList])
+}
+
+func TestDiagnoseReportsMissingContainer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", "ContainerNotFound")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	d := Diagnose(context.Background(), Config{
+		ContainerName: "caddy",
+		ConnectionString: "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;" +
+			"AccountKey=" + base64.StdEncoding.EncodeToString([]byte("account-key")) + ";" +
+			"BlobEndpoint=" + server.URL + "/devstoreaccount1;",
+		ReadOnly: true,
+	})
+
+	statuses := statusesOf(d)
+	assert.Equal(t, DiagnosticFail, statuses[StepContainer])
+	assert.Equal(t, DiagnosticSkip, statuses[StepList])
+}
+
+func TestDiagnoseReportsUnreachableEndpoint(t *testing.T) {
+	d := Diagnose(context.Background(), Config{
+		ContainerName: "caddy",
+		ConnectionString: "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;" +
+			"AccountKey=" + base64.StdEncoding.EncodeToString([]byte("account-key")) + ";" +
+			"BlobEndpoint=http://127.0.0.1:1/devstoreaccount1;",
+	})
+
+	statuses := statusesOf(d)
+	assert.Equal(t, DiagnosticFail, statuses[StepEndpoint])
+	assert.Equal(t, DiagnosticSkip, statuses[StepContainer])
+}
+
+func TestDiagnosticHint(t *testing.T) {
+	for code, want := range map[string]string{
+		"AuthorizationFailure":               "firewall",
+		"AuthorizationResourceTypeMismatch":  "SAS token",
+		"ContainerNotFound":                  "does not exist",
+		"KeyBasedAuthenticationNotPermitted": "Microsoft Entra ID",
+	} {
+		assert.Contains(t, diagnosticHint(StepWrite, &azcore.ResponseError{ErrorCode: code, StatusCode: http.StatusForbidden}), want, code)
+	}
+	assert.Contains(t, diagnosticHint(StepList, &azcore.ResponseError{ErrorCode: "AuthorizationPermissionMismatch"}), "Storage Blob Data Reader")
+	assert.Empty(t, diagnosticHint(StepList, &azcore.ResponseError{ErrorCode: "Other", StatusCode: http.StatusBadRequest}))
+}
+
+func TestDiagnoseStorage(t *testing.T) {
+	s := setupTestStorage(t)
+
+	d := s.Diagnose(context.Background())
+	require.False(t, d.Failed(), "%+v", d.Steps)
+	statuses := statusesOf(d)
+	for _, step := range []string{StepContainer, StepList, StepWrite, StepRead, StepLease, StepDelete} {
+		assert.Equal(t, DiagnosticPass, statuses[step], step)
+	}
+}
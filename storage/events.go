@@ -0,0 +1,30 @@
+package storage
+
+// Names of the events passed to Config.Events.
+const (
+	// EventStored is emitted after a key is stored. Data: key, size.
+	EventStored = "azureblob.stored"
+	// EventDeleted is emitted for every key removed by Delete. Data: key.
+	EventDeleted = "azureblob.deleted"
+	// EventUndeleted is emitted for every key restored by Undelete. Data: key.
+	EventUndeleted = "azureblob.undeleted"
+	// EventLockAcquired is emitted after Lock obtains a lock. Data: key, wait.
+	EventLockAcquired = "azureblob.lock_acquired"
+	// EventLockReleased is emitted after Unlock releases a lock (data: key, held) and
+	// after ForceUnlock breaks one (data: key, forced).
+	EventLockReleased = "azureblob.lock_released"
+	// EventLockLost is emitted when a held lock's lease could not be renewed and may
+	// have expired, so another process may acquire it. Data: key, error.
+	EventLockLost = "azureblob.lock_lost"
+	// EventReplicationDropped is emitted for every async write to the secondary that is
+	// dropped: because the queue was full, its attempts were exhausted or replication
+	// stopped before it was applied. Data: key, reason.
+	EventReplicationDropped = "azureblob.replication_dropped"
+)
+
+// emit passes an event to the configured event handler, if any.
+func (s *Storage) emit(name string, data map[string]any) {
+	if s.events != nil {
+		s.events(name, data)
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedEvent is a single call to Config.Events.
+type recordedEvent struct {
+	data map[string]any
+	name string
+}
+
+// eventRecorder collects the events emitted by a Storage.
+type eventRecorder struct {
+	events []recordedEvent
+	mu     sync.Mutex
+}
+
+func (r *eventRecorder) emit(name string, data map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, recordedEvent{name: name, data: data})
+}
+
+// names returns the names of the recorded events for key, in order.
+func (r *eventRecorder) names(key string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var names []string
+	for _, e := range r.events {
+		if e.data["key"] == key {
+			names = append(names, e.name)
+		}
+	}
+	return names
+}
+
+func TestLockLostEvent(t *testing.T) {
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: statusTransport{status: http.StatusConflict, errorCode: "LeaseIdMismatchWithLeaseOperation"},
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	require.NoError(t, err)
+	leaseClient, err := lease.NewBlobClient(containerClient.NewBlobClient("key.lock"), nil)
+	require.NoError(t, err)
+
+	recorder := &eventRecorder{}
+	s := newStorage(azureBlobs{containerClient}, Config{Events: recorder.emit})
+
+	// The renewer returns after the first failed renewal.
+	s.runLeaseRenewer(context.Background(), "key", leaseClient, 1)
+
+	require.Equal(t, []string{EventLockLost}, recorder.names("key"))
+	assert.Contains(t, recorder.events[0].data["error"], "LeaseIdMismatchWithLeaseOperation")
+}
+
+// Test that mutations and lock changes emit events.
+func TestEvents(t *testing.T) {
+	recorder := &eventRecorder{}
+	s := setupTestStorageWith(t, func(c *Config) { c.Events = recorder.emit })
+	ctx := context.Background()
+
+	key := "events-test/file.txt"
+	require.NoError(t, s.Store(ctx, key, []byte("value")))
+	require.NoError(t, s.Lock(ctx, key))
+	require.NoError(t, s.Unlock(ctx, key))
+	require.NoError(t, s.Delete(ctx, key))
+	require.NoError(t, s.Delete(ctx, key), "Deleting a missing key should not emit another event")
+
+	assert.Equal(t, []string{EventStored, EventLockAcquired, EventLockReleased, EventDeleted}, recorder.names(key))
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/webedmj/certmagic-azureblob/storage/storagetest"
+)
+
+// setupFakeStorage returns a Storage backed by an in-memory storagetest.Server, with a
+// hook to adjust the Config before the storage is created.
+func setupFakeStorage(t *testing.T, configure func(*Config)) *Storage {
+	t.Helper()
+	server := storagetest.NewServer()
+	t.Cleanup(server.Close)
+	config := Config{
+		AccountName:      storagetest.AccountName,
+		ContainerName:    testContainerName,
+		ConnectionString: server.ConnectionString(),
+	}
+	configure(&config)
+	s, err := NewStorage(context.Background(), config)
+	require.NoError(t, err)
+	return s
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.RunConformance(t, setupFakeStorage(t, func(*Config) {}))
+}
+
+func TestConformanceEscapedKeys(t *testing.T) {
+	storagetest.RunConformance(t, setupFakeStorage(t, func(config *Config) {
+		config.KeyCodec = EscapedKeys{}
+		config.Cache = &CacheConfig{TTL: time.Minute}
+	}))
+}
+
+func TestCacheRevalidatesUnchangedBlob(t *testing.T) {
+	s := setupFakeStorage(t, func(config *Config) {
+		config.Cache = &CacheConfig{TTL: time.Millisecond}
+	})
+	ctx := context.Background()
+	require.NoError(t, s.Store(ctx, "certificates/a.crt", []byte("certificate")))
+
+	for range 3 {
+		value, err := s.Load(ctx, "certificates/a.crt")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("certificate"), value, "A revalidated entry should keep its value")
+		time.Sleep(2 * time.Millisecond)
+	}
+}
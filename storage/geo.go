@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.uber.org/zap"
+)
+
+// ErrPrimaryUnavailable is returned for writes and locks while the primary endpoint is
+// unavailable and reads are served from the geo-redundant secondary endpoint.
+var ErrPrimaryUnavailable = errors.New("primary storage endpoint unavailable")
+
+// DefaultPrimaryRecheckInterval is how long the primary endpoint is considered down
+// after reads failed on it, before requests are sent to it again.
+const DefaultPrimaryRecheckInterval = 30 * time.Second
+
+// primaryUnavailableError is returned by secondaryEndpointPolicy for requests that
+// cannot be served while the primary endpoint is down. The SDK does not retry it.
+type primaryUnavailableError struct {
+	cause error
+}
+
+func (e *primaryUnavailableError) Error() string {
+	if e.cause == nil {
+		return ErrPrimaryUnavailable.Error()
+	}
+	return ErrPrimaryUnavailable.Error() + ": " + e.cause.Error()
+}
+
+func (e *primaryUnavailableError) Is(target error) bool { return target == ErrPrimaryUnavailable }
+func (e *primaryUnavailableError) Unwrap() error        { return e.cause }
+func (e *primaryUnavailableError) NonRetriable()        {}
+
+// primaryFailureThreshold is how many reads in a row must fail on the primary endpoint
+// before it is considered down.
+const primaryFailureThreshold = 3
+
+// secondaryEndpointPolicy sends reads (GET and HEAD) to the read-access geo-redundant
+// secondary endpoint, <account>-secondary, when the primary endpoint fails. After
+// primaryFailureThreshold failed reads in a row the primary is considered down for
+// recheck: reads go straight to the secondary and requests other than reads that are
+// made in that time fail with ErrPrimaryUnavailable. Failed writes do not count, and the
+// SDK's retries of a request made before the primary went down still reach it.
+type secondaryEndpointPolicy struct {
+	logger *zap.Logger
+	state  *primaryState
+	// recheck is how long the primary is considered down.
+	recheck time.Duration
+}
+
+// primaryState tracks the primary endpoint of one account.
+type primaryState struct {
+	// failures counts the reads that failed in a row.
+	failures atomic.Int32
+	// downSince and downUntil are the Unix nanosecond times the primary is considered
+	// down between.
+	downSince atomic.Int64
+	downUntil atomic.Int64
+}
+
+// primaryCall is the operation value that secondaryEndpointCallPolicy stores for every
+// request: the context of the caller, which the retry policy replaces with a context
+// per try, and when the request was made.
+type primaryCall struct {
+	ctx   context.Context
+	start time.Time
+}
+
+// secondaryEndpointCallPolicy records the primaryCall of a request for
+// secondaryEndpointPolicy. It runs once per request, before the SDK's retries.
+type secondaryEndpointCallPolicy struct{}
+
+func (secondaryEndpointCallPolicy) Do(req *policy.Request) (*http.Response, error) {
+	req.SetOperationValue(primaryCall{ctx: req.Raw().Context(), start: time.Now()})
+	return req.Next()
+}
+
+func newSecondaryEndpointPolicy(logger *zap.Logger) secondaryEndpointPolicy {
+	return secondaryEndpointPolicy{
+		logger:  logger,
+		state:   new(primaryState),
+		recheck: DefaultPrimaryRecheckInterval,
+	}
+}
+
+func (p secondaryEndpointPolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	secondaryHost := secondaryEndpointHost(raw.URL.Host)
+	if secondaryHost == "" {
+		return req.Next()
+	}
+	read := raw.Method == http.MethodGet || raw.Method == http.MethodHead
+	call := primaryCall{ctx: raw.Context()}
+	req.OperationValue(&call)
+
+	if p.primaryDown() {
+		if read {
+			return p.readSecondary(req, call, secondaryHost)
+		}
+		if !call.start.Before(time.Unix(0, p.state.downSince.Load())) {
+			return nil, &primaryUnavailableError{}
+		}
+	}
+
+	resp, err := req.Next()
+	if !primaryFailed(call.ctx, resp, err) {
+		if err == nil && resp.StatusCode < 500 {
+			p.state.failures.Store(0)
+		}
+		return resp, err
+	}
+	if !read {
+		// A failed write does not take the primary down, so the SDK retries it there
+		return resp, err
+	}
+	if p.state.failures.Add(1) >= primaryFailureThreshold {
+		p.markDown(raw, resp, err)
+	}
+
+	secondaryResp, secondaryErr := p.readSecondary(req, call, secondaryHost)
+	if secondaryErr != nil || secondaryResp.StatusCode >= 500 {
+		if secondaryResp != nil {
+			secondaryResp.Body.Close()
+		}
+		return resp, err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return secondaryResp, nil
+}
+
+// readSecondary sends req to the secondary endpoint. If the try of req timed out on the
+// primary, the secondary is read within the deadline of call instead.
+func (p secondaryEndpointPolicy) readSecondary(req *policy.Request, call primaryCall, host string) (*http.Response, error) {
+	ctx := req.Raw().Context()
+	if ctx.Err() != nil {
+		ctx = call.ctx
+	}
+	secondaryReq := req.Clone(ctx)
+	secondaryReq.Raw().URL.Host = host
+	secondaryReq.Raw().Host = ""
+	return secondaryReq.Next()
+}
+
+func (p secondaryEndpointPolicy) primaryDown() bool {
+	return time.Now().UnixNano() < p.state.downUntil.Load()
+}
+
+// markDown considers the primary endpoint down for the recheck interval.
+func (p secondaryEndpointPolicy) markDown(raw *http.Request, resp *http.Response, err error) {
+	now := time.Now()
+	p.state.failures.Store(0)
+	p.state.downSince.Store(now.UnixNano())
+	p.state.downUntil.Store(now.Add(p.recheck).UnixNano())
+
+	fields := []zap.Field{zap.String("host", raw.URL.Host), zap.Duration("recheck", p.recheck)}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	} else {
+		fields = append(fields, responseFields(resp)...)
+	}
+	p.logger.Warn("primary storage endpoint unavailable; reading from secondary endpoint", fields...)
+}
+
+// primaryFailed reports whether a response means the primary endpoint is unavailable.
+// Throttling is not an outage, and neither is a request the caller abandoned, i.e. one
+// whose ctx is done; a try that timed out while ctx is not counts.
+func primaryFailed(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	return resp.StatusCode >= 500 && resp.Header.Get(headerErrorCode) != "ServerBusy"
+}
+
+// secondaryEndpointHost returns the secondary endpoint of a primary account host, or ""
+// if host has none (e.g. an emulator or a custom domain addressed by IP).
+func secondaryEndpointHost(host string) string {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	account, rest, ok := strings.Cut(host, ".")
+	if !ok || net.ParseIP(hostname) != nil || strings.HasSuffix(account, "-secondary") {
+		return ""
+	}
+	return account + "-secondary." + rest
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// hostTransport answers requests with a fixed status and error code per host, and
+// records the hosts it was asked for.
+type hostTransport struct {
+	responses map[string]statusTransport
+	hosts     []string
+	mu        sync.Mutex
+}
+
+func (t *hostTransport) Do(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.hosts = append(t.hosts, req.URL.Host)
+	t.mu.Unlock()
+	return t.responses[req.URL.Host].Do(req)
+}
+
+func (t *hostTransport) requests() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.hosts...)
+}
+
+// flakyTransport answers the first failures requests with 503 Service Unavailable, the
+// other reads with 200 OK and the other writes with 201 Created, and records the hosts
+// it was asked for.
+type flakyTransport struct {
+	hostTransport
+	failures int
+}
+
+func (t *flakyTransport) Do(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.hosts = append(t.hosts, req.URL.Host)
+	fail := len(t.hosts) <= t.failures
+	t.mu.Unlock()
+	if fail {
+		return statusTransport{status: http.StatusServiceUnavailable, errorCode: "InternalError"}.Do(req)
+	}
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return statusTransport{status: http.StatusOK}.Do(req)
+	}
+	return statusTransport{status: http.StatusCreated}.Do(req)
+}
+
+// hangingPrimaryTransport never answers requests to the primary endpoint, and answers
+// requests to the secondary endpoint with 200 OK.
+type hangingPrimaryTransport struct {
+	hostTransport
+}
+
+func (t *hangingPrimaryTransport) Do(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.hosts = append(t.hosts, req.URL.Host)
+	t.mu.Unlock()
+	if strings.HasPrefix(req.URL.Host, "account-secondary.") {
+		return statusTransport{status: http.StatusOK}.Do(req)
+	}
+	return hangingTransport{}.Do(req)
+}
+
+// newGeoClient returns a Storage on transport that reads from the secondary endpoint.
+func newGeoClient(t *testing.T, transport policy.Transporter, retry policy.RetryOptions) *Storage {
+	t.Helper()
+	options := accountOptions(Config{ReadSecondaryEndpoint: true, Logger: zap.NewNop()}, azcore.ClientOptions{
+		Transport: transport,
+		Retry:     retry,
+	})
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: options,
+	})
+	require.NoError(t, err)
+	return newStorage(azureBlobs{containerClient}, Config{})
+}
+
+// newGeoStorage returns a Storage reading from the secondary endpoint, where the
+// primary endpoint answers with primaryStatus and the secondary with 200 OK.
+func newGeoStorage(t *testing.T, primaryStatus int, primaryErrorCode string, retry policy.RetryOptions) (*Storage, *hostTransport) {
+	t.Helper()
+	transport := &hostTransport{responses: map[string]statusTransport{
+		"account.blob.core.windows.net":           {status: primaryStatus, errorCode: primaryErrorCode},
+		"account-secondary.blob.core.windows.net": {status: http.StatusOK},
+	}}
+	return newGeoClient(t, transport, retry), transport
+}
+
+func TestSecondaryEndpointHost(t *testing.T) {
+	assert.Equal(t, "account-secondary.blob.core.windows.net", secondaryEndpointHost("account.blob.core.windows.net"))
+	assert.Equal(t, "account-secondary.blob.core.windows.net:443", secondaryEndpointHost("account.blob.core.windows.net:443"))
+	assert.Empty(t, secondaryEndpointHost("account-secondary.blob.core.windows.net"))
+	assert.Empty(t, secondaryEndpointHost("127.0.0.1:10000"))
+	assert.Empty(t, secondaryEndpointHost("localhost:10000"))
+}
+
+func TestSecondaryEndpointServesReads(t *testing.T) {
+	s, transport := newGeoStorage(t, http.StatusInternalServerError, "InternalError", policy.RetryOptions{MaxRetries: -1})
+	ctx := context.Background()
+
+	var want []string
+	for range primaryFailureThreshold {
+		assert.True(t, s.Exists(ctx, "key.txt"))
+		want = append(want, "account.blob.core.windows.net", "account-secondary.blob.core.windows.net")
+	}
+	assert.Equal(t, want, transport.requests())
+
+	// Once the primary is down, reads skip it and writes fail without a request
+	assert.True(t, s.Exists(ctx, "key.txt"))
+	err := s.Store(ctx, "key.txt", []byte("value"))
+	require.ErrorIs(t, err, ErrPrimaryUnavailable)
+	assert.Equal(t, append(want, "account-secondary.blob.core.windows.net"), transport.requests())
+}
+
+// Test that failed writes are retried on the primary instead of taking it down.
+func TestSecondaryEndpointRetriesWrites(t *testing.T) {
+	s, transport := newGeoStorage(t, http.StatusInternalServerError, "InternalError", policy.RetryOptions{
+		MaxRetries: 3,
+		RetryDelay: time.Millisecond,
+	})
+
+	err := s.Store(context.Background(), "key.txt", []byte("value"))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrPrimaryUnavailable)
+	assert.Equal(t, slices.Repeat([]string{"account.blob.core.windows.net"}, 4), transport.requests(), "Every retry should reach the primary")
+}
+
+func TestSecondaryEndpointWriteSucceedsOnRetry(t *testing.T) {
+	transport := &flakyTransport{failures: 1}
+	s := newGeoClient(t, transport, policy.RetryOptions{MaxRetries: 3, RetryDelay: time.Millisecond})
+	ctx := context.Background()
+
+	require.NoError(t, s.Store(ctx, "key.txt", []byte("value")))
+	require.NoError(t, s.Store(ctx, "key.txt", []byte("value")))
+	assert.Equal(t, slices.Repeat([]string{"account.blob.core.windows.net"}, 3), transport.requests())
+}
+
+// Test that a single failed read falls back to the secondary without taking the
+// primary down for the requests that follow.
+func TestSecondaryEndpointToleratesSingleFailedRead(t *testing.T) {
+	transport := &flakyTransport{failures: 1}
+	s := newGeoClient(t, transport, policy.RetryOptions{MaxRetries: -1})
+	ctx := context.Background()
+
+	assert.True(t, s.Exists(ctx, "key.txt"))
+	require.NoError(t, s.Store(ctx, "key.txt", []byte("value")))
+	assert.Equal(t, []string{
+		"account.blob.core.windows.net",
+		"account-secondary.blob.core.windows.net",
+		"account.blob.core.windows.net",
+	}, transport.requests())
+}
+
+// Test that reads whose tries time out on a primary that does not answer count as
+// failed, while the caller is still waiting.
+func TestSecondaryEndpointDetectsHangingPrimary(t *testing.T) {
+	transport := &hangingPrimaryTransport{}
+	s := newGeoClient(t, transport, policy.RetryOptions{MaxRetries: -1, TryTimeout: 10 * time.Millisecond})
+	ctx := context.Background()
+
+	for range primaryFailureThreshold {
+		assert.True(t, s.Exists(ctx, "key.txt"))
+	}
+	require.ErrorIs(t, s.Store(ctx, "key.txt", []byte("value")), ErrPrimaryUnavailable)
+	assert.Len(t, transport.requests(), 2*primaryFailureThreshold)
+}
+
+func TestSecondaryEndpointIgnoresThrottling(t *testing.T) {
+	s, transport := newGeoStorage(t, http.StatusServiceUnavailable, "ServerBusy", policy.RetryOptions{MaxRetries: -1})
+
+	assert.False(t, s.Exists(context.Background(), "key.txt"))
+	err := s.Store(context.Background(), "key.txt", []byte("value"))
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrPrimaryUnavailable)
+	for _, host := range transport.requests() {
+		assert.False(t, strings.HasPrefix(host, "account-secondary"), "Throttled requests should stay on the primary")
+	}
+}
+
+func TestSecondaryEndpointRechecksPrimary(t *testing.T) {
+	p := newSecondaryEndpointPolicy(zap.NewNop())
+	p.recheck = time.Millisecond
+	p.markDown(&http.Request{URL: &url.URL{Host: "account.blob.core.windows.net"}}, nil, io.ErrUnexpectedEOF)
+	assert.True(t, p.primaryDown())
+	assert.Eventually(t, func() bool { return !p.primaryDown() }, time.Second, time.Millisecond)
+}
+
+// Test that every account tracks the state of its own primary endpoint, so that an
+// outage of one account does not send the reads of another to its secondary.
+func TestAccountOptionsTrackEachAccount(t *testing.T) {
+	config := Config{ReadSecondaryEndpoint: true, Logger: zap.NewNop()}
+	endpointPolicy := func(options azcore.ClientOptions) secondaryEndpointPolicy {
+		for _, p := range options.PerRetryPolicies {
+			if p, ok := p.(secondaryEndpointPolicy); ok {
+				return p
+			}
+		}
+		require.FailNow(t, "no secondary endpoint policy")
+		return secondaryEndpointPolicy{}
+	}
+	primary := endpointPolicy(accountOptions(config, azcore.ClientOptions{}))
+	route := endpointPolicy(accountOptions(config, azcore.ClientOptions{}))
+
+	primary.markDown(&http.Request{URL: &url.URL{Host: "account.blob.core.windows.net"}}, nil, io.ErrUnexpectedEOF)
+	assert.True(t, primary.primaryDown())
+	assert.False(t, route.primaryDown(), "A route's account should not share the primary's state")
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.uber.org/zap"
+)
+
+const (
+	headerRequestID = "x-ms-request-id"
+	headerErrorCode = "x-ms-error-code"
+)
+
+// requestLogPolicy logs every failed or throttled attempt of an Azure request. It runs
+// once per try, so requests that the SDK retries are logged for each retry.
+type requestLogPolicy struct {
+	logger *zap.Logger
+}
+
+func (p requestLogPolicy) Do(req *policy.Request) (*http.Response, error) {
+	resp, err := req.Next()
+
+	raw := req.Raw()
+	fields := []zap.Field{
+		zap.String("method", raw.Method),
+		zap.String("path", raw.URL.Path),
+	}
+	switch {
+	case err != nil:
+		p.logger.Warn("azure request failed", append(fields, zap.Error(err))...)
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		p.logger.Warn("azure request throttled", append(fields, responseFields(resp)...)...)
+	case resp.StatusCode >= 500:
+		p.logger.Warn("azure request failed", append(fields, responseFields(resp)...)...)
+	}
+	return resp, err
+}
+
+// responseFields describes an Azure response for logging.
+func responseFields(resp *http.Response) []zap.Field {
+	return []zap.Field{
+		zap.Int("status", resp.StatusCode),
+		zap.String("error_code", resp.Header.Get(headerErrorCode)),
+		zap.String("request_id", resp.Header.Get(headerRequestID)),
+	}
+}
+
+// errorFields describes err for logging, including the Azure error code and request ID
+// when err carries an Azure response.
+func errorFields(err error) []zap.Field {
+	fields := []zap.Field{zap.Error(err)}
+	var responseError *azcore.ResponseError
+	if errors.As(err, &responseError) {
+		fields = append(fields, zap.String("error_code", responseError.ErrorCode))
+		if responseError.RawResponse != nil {
+			fields = append(fields, zap.String("request_id", responseError.RawResponse.Header.Get(headerRequestID)))
+		}
+	}
+	return fields
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// statusTransport answers every request with a fixed status and error code.
+type statusTransport struct {
+	status    int
+	errorCode string
+}
+
+func (t statusTransport) Do(req *http.Request) (*http.Response, error) {
+	header := http.Header{}
+	header.Set(headerRequestID, "test-request-id")
+	header.Set(headerErrorCode, t.errorCode)
+	return &http.Response{
+		StatusCode: t.status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestRequestLogPolicyLogsThrottling(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport:        statusTransport{status: http.StatusServiceUnavailable, errorCode: "ServerBusy"},
+			Retry:            policy.RetryOptions{MaxRetries: -1},
+			PerRetryPolicies: []policy.Policy{requestLogPolicy{logger: zap.New(core)}},
+		},
+	})
+	require.NoError(t, err)
+
+	s := newStorage(azureBlobs{containerClient}, Config{Logger: zap.New(core)})
+	assert.False(t, s.Exists(context.Background(), "key.txt"))
+
+	throttled := logs.FilterMessage("azure request throttled").All()
+	require.Len(t, throttled, 1)
+	fields := throttled[0].ContextMap()
+	assert.Equal(t, "ServerBusy", fields["error_code"])
+	assert.Equal(t, "test-request-id", fields["request_id"])
+	assert.Equal(t, "/container/key.txt", fields["path"])
+}
+
+func TestErrorFields(t *testing.T) {
+	header := http.Header{}
+	header.Set(headerRequestID, "abc")
+	err := &azcore.ResponseError{
+		StatusCode:  403,
+		ErrorCode:   "AuthorizationFailure",
+		RawResponse: &http.Response{Header: header},
+	}
+
+	core, logs := observer.New(zap.DebugLevel)
+	zap.New(core).Warn("failed", errorFields(err)...)
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "AuthorizationFailure", fields["error_code"])
+	assert.Equal(t, "abc", fields["request_id"])
+}
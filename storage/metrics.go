@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// Operation names passed to Metrics.ObserveOperation.
+const (
+	OpStore  = "store"
+	OpLoad   = "load"
+	OpDelete = "delete"
+	OpList   = "list"
+	OpStat   = "stat"
+	OpExists = "exists"
+)
+
+// Outcomes passed to Metrics.ObserveOperation.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+// Metrics receives measurements from a Storage. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// ObserveOperation records one storage operation. errorCode is the Azure error code
+	// (e.g. "AuthorizationFailure") when outcome is OutcomeError and Azure returned one.
+	// Delete is observed once per blob it removes. Reads answered from the local mirror
+	// are observed with the Azure failure that caused the fallback.
+	ObserveOperation(op, outcome, errorCode string, duration time.Duration)
+	// ObserveLockAcquired records a successful Lock and how long it waited for the lease.
+	ObserveLockAcquired(wait time.Duration)
+	// LockContended records a Lock retry because the lease was held by someone else.
+	LockContended()
+	// ObserveLockRenewal records a lease renewal attempt.
+	ObserveLockRenewal(failed bool)
+	// AddLocksHeld adjusts the number of currently held locks by delta.
+	AddLocksHeld(delta int)
+}
+
+// nopMetrics discards all measurements.
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveOperation(string, string, string, time.Duration) {}
+func (nopMetrics) ObserveLockAcquired(time.Duration)                      {}
+func (nopMetrics) LockContended()                                         {}
+func (nopMetrics) ObserveLockRenewal(bool)                                {}
+func (nopMetrics) AddLocksHeld(int)                                       {}
+
+// observe records the outcome of an operation that started at start.
+func (s *Storage) observe(op string, start time.Time, err error) {
+	outcome, code := OutcomeSuccess, ""
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		outcome = OutcomeNotFound
+	default:
+		outcome = OutcomeError
+		var responseError *azcore.ResponseError
+		if errors.As(err, &responseError) {
+			code = responseError.ErrorCode
+		}
+	}
+	s.metrics.ObserveOperation(op, outcome, code, time.Since(start))
+}
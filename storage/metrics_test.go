@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedOperation is a single ObserveOperation call.
+type recordedOperation struct {
+	op, outcome, errorCode string
+}
+
+// recordingMetrics is a Metrics that remembers what it was given.
+type recordingMetrics struct {
+	operations    []recordedOperation
+	lockAcquired  int
+	lockContended int
+	renewals      int
+	renewFailures int
+	locksHeld     int
+	mu            sync.Mutex
+}
+
+func (m *recordingMetrics) ObserveOperation(op, outcome, errorCode string, _ time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operations = append(m.operations, recordedOperation{op, outcome, errorCode})
+}
+
+func (m *recordingMetrics) ObserveLockAcquired(time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lockAcquired++
+}
+
+func (m *recordingMetrics) LockContended() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lockContended++
+}
+
+func (m *recordingMetrics) ObserveLockRenewal(failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renewals++
+	if failed {
+		m.renewFailures++
+	}
+}
+
+func (m *recordingMetrics) AddLocksHeld(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.locksHeld += delta
+}
+
+func TestObserveOutcomes(t *testing.T) {
+	m := &recordingMetrics{}
+	s := &Storage{metrics: m}
+	start := time.Now()
+
+	s.observe(OpStore, start, nil)
+	s.observe(OpLoad, start, fmt.Errorf("reading blob: %w", fs.ErrNotExist))
+	s.observe(OpStat, start, fmt.Errorf("getting properties: %w", &azcore.ResponseError{StatusCode: 403, ErrorCode: "AuthorizationFailure"}))
+
+	assert.Equal(t, []recordedOperation{
+		{OpStore, OutcomeSuccess, ""},
+		{OpLoad, OutcomeNotFound, ""},
+		{OpStat, OutcomeError, "AuthorizationFailure"},
+	}, m.operations)
+}
+
+// Test that operations and locks against Azure are reported to the configured Metrics.
+func TestMetricsRecorded(t *testing.T) {
+	m := &recordingMetrics{}
+	s := setupTestStorageWith(t, func(c *Config) { c.Metrics = m })
+	ctx := context.Background()
+
+	key := "metrics-test/file.txt"
+	require.NoError(t, s.Store(ctx, key, []byte("value")))
+	t.Cleanup(func() { _ = s.Delete(context.Background(), key) })
+	_, err := s.Load(ctx, "metrics-test/missing.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	require.NoError(t, s.Lock(ctx, key))
+	assert.Equal(t, 1, m.locksHeld)
+	require.NoError(t, s.RenewLockLease(ctx, key, time.Minute))
+	require.NoError(t, s.Unlock(ctx, key))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	assert.Contains(t, m.operations, recordedOperation{OpStore, OutcomeSuccess, ""})
+	assert.Contains(t, m.operations, recordedOperation{OpLoad, OutcomeNotFound, ""})
+	assert.Equal(t, 1, m.lockAcquired)
+	assert.Equal(t, 1, m.renewals)
+	assert.Equal(t, 0, m.locksHeld)
+}
@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+)
+
+// MigrateAction describes what Migrate did with a single key.
+type MigrateAction string
+
+const (
+	// MigrateCopied means the key was copied (or, in dry-run mode, would be copied).
+	MigrateCopied MigrateAction = "copied"
+	// MigrateSkipped means the destination already held an identical value.
+	MigrateSkipped MigrateAction = "skipped"
+)
+
+// MigrateOptions controls Migrate.
+type MigrateOptions struct {
+	// Progress, if set, is called after each key is processed.
+	Progress func(key string, action MigrateAction)
+	// Prefix limits the migration to keys that start with Prefix.
+	Prefix string
+	// ResumeAfter skips every key that sorts at or before it. Set it to the LastKey
+	// of a previous, interrupted run to continue where that run stopped.
+	ResumeAfter string
+	// DryRun reports what would be copied without writing anything.
+	DryRun bool
+}
+
+// MigrateReport summarizes a Migrate run.
+type MigrateReport struct {
+	// Copied lists the keys that were (or would be) copied.
+	Copied []string
+	// Skipped lists the keys whose destination value was already identical.
+	Skipped []string
+	// LastKey is the last key that was fully processed, for use with ResumeAfter.
+	LastKey string
+}
+
+// Migrate copies every key from src to dst, in either direction between
+// certmagic.FileStorage and Storage (or any other certmagic.Storage). Keys are processed
+// in sorted order, lock keys are never copied, and keys whose destination value is
+// already identical are skipped, so an interrupted migration can simply be run again or
+// resumed with MigrateOptions.ResumeAfter.
+//
+// Modification times are preserved: Storage records them in blob metadata, and
+// certmagic.FileStorage sets them on the copied files.
+func Migrate(ctx context.Context, src, dst certmagic.Storage, opts MigrateOptions) (MigrateReport, error) {
+	var report MigrateReport
+
+	keys, err := src.List(ctx, opts.Prefix, true)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return report, fmt.Errorf("listing source keys: %w", err)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, lockSuffix) || (opts.ResumeAfter != "" && key <= opts.ResumeAfter) {
+			continue
+		}
+
+		action, err := migrateKey(ctx, src, dst, key, opts.DryRun)
+		if err != nil {
+			return report, fmt.Errorf("migrating %s: %w", key, err)
+		}
+		switch action {
+		case MigrateCopied:
+			report.Copied = append(report.Copied, key)
+		case MigrateSkipped:
+			report.Skipped = append(report.Skipped, key)
+		default:
+			// Not a terminal key (e.g. a FileStorage directory); nothing to do.
+			continue
+		}
+		report.LastKey = key
+		if opts.Progress != nil {
+			opts.Progress(key, action)
+		}
+	}
+
+	return report, nil
+}
+
+// migrateKey copies a single key and returns what was done. An empty action means the
+// key is not terminal and was ignored.
+func migrateKey(ctx context.Context, src, dst certmagic.Storage, key string, dryRun bool) (MigrateAction, error) {
+	srcInfo, err := src.Stat(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("stat source: %w", err)
+	}
+	if !srcInfo.IsTerminal {
+		return "", nil
+	}
+
+	value, err := src.Load(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("loading source: %w", err)
+	}
+
+	dstInfo, err := dst.Stat(ctx, key)
+	switch {
+	case err == nil:
+		if dstInfo.IsTerminal && dstInfo.Size == srcInfo.Size {
+			existing, loadErr := dst.Load(ctx, key)
+			if loadErr != nil {
+				return "", fmt.Errorf("loading destination: %w", loadErr)
+			}
+			if bytes.Equal(existing, value) {
+				return MigrateSkipped, nil
+			}
+		}
+	case errors.Is(err, fs.ErrNotExist):
+	default:
+		return "", fmt.Errorf("stat destination: %w", err)
+	}
+
+	if dryRun {
+		return MigrateCopied, nil
+	}
+	if err := storeWithModified(ctx, dst, key, value, srcInfo.Modified); err != nil {
+		return "", fmt.Errorf("storing destination: %w", err)
+	}
+	return MigrateCopied, nil
+}
+
+// storeWithModified stores value in dst, preserving modified where dst supports it.
+func storeWithModified(ctx context.Context, dst certmagic.Storage, key string, value []byte, modified time.Time) error {
+	switch d := dst.(type) {
+	case *Storage:
+		return d.storeModified(ctx, key, value, modified)
+	case *certmagic.FileStorage:
+		if err := d.Store(ctx, key, value); err != nil {
+			return err
+		}
+		return os.Chtimes(d.Filename(key), modified, modified)
+	default:
+		return dst.Store(ctx, key, value)
+	}
+}
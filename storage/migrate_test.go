@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var migrateTestKeys = map[string][]byte{
+	"certificates/issuer/example.com/example.com.crt":  []byte("cert"),
+	"certificates/issuer/example.com/example.com.key":  []byte("key"),
+	"certificates/issuer/example.com/example.com.json": []byte("{}"),
+	"acme/issuer/users/admin@example.com/admin.key":    []byte("account key"),
+}
+
+func TestMigrateBetweenFileStorages(t *testing.T) {
+	ctx := context.Background()
+	src := &certmagic.FileStorage{Path: t.TempDir()}
+	dst := &certmagic.FileStorage{Path: t.TempDir()}
+
+	for key, value := range migrateTestKeys {
+		require.NoError(t, src.Store(ctx, key, value))
+	}
+	require.NoError(t, src.Store(ctx, "locks/issuer.lock", []byte("lock")))
+
+	// Dry run reports everything but writes nothing.
+	report, err := Migrate(ctx, src, dst, MigrateOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Len(t, report.Copied, len(migrateTestKeys))
+	assert.False(t, dst.Exists(ctx, "certificates/issuer/example.com/example.com.crt"))
+
+	report, err = Migrate(ctx, src, dst, MigrateOptions{})
+	require.NoError(t, err)
+	assert.Len(t, report.Copied, len(migrateTestKeys))
+	assert.Empty(t, report.Skipped)
+	assert.False(t, dst.Exists(ctx, "locks/issuer.lock"), "Lock files must not be migrated")
+
+	for key, value := range migrateTestKeys {
+		loaded, err := dst.Load(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, value, loaded)
+
+		srcInfo, err := src.Stat(ctx, key)
+		require.NoError(t, err)
+		dstInfo, err := dst.Stat(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, srcInfo.Modified.Equal(dstInfo.Modified), "Modification time should be preserved for %s", key)
+	}
+
+	// Running again skips identical keys.
+	report, err = Migrate(ctx, src, dst, MigrateOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, report.Copied)
+	assert.Len(t, report.Skipped, len(migrateTestKeys))
+}
+
+func TestMigrateResumeAfter(t *testing.T) {
+	ctx := context.Background()
+	src := &certmagic.FileStorage{Path: t.TempDir()}
+	dst := &certmagic.FileStorage{Path: t.TempDir()}
+
+	for key, value := range migrateTestKeys {
+		require.NoError(t, src.Store(ctx, key, value))
+	}
+
+	report, err := Migrate(ctx, src, dst, MigrateOptions{
+		ResumeAfter: "certificates/issuer/example.com/example.com.crt",
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"certificates/issuer/example.com/example.com.json",
+		"certificates/issuer/example.com/example.com.key",
+	}, report.Copied)
+	assert.Equal(t, "certificates/issuer/example.com/example.com.key", report.LastKey)
+}
+
+// Test migrating from FileStorage into Azure and back, preserving modification times.
+func TestMigrateFileStorageRoundTrip(t *testing.T) {
+	s := setupTestStorage(t)
+	ctx := context.Background()
+
+	prefix := "migrate-test/"
+	key := prefix + "example.com.crt"
+	modified := time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+
+	src := &certmagic.FileStorage{Path: t.TempDir()}
+	require.NoError(t, storeWithModified(ctx, src, key, []byte("cert"), modified))
+	t.Cleanup(func() { _ = s.Delete(context.Background(), prefix) })
+
+	report, err := Migrate(ctx, src, s, MigrateOptions{Prefix: prefix})
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, report.Copied)
+
+	info, err := s.Stat(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, modified.Equal(info.Modified), "Stat should report the preserved modification time")
+
+	back := &certmagic.FileStorage{Path: t.TempDir()}
+	report, err = Migrate(ctx, s, back, MigrateOptions{Prefix: prefix})
+	require.NoError(t, err)
+	assert.Equal(t, []string{key}, report.Copied)
+
+	info, err = back.Stat(ctx, key)
+	require.NoError(t, err)
+	assert.True(t, modified.Equal(info.Modified), "Modification time should survive the round trip")
+}
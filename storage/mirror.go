@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// isUnavailable reports whether err means Azure could not be reached or failed on
+// its side (network errors and 5xx responses), as opposed to a definitive answer
+// such as a missing key or a permission error.
+func isUnavailable(err error) bool {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNotModified) || errors.Is(err, ErrArchived) {
+		return false
+	}
+	var responseError *azcore.ResponseError
+	if errors.As(err, &responseError) {
+		return responseError.StatusCode >= 500
+	}
+	return true
+}
+
+// useMirror reports whether a failed read should be served from the local mirror.
+// Reads abandoned by the caller are not retried locally.
+func (s *Storage) useMirror(ctx context.Context, err error) bool {
+	return s.mirror != nil && ctx.Err() == nil && isUnavailable(err)
+}
+
+// writeMirror copies value into the local mirror. Failures are logged but never fail
+// the Azure operation that produced the value.
+func (s *Storage) writeMirror(ctx context.Context, key string, value []byte) {
+	if s.mirror == nil {
+		return
+	}
+	if err := s.mirror.Store(ctx, key, value); err != nil {
+		s.logger.Warn("writing local mirror", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// removeMirror deletes key from the local mirror so deleted keys are not served later.
+func (s *Storage) removeMirror(ctx context.Context, key string) {
+	if s.mirror == nil {
+		return
+	}
+	if err := s.mirror.Delete(ctx, key); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.logger.Warn("removing key from local mirror", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func (s *Storage) warnMirror(op, key string, cause error) {
+	s.logger.Warn("azure blob storage unavailable; serving possibly stale data from local mirror",
+		zap.String("op", op),
+		zap.String("key", key),
+		zap.String("mirror", s.mirror.Path),
+		zap.NamedError("cause", cause))
+}
+
+// loadMirror serves Load from the local mirror. If the mirror cannot answer either,
+// the original Azure error is returned.
+func (s *Storage) loadMirror(ctx context.Context, key string, cause error) ([]byte, error) {
+	data, err := s.mirror.Load(ctx, key)
+	if err != nil {
+		return nil, cause
+	}
+	s.warnMirror("load", key, cause)
+	return data, nil
+}
+
+// statMirror serves Stat from the local mirror.
+func (s *Storage) statMirror(ctx context.Context, key string, cause error) (certmagic.KeyInfo, error) {
+	info, err := s.mirror.Stat(ctx, key)
+	if err != nil || !info.IsTerminal {
+		return certmagic.KeyInfo{}, cause
+	}
+	s.warnMirror("stat", key, cause)
+	return info, nil
+}
+
+// listMirror serves List from the local mirror, with the same prefix semantics as List:
+// prefix is a plain string prefix and only files (never directories) are returned.
+func (s *Storage) listMirror(ctx context.Context, prefix string, recursive bool, cause error) ([]string, error) {
+	walkDir := path.Dir(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		walkDir = strings.TrimSuffix(prefix, "/")
+	}
+
+	var names []string
+	root := s.mirror.Filename(walkDir)
+	err := filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.mirror.Path, fpath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || (!recursive && strings.Contains(key[len(prefix):], "/")) {
+			return nil
+		}
+		names = append(names, key)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, cause
+	}
+
+	sort.Strings(names)
+	s.warnMirror("list", prefix, cause)
+	return names, nil
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUnavailable(t *testing.T) {
+	assert.True(t, isUnavailable(errors.New("dial tcp: connection refused")))
+	assert.True(t, isUnavailable(fmt.Errorf("downloading blob: %w", &azcore.ResponseError{StatusCode: 503})))
+	assert.False(t, isUnavailable(&azcore.ResponseError{StatusCode: 403}))
+	assert.False(t, isUnavailable(fs.ErrNotExist))
+	assert.False(t, isUnavailable(fmt.Errorf("downloading blob: %w", ErrArchived)))
+}
+
+// newUnreachableStorage returns a Storage whose container endpoint refuses connections,
+// with a local mirror in a temporary directory.
+func newUnreachableStorage(t *testing.T) *Storage {
+	t.Helper()
+	return newStorage(unreachableClient(t), Config{MirrorDir: t.TempDir()})
+}
+
+func TestMirrorServesReadsWhenAzureIsUnreachable(t *testing.T) {
+	s := newUnreachableStorage(t)
+	ctx := context.Background()
+
+	keys := []string{
+		"certificates/issuer/example.com/example.com.crt",
+		"certificates/issuer/example.com/example.com.key",
+		"certificates/other.txt",
+	}
+	for _, key := range keys {
+		require.NoError(t, s.mirror.Store(ctx, key, []byte(key)))
+	}
+
+	loaded, err := s.Load(ctx, keys[0])
+	require.NoError(t, err)
+	assert.Equal(t, []byte(keys[0]), loaded)
+
+	info, err := s.Stat(ctx, keys[0])
+	require.NoError(t, err)
+	assert.True(t, info.IsTerminal)
+	assert.Equal(t, int64(len(keys[0])), info.Size)
+	assert.True(t, s.Exists(ctx, keys[1]))
+
+	recursive, err := s.List(ctx, "certificates/", true)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, keys, recursive, "Recursive mirror listing should return files only")
+
+	shallow, err := s.List(ctx, "certificates/", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"certificates/other.txt"}, shallow)
+
+	partial, err := s.List(ctx, "certificates/issuer/example.com/example.com.k", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{keys[1]}, partial, "Prefixes need not end at a path separator")
+
+	// A key missing from the mirror reports the Azure error rather than fs.ErrNotExist,
+	// since Azure may still hold it.
+	_, err = s.Load(ctx, "certificates/missing.crt")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMirrorNotUsedForCancelledReads(t *testing.T) {
+	s := newUnreachableStorage(t)
+	require.NoError(t, s.mirror.Store(context.Background(), "key.txt", []byte("value")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.Load(ctx, "key.txt")
+	assert.Error(t, err, "Load should honor context cancellation even with a mirror")
+}
+
+// Test that Store and Load write through to the mirror and Delete removes from it.
+func TestMirrorWriteThrough(t *testing.T) {
+	mirrorDir := t.TempDir()
+	s := setupTestStorageWith(t, func(c *Config) { c.MirrorDir = mirrorDir })
+	ctx := context.Background()
+	mirror := &certmagic.FileStorage{Path: mirrorDir}
+
+	key := "mirror-test/file.txt"
+	require.NoError(t, s.Store(ctx, key, []byte("mirrored")))
+	t.Cleanup(func() { _ = s.Delete(context.Background(), key) })
+
+	loaded, err := mirror.Load(ctx, key)
+	require.NoError(t, err, "Store should write through to the mirror")
+	assert.Equal(t, []byte("mirrored"), loaded)
+
+	require.NoError(t, s.Delete(ctx, key))
+	assert.False(t, mirror.Exists(ctx, key), "Delete should remove the key from the mirror")
+}
@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// postureKeyPrefix is the prefix of the scratch keys written by CheckPosture with
+// Config.PostureWriteProbe.
+const postureKeyPrefix = ".azureblob-posture/"
+
+// sharedKeyProbeVersion is the service version sent with the shared key probe.
+const sharedKeyProbeVersion = "2023-11-03"
+
+// PostureStatus is the result of a single posture check.
+type PostureStatus string
+
+const (
+	// PosturePass means the setting is secure.
+	PosturePass PostureStatus = "pass"
+	// PostureFail means the setting weakens the protection of the stored keys.
+	PostureFail PostureStatus = "fail"
+	// PostureUnknown means the setting could not be determined; see PostureCheck.Detail.
+	PostureUnknown PostureStatus = "unknown"
+)
+
+// Names of the posture checks, in the order they run.
+const (
+	PosturePublicAccess = "public_access"
+	PostureSharedKey    = "shared_key"
+	PostureVersioning   = "versioning"
+	PostureSoftDelete   = "soft_delete"
+)
+
+// PostureCheck is the outcome of one check of CheckPosture.
+type PostureCheck struct {
+	// Container is the name of the checked container.
+	Container string
+	Name      string
+	Status    PostureStatus
+	Detail    string
+}
+
+// Posture is the result of CheckPosture.
+type Posture struct {
+	Checks []PostureCheck
+	// container is the container that add records checks for.
+	container string
+}
+
+// postureTarget is a container checked by CheckPosture, with the configuration of its
+// account.
+type postureTarget struct {
+	client blobClient
+	config Config
+}
+
+// Failed reports whether any check failed.
+func (p Posture) Failed() bool {
+	for _, check := range p.Checks {
+		if check.Status == PostureFail {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPosture checks security settings of the containers and their accounts that
+// matter because the containers hold TLS private keys: that a container does not allow
+// anonymous access, that its account rejects shared key authorization if
+// Config.RequireEntraAuth is set, and that blob versioning and soft delete are enabled.
+// The container of every route and the secondary are checked too.
+//
+// Versioning and soft delete are detected from versions and deleted blobs in the
+// container's listing. Where the listing shows neither, they are unknown unless
+// Config.PostureWriteProbe is set; a container of read-only storage is never written.
+func (s *Storage) CheckPosture(ctx context.Context) Posture {
+	var p Posture
+	for _, target := range s.postureTargets() {
+		p.container = target.config.ContainerName
+		p.checkContainer(ctx, target, s.config, s.clientOptions.Transport)
+	}
+	return p
+}
+
+// postureTargets returns the default container, the containers of the routes and the
+// secondary container.
+func (s *Storage) postureTargets() []postureTarget {
+	targets := []postureTarget{{client: s.client(), config: s.config}}
+	for _, r := range s.routes {
+		targets = append(targets, postureTarget{client: r.client, config: r.config})
+	}
+	if s.secondary != nil {
+		targets = append(targets, postureTarget{client: s.secondary.client, config: s.secondary.config})
+	}
+	return targets
+}
+
+// checkContainer checks the container of target. config is the configuration of the
+// Storage, and transport sends the shared key probe.
+func (p *Posture) checkContainer(ctx context.Context, target postureTarget, config Config, transport policy.Transporter) {
+	props, err := target.client.GetProperties(ctx, nil)
+	switch {
+	case err != nil:
+		p.add(PosturePublicAccess, PostureUnknown, "reading container properties: "+diagnosticError(err))
+	case props.BlobPublicAccess != nil && *props.BlobPublicAccess != "":
+		p.add(PosturePublicAccess, PostureFail, fmt.Sprintf("container allows anonymous %s access", *props.BlobPublicAccess))
+	default:
+		p.add(PosturePublicAccess, PosturePass, "container is private")
+	}
+
+	if config.RequireEntraAuth {
+		p.checkSharedKey(ctx, target.config, target.client.URL(), transport)
+	}
+
+	versioned, softDeleted, err := listDataProtection(ctx, target.client)
+	if err != nil {
+		p.add(PostureVersioning, PostureUnknown, "listing versions: "+diagnosticError(err))
+		p.add(PostureSoftDelete, PostureUnknown, "listing deleted blobs: "+diagnosticError(err))
+		return
+	}
+	if versioned && softDeleted {
+		p.add(PostureVersioning, PosturePass, "container has blob versions")
+		p.add(PostureSoftDelete, PosturePass, "container has soft-deleted blobs")
+		return
+	}
+	if config.PostureWriteProbe && !config.ReadOnly {
+		p.checkDataProtection(ctx, target.client)
+		return
+	}
+	reason := "the write probe is disabled"
+	if config.ReadOnly {
+		reason = "storage is read-only"
+	}
+	if versioned {
+		p.add(PostureVersioning, PosturePass, "container has blob versions")
+	} else {
+		p.add(PostureVersioning, PostureUnknown, "no blob versions listed and "+reason)
+	}
+	if softDeleted {
+		p.add(PostureSoftDelete, PosturePass, "container has soft-deleted blobs")
+	} else {
+		p.add(PostureSoftDelete, PostureUnknown, "no soft-deleted blobs listed and "+reason)
+	}
+}
+
+// listDataProtection reports whether the first page of the container's listing, with
+// versions and deleted blobs, shows that blob versioning or soft delete is enabled.
+func listDataProtection(ctx context.Context, client blobClient) (versioned, softDeleted bool, err error) {
+	page, err := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Include: container.ListBlobsInclude{Deleted: true, Versions: true},
+	}).NextPage(ctx)
+	if err != nil {
+		return false, false, err
+	}
+	for _, item := range page.Segment.BlobItems {
+		versioned = versioned || (item.VersionID != nil && *item.VersionID != "")
+		softDeleted = softDeleted || (item.Deleted != nil && *item.Deleted)
+	}
+	return versioned, softDeleted, nil
+}
+
+// checkSharedKey checks that config does not use a shared key or SAS token, and that
+// the account rejects shared key authorization. The account is probed with a request
+// signed with an invalid key: Azure rejects it with KeyBasedAuthenticationNotPermitted
+// only if shared key access is disabled.
+func (p *Posture) checkSharedKey(ctx context.Context, config Config, containerURL string, transport policy.Transporter) {
+	if config.ConnectionString != "" || config.secretFile() != "" {
+		p.add(PostureSharedKey, PostureFail, "storage authenticates with a connection string, account key or SAS token")
+		return
+	}
+	probeURL, err := url.Parse(containerURL)
+	if err != nil {
+		p.add(PostureSharedKey, PostureUnknown, err.Error())
+		return
+	}
+	probeURL.RawQuery = "restype=container"
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, probeURL.String(), nil)
+	if err != nil {
+		p.add(PostureSharedKey, PostureUnknown, err.Error())
+		return
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-version", sharedKeyProbeVersion)
+	req.Header.Set("Authorization", "SharedKey "+config.AccountName+":"+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if transport == nil {
+		transport = http.DefaultClient
+	}
+	resp, err := transport.Do(req)
+	if err != nil {
+		p.add(PostureSharedKey, PostureUnknown, "probing shared key authorization: "+err.Error())
+		return
+	}
+	resp.Body.Close()
+	switch code := resp.Header.Get(headerErrorCode); code {
+	case "KeyBasedAuthenticationNotPermitted":
+		p.add(PostureSharedKey, PosturePass, "account rejects shared key authorization")
+	case "AuthenticationFailed":
+		p.add(PostureSharedKey, PostureFail, "account allows shared key authorization")
+	default:
+		p.add(PostureSharedKey, PostureUnknown, fmt.Sprintf("shared key probe answered %d %s", resp.StatusCode, code))
+	}
+}
+
+// checkDataProtection writes and deletes a scratch blob. Versioning is enabled if the
+// upload created a version, and soft delete if the deleted blob can still be listed.
+// With versioning, deleting the blob only turns it into a previous version, so that
+// version is deleted too.
+func (p *Posture) checkDataProtection(ctx context.Context, client blobClient) {
+	node, _ := os.Hostname()
+	name := postureKeyPrefix + node + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	resp, err := client.UploadBlob(ctx, name, []byte("azureblob posture"), nil)
+	if err != nil {
+		p.add(PostureVersioning, PostureUnknown, "writing scratch blob: "+diagnosticError(err))
+		p.add(PostureSoftDelete, PostureUnknown, "writing scratch blob: "+diagnosticError(err))
+		return
+	}
+	versioned := resp.VersionID != nil && *resp.VersionID != ""
+	if versioned {
+		p.add(PostureVersioning, PosturePass, "blob versioning is enabled")
+	} else {
+		p.add(PostureVersioning, PostureFail, "blob versioning is disabled")
+	}
+
+	if _, err := client.DeleteBlob(ctx, name, nil); err != nil {
+		p.add(PostureSoftDelete, PostureUnknown, "deleting scratch blob "+name+": "+diagnosticError(err))
+		return
+	}
+	if versioned {
+		if _, err := client.DeleteBlobVersion(ctx, name, *resp.VersionID); err != nil {
+			p.add(PostureSoftDelete, PostureUnknown, "deleting version of scratch blob "+name+": "+diagnosticError(err))
+			return
+		}
+	}
+
+	pager := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &name,
+		Include: container.ListBlobsInclude{Deleted: true, Versions: versioned},
+	})
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			p.add(PostureSoftDelete, PostureUnknown, "listing deleted blobs: "+diagnosticError(err))
+			return
+		}
+		for _, item := range page.Segment.BlobItems {
+			if item.Name != nil && *item.Name == name && item.Deleted != nil && *item.Deleted {
+				p.add(PostureSoftDelete, PosturePass, "blob soft delete is enabled")
+				return
+			}
+		}
+	}
+	p.add(PostureSoftDelete, PostureFail, "blob soft delete is disabled")
+}
+
+func (p *Posture) add(name string, status PostureStatus, detail string) {
+	p.Checks = append(p.Checks, PostureCheck{Container: p.container, Name: name, Status: status, Detail: detail})
+}
+
+// Err returns an error describing the failed checks, or nil if none failed.
+func (p Posture) Err() error {
+	var errs []error
+	for _, check := range p.Checks {
+		if check.Status == PostureFail {
+			errs = append(errs, fmt.Errorf("%s %s: %s", check.Container, check.Name, check.Detail))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postureTransport fakes the requests of CheckPosture for an account with the given
+// settings.
+type postureTransport struct {
+	publicAccess  string
+	versioning    bool
+	softDelete    bool
+	sharedKeyCode string
+	// listedVersion and listedDeleted add a blob version and a deleted blob to the
+	// listing of the container
+	listedVersion bool
+	listedDeleted bool
+	// deletedVersion is the version ID of the last deleted version
+	deletedVersion string
+	// writes counts the uploads
+	writes int
+}
+
+func (t *postureTransport) Do(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	header := http.Header{}
+	status, body := http.StatusOK, ""
+	switch {
+	case req.Method == http.MethodHead:
+		status = http.StatusForbidden
+		header.Set(headerErrorCode, t.sharedKeyCode)
+	case req.Method == http.MethodGet && query.Get("comp") == "list":
+		var blobs string
+		switch {
+		case query.Get("prefix") == "":
+			if t.listedVersion {
+				blobs += "<Blob><Name>a</Name><VersionId>2026-10-18T00:00:00.0000000Z</VersionId><Properties></Properties></Blob>"
+			}
+			if t.listedDeleted {
+				blobs += "<Blob><Name>b</Name><Deleted>true</Deleted><Properties></Properties></Blob>"
+			}
+		case t.softDelete:
+			blobs = fmt.Sprintf("<Blob><Name>%s</Name><Deleted>true</Deleted><Properties></Properties></Blob>", query.Get("prefix"))
+		}
+		body = `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>` + blobs + `</Blobs><NextMarker/></EnumerationResults>`
+		header.Set("Content-Type", "application/xml")
+	case req.Method == http.MethodGet:
+		if t.publicAccess != "" {
+			header.Set("x-ms-blob-public-access", t.publicAccess)
+		}
+	case req.Method == http.MethodPut:
+		t.writes++
+		status = http.StatusCreated
+		if t.versioning {
+			header.Set("x-ms-version-id", "2026-10-18T00:00:00.0000000Z")
+		}
+	case req.Method == http.MethodDelete:
+		status = http.StatusAccepted
+		t.deletedVersion = query.Get("versionid")
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// postureStatuses returns the status of every check of p by name.
+func postureStatuses(p Posture) map[string]PostureStatus {
+	statuses := map[string]PostureStatus{}
+	for _, check := range p.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestCheckPosture(t *testing.T) {
+	transport := &postureTransport{versioning: true, softDelete: true, sharedKeyCode: "KeyBasedAuthenticationNotPermitted"}
+	s := newStorage(fakeContainer(t, "container", transport), Config{AccountName: "account", RequireEntraAuth: true, PostureWriteProbe: true})
+	s.clientOptions.Transport = transport
+
+	p := s.CheckPosture(context.Background())
+	assert.Equal(t, map[string]PostureStatus{
+		PosturePublicAccess: PosturePass,
+		PostureSharedKey:    PosturePass,
+		PostureVersioning:   PosturePass,
+		PostureSoftDelete:   PosturePass,
+	}, postureStatuses(p))
+	assert.False(t, p.Failed())
+	require.NoError(t, p.Err())
+	assert.NotEmpty(t, transport.deletedVersion, "The version of the scratch blob should be deleted")
+}
+
+func TestCheckPostureFailures(t *testing.T) {
+	transport := &postureTransport{publicAccess: "blob", sharedKeyCode: "AuthenticationFailed"}
+	s := newStorage(fakeContainer(t, "container", transport), Config{AccountName: "account", RequireEntraAuth: true, PostureWriteProbe: true})
+	s.clientOptions.Transport = transport
+
+	p := s.CheckPosture(context.Background())
+	assert.Equal(t, map[string]PostureStatus{
+		PosturePublicAccess: PostureFail,
+		PostureSharedKey:    PostureFail,
+		PostureVersioning:   PostureFail,
+		PostureSoftDelete:   PostureFail,
+	}, postureStatuses(p))
+	assert.True(t, p.Failed())
+	assert.ErrorContains(t, p.Err(), "container allows anonymous blob access")
+}
+
+// Test that versioning and soft delete are detected from the listing, and that the
+// container is not written without the write probe.
+func TestCheckPostureWithoutWriteProbe(t *testing.T) {
+	transport := &postureTransport{versioning: true, softDelete: true, listedVersion: true}
+	s := newStorage(fakeContainer(t, "container", transport), Config{})
+
+	assert.Equal(t, map[string]PostureStatus{
+		PosturePublicAccess: PosturePass,
+		PostureVersioning:   PosturePass,
+		PostureSoftDelete:   PostureUnknown,
+	}, postureStatuses(s.CheckPosture(context.Background())))
+	assert.Zero(t, transport.writes)
+
+	transport.listedDeleted = true
+	s.config.PostureWriteProbe = true
+	assert.Equal(t, map[string]PostureStatus{
+		PosturePublicAccess: PosturePass,
+		PostureVersioning:   PosturePass,
+		PostureSoftDelete:   PosturePass,
+	}, postureStatuses(s.CheckPosture(context.Background())))
+	assert.Zero(t, transport.writes, "Settings found in the listing should not be probed")
+}
+
+func TestCheckPostureChecksEveryContainer(t *testing.T) {
+	secure := &postureTransport{listedVersion: true, listedDeleted: true}
+	public := &postureTransport{publicAccess: "container", listedVersion: true, listedDeleted: true}
+	s := newStorage(fakeContainer(t, "default", secure), Config{ContainerName: "default"})
+	s.routes = []route{{prefixes: []string{"acme/"}, client: fakeContainer(t, "keys", public), config: Config{ContainerName: "keys"}}}
+	s.secondary = &secondary{client: fakeContainer(t, "replica", secure), config: Config{ContainerName: "replica"}}
+
+	p := s.CheckPosture(context.Background())
+	var containers []string
+	for _, check := range p.Checks {
+		if check.Name == PosturePublicAccess {
+			containers = append(containers, check.Container)
+		}
+	}
+	assert.Equal(t, []string{"default", "keys", "replica"}, containers)
+	assert.True(t, p.Failed())
+	assert.EqualError(t, p.Err(), "keys public_access: container allows anonymous container access")
+}
+
+func TestCheckPostureReadOnly(t *testing.T) {
+	transport := &postureTransport{}
+	s := newStorage(fakeContainer(t, "container", transport), Config{ReadOnly: true, ConnectionString: "UseDevelopmentStorage=true", RequireEntraAuth: true})
+
+	assert.Equal(t, map[string]PostureStatus{
+		PosturePublicAccess: PosturePass,
+		PostureSharedKey:    PostureFail,
+		PostureVersioning:   PostureUnknown,
+		PostureSoftDelete:   PostureUnknown,
+	}, postureStatuses(s.CheckPosture(context.Background())))
+}
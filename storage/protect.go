@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var (
+	// ErrReadOnly matches a WriteProtectedError returned because the storage is read-only.
+	ErrReadOnly = errors.New("storage is read-only")
+	// ErrProtectedKey matches a WriteProtectedError returned because the key is protected.
+	ErrProtectedKey = errors.New("key is write-protected")
+)
+
+// WriteProtectedError is returned by operations that read-only mode or a protected
+// prefix does not allow. Match it with errors.Is(err, ErrReadOnly) or
+// errors.Is(err, ErrProtectedKey).
+type WriteProtectedError struct {
+	// Op is the denied operation, e.g. "store" or "lock".
+	Op string
+	// Key is the key the operation was denied for.
+	Key string
+	// Prefix is the protected prefix that matched Key; empty in read-only mode.
+	Prefix string
+}
+
+func (e *WriteProtectedError) Error() string {
+	if e.Prefix == "" {
+		return fmt.Sprintf("%s %s: %v", e.Op, e.Key, ErrReadOnly)
+	}
+	return fmt.Sprintf("%s %s: %v by prefix %s", e.Op, e.Key, ErrProtectedKey, e.Prefix)
+}
+
+func (e *WriteProtectedError) Is(target error) bool {
+	if e.Prefix == "" {
+		return target == ErrReadOnly
+	}
+	return target == ErrProtectedKey
+}
+
+// overrideKey marks a context as allowed to overwrite and delete protected keys.
+type overrideKey struct{}
+
+// OverrideProtection returns a context whose Store and Delete calls may overwrite and
+// delete keys under Config.ProtectedPrefixes. It does not lift read-only mode.
+func OverrideProtection(ctx context.Context) context.Context {
+	return context.WithValue(ctx, overrideKey{}, true)
+}
+
+// checkWritable returns a WriteProtectedError if op may not modify anything.
+func (s *Storage) checkWritable(op, key string) error {
+	if s.config.ReadOnly {
+		return &WriteProtectedError{Op: op, Key: key}
+	}
+	return nil
+}
+
+// protectedPrefix returns the protected prefix matching key, unless ctx overrides the
+// protection.
+func (s *Storage) protectedPrefix(ctx context.Context, key string) (string, bool) {
+	if override, _ := ctx.Value(overrideKey{}).(bool); override {
+		return "", false
+	}
+	for _, prefix := range s.config.ProtectedPrefixes {
+		if matchPrefix(prefix, key) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// matchPrefix reports whether key starts with prefix. Prefixes may contain path.Match
+// wildcards, which match within a path segment: "acme/*/users/" protects the users of
+// every ACME account.
+func matchPrefix(prefix, key string) bool {
+	if !strings.ContainsAny(prefix, `*?[\`) {
+		return strings.HasPrefix(key, prefix)
+	}
+	prefixSegments := strings.Split(prefix, "/")
+	keySegments := strings.Split(key, "/")
+	if len(keySegments) < len(prefixSegments) {
+		return false
+	}
+	last := len(prefixSegments) - 1
+	if matched, _ := path.Match(strings.Join(prefixSegments[:last], "/"), strings.Join(keySegments[:last], "/")); !matched {
+		return false
+	}
+	// The last segment is a prefix of the key's segment unless it ends with a wildcard
+	lastPattern, lastSegment := prefixSegments[last], keySegments[last]
+	if lastPattern == "" {
+		return true
+	}
+	for end := len(lastSegment); end >= 0; end-- {
+		if matched, _ := path.Match(lastPattern, lastSegment[:end]); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conditionTransport answers every request like statusTransport, except that deletes
+// succeed, and records the If-None-Match header of each request.
+type conditionTransport struct {
+	statusTransport
+	ifNoneMatch []string
+}
+
+func (t *conditionTransport) Do(req *http.Request) (*http.Response, error) {
+	t.ifNoneMatch = append(t.ifNoneMatch, req.Header.Get("If-None-Match"))
+	if req.Method == http.MethodDelete {
+		return statusTransport{status: http.StatusAccepted}.Do(req)
+	}
+	return t.statusTransport.Do(req)
+}
+
+// newProtectedStorage returns a Storage with config whose requests go to transport.
+func newProtectedStorage(t *testing.T, transport policy.Transporter, config Config) *Storage {
+	t.Helper()
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: transport, Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+	return newStorage(azureBlobs{containerClient}, config)
+}
+
+func TestMatchPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, key string
+		match       bool
+	}{
+		{"acme/", "acme/ca/users/me/me.key", true},
+		{"acme/", "certificates/acme/a.crt", false},
+		{"acme/*/users/", "acme/ca/users/me/me.key", true},
+		{"acme/*/users/", "acme/ca/sites/me.key", false},
+		{"acme/*/users/*/*.key", "acme/ca/users/me/me.key", true},
+		{"acme/*/users/*/*.key", "acme/ca/users/me/me.json", false},
+		{"acme/*/users/*/*.key", "acme/ca/users/me/me.key.bak", true},
+		{"acme/*/us", "acme/ca/users/me/me.key", true},
+		{"acme/*/users/", "acme/ca", false},
+	} {
+		assert.Equal(t, tc.match, matchPrefix(tc.prefix, tc.key), "%s %s", tc.prefix, tc.key)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	transport := &pathTransport{}
+	s := newProtectedStorage(t, transport, Config{ReadOnly: true})
+	ctx := context.Background()
+
+	require.ErrorIs(t, s.Store(ctx, "key.txt", []byte("value")), ErrReadOnly)
+	require.ErrorIs(t, s.Delete(ctx, "key.txt"), ErrReadOnly)
+	require.ErrorIs(t, s.Lock(ctx, "key.txt"), ErrReadOnly)
+	require.ErrorIs(t, s.Undelete(ctx, "key.txt"), ErrReadOnly)
+	_, err := s.ApplyTierPolicy(ctx, TierPolicy{Prefix: "certificates/", MinAge: time.Hour, Tier: "Cool"})
+	require.ErrorIs(t, err, ErrReadOnly)
+
+	err = s.Store(OverrideProtection(ctx), "key.txt", []byte("value"))
+	var protectedErr *WriteProtectedError
+	require.ErrorAs(t, err, &protectedErr, "The override should not lift read-only mode")
+	assert.Equal(t, "store", protectedErr.Op)
+	assert.Empty(t, transport.paths, "Nothing should be sent to Azure")
+}
+
+func TestProtectedStoreIsConditional(t *testing.T) {
+	transport := &conditionTransport{statusTransport: statusTransport{status: http.StatusConflict, errorCode: "BlobAlreadyExists"}}
+	s := newProtectedStorage(t, transport, Config{ProtectedPrefixes: []string{"acme/*/users/"}})
+	ctx := context.Background()
+
+	err := s.Store(ctx, "acme/ca/users/me/me.key", []byte("value"))
+	require.ErrorIs(t, err, ErrProtectedKey)
+	assert.NotErrorIs(t, err, ErrReadOnly)
+	assert.Equal(t, []string{"*"}, transport.ifNoneMatch)
+
+	transport.statusTransport = statusTransport{status: http.StatusCreated}
+	require.NoError(t, s.Store(OverrideProtection(ctx), "acme/ca/users/me/me.key", []byte("value")))
+	require.NoError(t, s.Store(ctx, "certificates/ca/a.crt", []byte("value")))
+	assert.Equal(t, []string{"*", "", ""}, transport.ifNoneMatch[:3], "Only protected keys should be uploaded conditionally")
+}
+
+func TestProtectedDelete(t *testing.T) {
+	transport := &conditionTransport{statusTransport: statusTransport{status: http.StatusOK}}
+	s := newProtectedStorage(t, transport, Config{ProtectedPrefixes: []string{"acme/"}})
+	ctx := context.Background()
+
+	err := s.Delete(ctx, "acme/ca/users/me/me.key")
+	var protectedErr *WriteProtectedError
+	require.ErrorAs(t, err, &protectedErr)
+	assert.Equal(t, "delete", protectedErr.Op)
+	assert.Equal(t, "acme/", protectedErr.Prefix)
+	assert.Len(t, transport.ifNoneMatch, 1, "Only the Stat request should be sent")
+
+	require.NoError(t, s.Delete(OverrideProtection(ctx), "acme/ca/users/me/me.key"))
+}
+
+func TestProtectedPrefixesStorage(t *testing.T) {
+	prefix := "protect/" + time.Now().Format("20060102150405.000000") + "/"
+	s := setupTestStorageWith(t, func(config *Config) {
+		config.ProtectedPrefixes = []string{prefix}
+	})
+	ctx := context.Background()
+	key := prefix + "users/me.key"
+
+	require.NoError(t, s.Store(ctx, key, []byte("original")), "Protected keys should be creatable")
+	require.ErrorIs(t, s.Store(ctx, key, []byte("overwritten")), ErrProtectedKey)
+	require.ErrorIs(t, s.Delete(ctx, prefix), ErrProtectedKey)
+
+	data, err := s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, "original", string(data))
+
+	require.NoError(t, s.Delete(OverrideProtection(ctx), key))
+	assert.False(t, s.Exists(ctx, key))
+}
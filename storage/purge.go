@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+)
+
+// certificatesPrefix is the root of certmagic's certificate layout:
+// certificates/<issuer>/<name>/<name>.{crt,key,json}
+const certificatesPrefix = "certificates/"
+
+// issueLockPrefix is the prefix of the lock certmagic holds on a name while it obtains
+// or renews its certificate.
+const issueLockPrefix = "issue_cert_"
+
+// PurgeOptions controls PurgeExpired.
+type PurgeOptions struct {
+	// GracePeriod is how long after expiry a certificate is kept before it is purged.
+	GracePeriod time.Duration
+	// DryRun reports what would be purged without deleting anything.
+	DryRun bool
+}
+
+// PurgedBundle describes a certificate bundle removed (or, in dry-run mode, that would
+// be removed) by PurgeExpired.
+type PurgedBundle struct {
+	// NotAfter is the expiry time of the bundle's leaf certificate.
+	NotAfter time.Time
+	// Name is the bundle directory, e.g. certificates/<issuer>/example.com.
+	Name string
+	// Keys lists every key in the bundle (.crt, .key, .json and anything else alongside).
+	Keys []string
+}
+
+// PurgeReport summarizes a PurgeExpired run.
+type PurgeReport struct {
+	// Purged lists the expired bundles that were (or would be) deleted.
+	Purged []PurgedBundle
+	// Unparsed lists certificate keys whose PEM could not be parsed; their bundles are kept.
+	Unparsed []string
+	// Failed lists certificate keys that could not be loaded, locked or deleted; their
+	// bundles are kept or, if deleting failed, partly kept.
+	Failed []string
+	// DryRun reports whether the run was a dry run.
+	DryRun bool
+}
+
+// PurgeExpired walks certmagic's certificates/ layout and deletes every bundle whose
+// certificate expired more than opts.GracePeriod ago. Bundles with certificates that
+// cannot be parsed are never deleted. Each bundle is deleted while holding certmagic's
+// issue_cert_<name> lock, and kept if it was renewed in the meantime. Bundles that fail
+// are listed in the report's Failed field and reported in the error after the others
+// were processed. With opts.DryRun set, nothing is deleted and the report lists what
+// would have been removed.
+func (s *Storage) PurgeExpired(ctx context.Context, opts PurgeOptions) (report PurgeReport, err error) {
+	ctx, span := s.startSpan(ctx, "PurgeExpired")
+	defer func() { endSpan(span, err) }()
+
+	report.DryRun = opts.DryRun
+
+	keys, err := s.List(ctx, certificatesPrefix, true)
+	if err != nil {
+		return report, fmt.Errorf("listing certificates: %w", err)
+	}
+
+	// Group keys by bundle directory.
+	bundles := make(map[string][]string)
+	for _, key := range keys {
+		dir := path.Dir(key)
+		bundles[dir] = append(bundles[dir], key)
+	}
+
+	dirs := make([]string, 0, len(bundles))
+	for dir := range bundles {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	var purgeErrs []string
+	for _, dir := range dirs {
+		certKey := path.Join(dir, path.Base(dir)+".crt")
+		bundleKeys := bundles[dir]
+		if !slices.Contains(bundleKeys, certKey) {
+			continue
+		}
+
+		cert, err := s.loadLeaf(ctx, certKey)
+		var parseErr *certParseError
+		switch {
+		case errors.As(err, &parseErr):
+			report.Unparsed = append(report.Unparsed, certKey)
+			continue
+		case err != nil:
+			report.Failed = append(report.Failed, certKey)
+			purgeErrs = append(purgeErrs, err.Error())
+			continue
+		case cert.NotAfter.After(cutoff):
+			continue
+		}
+
+		bundle := PurgedBundle{NotAfter: cert.NotAfter, Name: dir, Keys: bundleKeys}
+		if opts.DryRun {
+			report.Purged = append(report.Purged, bundle)
+			continue
+		}
+		purged, err := s.purgeBundle(ctx, bundle, certKey, bundleName(dir, cert), cutoff)
+		if purged {
+			report.Purged = append(report.Purged, bundle)
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, certKey)
+			purgeErrs = append(purgeErrs, err.Error())
+		}
+	}
+
+	if len(purgeErrs) > 0 {
+		return report, fmt.Errorf("errors purging expired certificates: %s", strings.Join(purgeErrs, "; "))
+	}
+	return report, nil
+}
+
+// purgeBundle deletes the keys of bundle while holding certmagic's issue lock on name,
+// unless the certificate at certKey no longer expired before cutoff once the lock is
+// held. It reports whether the bundle was deleted, even partly.
+func (s *Storage) purgeBundle(ctx context.Context, bundle PurgedBundle, certKey, name string, cutoff time.Time) (purged bool, err error) {
+	lockKey := issueLockPrefix + name
+	if err := s.Lock(ctx, lockKey); err != nil {
+		return false, fmt.Errorf("locking %s: %w", lockKey, err)
+	}
+	defer func() {
+		if unlockErr := s.Unlock(context.WithoutCancel(ctx), lockKey); unlockErr != nil && err == nil {
+			err = fmt.Errorf("unlocking %s: %w", lockKey, unlockErr)
+		}
+	}()
+
+	// The certificate may have been renewed before the lock was acquired
+	cert, err := s.loadLeaf(ctx, certKey)
+	if err != nil {
+		return false, err
+	}
+	if cert.NotAfter.After(cutoff) {
+		return false, nil
+	}
+
+	var deleteErrs []string
+	for _, key := range bundle.Keys {
+		if err := s.Delete(ctx, key); err != nil {
+			deleteErrs = append(deleteErrs, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	if len(deleteErrs) > 0 {
+		return len(deleteErrs) < len(bundle.Keys), errors.New(strings.Join(deleteErrs, "; "))
+	}
+	return true, nil
+}
+
+// certParseError is returned by loadLeaf for a certificate that was loaded but could not
+// be parsed.
+type certParseError struct {
+	key string
+	err error
+}
+
+func (e *certParseError) Error() string {
+	return fmt.Sprintf("parsing certificate %s: %v", e.key, e.err)
+}
+
+func (e *certParseError) Unwrap() error { return e.err }
+
+// loadLeaf loads certKey and parses its leaf certificate.
+func (s *Storage) loadLeaf(ctx context.Context, certKey string) (*x509.Certificate, error) {
+	certPEM, err := s.Load(ctx, certKey)
+	if err != nil {
+		return nil, fmt.Errorf("loading certificate %s: %w", certKey, err)
+	}
+	cert, err := leafCertificate(certPEM)
+	if err != nil {
+		return nil, &certParseError{key: certKey, err: err}
+	}
+	return cert, nil
+}
+
+// bundleName returns the name certmagic obtained the certificate of the bundle in dir
+// for, which is the name of its issue lock. dir ends in the storage-safe form of the
+// name, so the certificate name that maps to it is used.
+func bundleName(dir string, cert *x509.Certificate) string {
+	safe := path.Base(dir)
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, name := range names {
+		if name != "" && certmagic.StorageKeys.Safe(name) == safe {
+			return name
+		}
+	}
+	return strings.Replace(safe, "wildcard_", "*", 1)
+}
+
+// leafCertificate parses the first (leaf) certificate in certPEM.
+func leafCertificate(certPEM []byte) (*x509.Certificate, error) {
+	for {
+		var block *pem.Block
+		block, certPEM = pem.Decode(certPEM)
+		if block == nil {
+			return nil, errors.New("no certificate found in PEM data")
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing certificate: %w", err)
+		}
+		return cert, nil
+	}
+}
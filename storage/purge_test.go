@@ -0,0 +1,198 @@
+package storage
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCertPEM returns a self-signed PEM certificate for name that expires at notAfter.
+func testCertPEM(t *testing.T, name string, notAfter time.Time) []byte {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestLeafCertificate(t *testing.T) {
+	notAfter := time.Now().Add(-48 * time.Hour).Truncate(time.Second).UTC()
+	certPEM := testCertPEM(t, "example.com", notAfter)
+
+	cert, err := leafCertificate(certPEM)
+	require.NoError(t, err)
+	assert.True(t, notAfter.Equal(cert.NotAfter), "expected %v, got %v", notAfter, cert.NotAfter)
+
+	_, err = leafCertificate([]byte("not a certificate"))
+	assert.Error(t, err)
+}
+
+// Test that PurgeExpired removes expired bundles (with siblings), keeps valid ones and
+// honors dry-run mode.
+func TestPurgeExpired(t *testing.T) {
+	s := setupTestStorage(t)
+	ctx := context.Background()
+
+	issuerDir := "certificates/purge-test-issuer/"
+	expiredDir := issuerDir + "expired.example.com/"
+	validDir := issuerDir + "valid.example.com/"
+	expiredKeys := []string{
+		expiredDir + "expired.example.com.crt",
+		expiredDir + "expired.example.com.key",
+		expiredDir + "expired.example.com.json",
+	}
+	validKeys := []string{
+		validDir + "valid.example.com.crt",
+		validDir + "valid.example.com.key",
+		validDir + "valid.example.com.json",
+	}
+	t.Cleanup(func() { _ = s.Delete(context.Background(), issuerDir) })
+
+	require.NoError(t, s.Store(ctx, expiredKeys[0], testCertPEM(t, "expired.example.com", time.Now().Add(-30*24*time.Hour))))
+	require.NoError(t, s.Store(ctx, validKeys[0], testCertPEM(t, "valid.example.com", time.Now().Add(30*24*time.Hour))))
+	for _, key := range append(expiredKeys[1:], validKeys[1:]...) {
+		require.NoError(t, s.Store(ctx, key, []byte("sibling")))
+	}
+
+	// Dry run reports the expired bundle but deletes nothing.
+	report, err := s.PurgeExpired(ctx, PurgeOptions{GracePeriod: 7 * 24 * time.Hour, DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	require.Len(t, purgedUnder(report, issuerDir), 1)
+	assert.ElementsMatch(t, expiredKeys, purgedUnder(report, issuerDir)[0].Keys)
+	for _, key := range expiredKeys {
+		assert.True(t, s.Exists(ctx, key), "Dry run must not delete %s", key)
+	}
+
+	// A grace period longer than the expiry keeps the bundle.
+	report, err = s.PurgeExpired(ctx, PurgeOptions{GracePeriod: 60 * 24 * time.Hour, DryRun: true})
+	require.NoError(t, err)
+	assert.Empty(t, purgedUnder(report, issuerDir))
+
+	// A real run removes the expired bundle and keeps the valid one.
+	report, err = s.PurgeExpired(ctx, PurgeOptions{GracePeriod: 7 * 24 * time.Hour})
+	require.NoError(t, err)
+	require.Len(t, purgedUnder(report, issuerDir), 1)
+	for _, key := range expiredKeys {
+		assert.False(t, s.Exists(ctx, key), "Expired bundle key should be purged: %s", key)
+	}
+	for _, key := range validKeys {
+		assert.True(t, s.Exists(ctx, key), "Valid bundle key should be kept: %s", key)
+	}
+}
+
+// purgedUnder filters a report to bundles below prefix, ignoring unrelated data in the container.
+func purgedUnder(report PurgeReport, prefix string) []PurgedBundle {
+	var bundles []PurgedBundle
+	for _, b := range report.Purged {
+		if strings.HasPrefix(b.Name+"/", prefix) {
+			bundles = append(bundles, b)
+		}
+	}
+	return bundles
+}
+
+// failingBlobs fails downloads of the blobs named in fail.
+type failingBlobs struct {
+	blobClient
+	fail map[string]error
+}
+
+func (c failingBlobs) DownloadBlob(ctx context.Context, name string, options *blob.DownloadStreamOptions) (blob.DownloadStreamResponse, error) {
+	if err, ok := c.fail[name]; ok {
+		return blob.DownloadStreamResponse{}, err
+	}
+	return c.blobClient.DownloadBlob(ctx, name, options)
+}
+
+// storeBundle stores a certificate bundle for name under issuerDir that expires at notAfter.
+func storeBundle(t *testing.T, s *Storage, issuerDir, name string, notAfter time.Time) []string {
+	t.Helper()
+	dir := issuerDir + certmagic.StorageKeys.Safe(name) + "/"
+	base := dir + certmagic.StorageKeys.Safe(name)
+	keys := []string{base + ".crt", base + ".key", base + ".json"}
+	require.NoError(t, s.Store(context.Background(), keys[0], testCertPEM(t, name, notAfter)))
+	for _, key := range keys[1:] {
+		require.NoError(t, s.Store(context.Background(), key, []byte("sibling")))
+	}
+	return keys
+}
+
+// Test that a certificate that cannot be loaded is reported as failed without stopping
+// the other bundles from being purged.
+func TestPurgeExpiredContinuesAfterFailedLoad(t *testing.T) {
+	s := setupFakeStorage(t, func(*Config) {})
+	ctx := context.Background()
+	expired := time.Now().Add(-30 * 24 * time.Hour)
+	failing := storeBundle(t, s, "certificates/issuer/", "a.example.com", expired)
+	purged := storeBundle(t, s, "certificates/issuer/", "b.example.com", expired)
+	s.setClient(failingBlobs{blobClient: s.client(), fail: map[string]error{
+		failing[0]: &azcore.ResponseError{StatusCode: http.StatusInternalServerError, ErrorCode: "InternalError"},
+	}})
+
+	report, err := s.PurgeExpired(ctx, PurgeOptions{})
+	require.Error(t, err)
+	assert.Equal(t, []string{failing[0]}, report.Failed)
+	require.Len(t, report.Purged, 1)
+	assert.Equal(t, "certificates/issuer/b.example.com", report.Purged[0].Name)
+	for _, key := range purged {
+		assert.False(t, s.Exists(ctx, key), "Expired bundle key should be purged: %s", key)
+	}
+	for _, key := range failing {
+		assert.True(t, s.Exists(ctx, key), "Bundle that failed to load should be kept: %s", key)
+	}
+}
+
+// Test that a bundle is not deleted while certmagic holds the issue lock of its name.
+func TestPurgeExpiredWaitsForIssueLock(t *testing.T) {
+	s := setupFakeStorage(t, func(*Config) {})
+	ctx := context.Background()
+	keys := storeBundle(t, s, "certificates/issuer/", "*.example.com", time.Now().Add(-30*24*time.Hour))
+	require.NoError(t, s.Lock(ctx, "issue_cert_*.example.com"))
+	t.Cleanup(func() { _ = s.Unlock(context.Background(), "issue_cert_*.example.com") })
+
+	purgeCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+	report, err := s.PurgeExpired(purgeCtx, PurgeOptions{})
+	require.Error(t, err)
+	assert.Empty(t, report.Purged)
+	assert.Equal(t, []string{keys[0]}, report.Failed)
+	for _, key := range keys {
+		assert.True(t, s.Exists(ctx, key), "Locked bundle key should be kept: %s", key)
+	}
+}
+
+func TestBundleName(t *testing.T) {
+	notAfter := time.Now().Add(24 * time.Hour)
+	block, _ := pem.Decode(testCertPEM(t, "*.example.com", notAfter))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+
+	assert.Equal(t, "*.example.com", bundleName("certificates/issuer/wildcard_.example.com", cert))
+	assert.Equal(t, "*.other.example", bundleName("certificates/issuer/wildcard_.other.example", cert),
+		"A directory matching none of the certificate's names falls back to reversing the safe form")
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+)
+
+// RouteConfig sends the keys matching Prefixes to their own container, e.g. to keep
+// private keys in a tightly restricted account. Keys matching no route are stored in
+// the container of Config. Caches, the mirror and the secondary account are shared by
+// all routes.
+type RouteConfig struct {
+	// Prefixes are the key prefixes routed to this container. Like
+	// Config.ProtectedPrefixes they may contain path.Match wildcards within a path
+	// segment, e.g. "acme/*/users/"
+	Prefixes []string
+	// Credential can be used for authentication (managed identity, etc.)
+	Credential azcore.TokenCredential
+	// AccountName is the route's Azure Storage account name
+	AccountName string
+	// ContainerName is the route's container name
+	ContainerName string
+	// ConnectionString is the route's connection string (optional)
+	ConnectionString string
+}
+
+// route is a container that keys are routed to.
+type route struct {
+	prefixes []string
+	client   blobClient
+	// config is the configuration of the route's container and account.
+	config Config
+}
+
+// backend is one of the containers of a Storage.
+type backend struct {
+	client blobClient
+	// route is the index in Storage.routes, or -1 for the default container
+	route int
+}
+
+// newRoutes creates the clients of routes, with the policies of accountOptions added to
+// options, and makes sure their containers exist, as config.CreateContainer allows.
+func newRoutes(ctx context.Context, config Config, options azcore.ClientOptions) ([]route, error) {
+	var routes []route
+	for _, routeConfig := range config.Routes {
+		if len(routeConfig.Prefixes) == 0 {
+			return nil, fmt.Errorf("route to container %s has no prefixes", routeConfig.ContainerName)
+		}
+		containerConfig := Config{
+			Credential:        routeConfig.Credential,
+			AccountName:       routeConfig.AccountName,
+			ContainerName:     routeConfig.ContainerName,
+			ConnectionString:  routeConfig.ConnectionString,
+			MirrorDir:         config.MirrorDir,
+			ReadOnly:          config.ReadOnly,
+			CreateContainer:   config.CreateContainer,
+			ContainerMetadata: config.ContainerMetadata,
+			ContainerAccess:   config.ContainerAccess,
+			Logger:            config.Logger,
+		}
+		client, _, err := newContainerClient(containerConfig, accountOptions(config, options))
+		if err != nil {
+			return nil, fmt.Errorf("route to container %s: %w", routeConfig.ContainerName, err)
+		}
+		if err := ensureContainer(ctx, azureBlobs{client}, containerConfig); err != nil {
+			return nil, fmt.Errorf("route to container %s: %w", routeConfig.ContainerName, err)
+		}
+		routes = append(routes, route{prefixes: routeConfig.Prefixes, client: azureBlobs{client}, config: containerConfig})
+	}
+	return routes, nil
+}
+
+// routeOf returns the index of the first route matching key, or -1 if key belongs to
+// the default container.
+func (s *Storage) routeOf(key string) int {
+	for i, r := range s.routes {
+		for _, prefix := range r.prefixes {
+			if matchPrefix(prefix, key) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// clientFor returns the client of the container that key is routed to.
+func (s *Storage) clientFor(key string) blobClient {
+	if i := s.routeOf(key); i >= 0 {
+		return s.routes[i].client
+	}
+	return s.client()
+}
+
+// backends returns the default container followed by the container of every route.
+func (s *Storage) backends() []backend {
+	backends := []backend{{client: s.client(), route: -1}}
+	for i, r := range s.routes {
+		backends = append(backends, backend{client: r.client, route: i})
+	}
+	return backends
+}
+
+// owns reports whether key is routed to b. Blobs of other keys in b's container, e.g.
+// stored there before the routes were configured, are ignored.
+func (s *Storage) owns(b backend, key string) bool {
+	return s.routeOf(key) == b.route
+}
+
+// listRouted lists the keys under prefix in every container and merges them.
+func (s *Storage) listRouted(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	if len(s.routes) == 0 {
+		return s.list(ctx, s.client(), prefix, recursive)
+	}
+	var names []string
+	for _, b := range s.backends() {
+		backendNames, err := s.list(ctx, b.client, prefix, recursive)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range backendNames {
+			if s.owns(b, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
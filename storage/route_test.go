@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeContainer returns a client for containerName whose requests go to transport.
+func fakeContainer(t *testing.T, containerName string, transport policy.Transporter) blobClient {
+	t.Helper()
+	client, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/"+containerName, &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: transport, Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+	return azureBlobs{client}
+}
+
+func TestRouteOf(t *testing.T) {
+	s := newStorage(nil, Config{})
+	s.routes = []route{
+		{prefixes: []string{"acme/*/users/"}},
+		{prefixes: []string{"certificates/", "acme/"}},
+	}
+
+	assert.Equal(t, 0, s.routeOf("acme/ca/users/me/me.key"))
+	assert.Equal(t, 1, s.routeOf("acme/ca/sites/x"), "A key should go to the first matching route")
+	assert.Equal(t, 1, s.routeOf("certificates/ca/a.crt"))
+	assert.Equal(t, -1, s.routeOf("issue_cert_a"))
+}
+
+func TestStoreUsesRoute(t *testing.T) {
+	defaultTransport, keysTransport := &pathTransport{}, &pathTransport{}
+	s := newStorage(fakeContainer(t, "public", defaultTransport), Config{})
+	s.routes = []route{{prefixes: []string{"acme/*/users/"}, client: fakeContainer(t, "keys", keysTransport)}}
+	ctx := context.Background()
+
+	require.NoError(t, s.Store(ctx, "acme/ca/users/me/me.key", []byte("key")))
+	require.NoError(t, s.Store(ctx, "certificates/ca/a.crt", []byte("cert")))
+	assert.Equal(t, []string{"/keys/acme%2Fca%2Fusers%2Fme%2Fme.key"}, keysTransport.paths)
+	assert.Equal(t, []string{"/public/certificates%2Fca%2Fa.crt"}, defaultTransport.paths)
+}
+
+func TestRoutesStorage(t *testing.T) {
+	keysContainer := testContainerName + "-keys"
+	s := setupTestStorageWith(t, func(config *Config) {
+		config.Routes = []RouteConfig{{
+			Prefixes:         []string{"routes/*/users/"},
+			AccountName:      config.AccountName,
+			ContainerName:    keysContainer,
+			ConnectionString: config.ConnectionString,
+		}}
+	})
+	ctx := context.Background()
+	prefix := fmt.Sprintf("routes/%d/", time.Now().UnixNano())
+	userKey := prefix + "users/me.key"
+	certKey := prefix + "certificates/a.crt"
+
+	require.NoError(t, s.Store(ctx, userKey, []byte("key")))
+	require.NoError(t, s.Store(ctx, certKey, []byte("cert")))
+	assert.True(t, s.Exists(ctx, userKey))
+	_, err := s.download(ctx, s.client(), userKey, nil)
+	require.Error(t, err, "Routed keys should not be stored in the default container")
+
+	keys, err := s.List(ctx, prefix, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{certKey, userKey}, keys)
+
+	require.NoError(t, s.Lock(ctx, userKey))
+	locks, err := s.ListLocks(ctx)
+	require.NoError(t, err)
+	assert.True(t, slices.ContainsFunc(locks, func(lock LockInfo) bool { return lock.Key == userKey && lock.Held }))
+	require.NoError(t, s.Unlock(ctx, userKey))
+
+	require.NoError(t, s.Delete(ctx, prefix))
+	assert.False(t, s.Exists(ctx, userKey))
+	assert.False(t, s.Exists(ctx, certKey))
+}
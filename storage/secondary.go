@@ -0,0 +1,431 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultSecondaryQueueSize is the async replication queue size used when
+	// SecondaryConfig.QueueSize is zero.
+	DefaultSecondaryQueueSize = 1024
+	// DefaultSecondaryMaxAttempts is how often an async write to the secondary is tried
+	// when SecondaryConfig.MaxAttempts is zero.
+	DefaultSecondaryMaxAttempts = 5
+	// DefaultSecondaryRetryInterval is the wait between async attempts when
+	// SecondaryConfig.RetryInterval is zero.
+	DefaultSecondaryRetryInterval = 5 * time.Second
+	// replicationDrainTimeout bounds applying the queued async writes after the context
+	// of RunReplication is done.
+	replicationDrainTimeout = 10 * time.Second
+)
+
+// SecondaryConfig configures a secondary storage account that receives a copy of every
+// Store and Delete (dual-write mode). Reads fall back to the secondary when the primary
+// is unavailable; locks are only ever taken on the primary.
+//
+// The two writes are not transactional: the secondary is written after the primary, so
+// when a synchronous write to the secondary fails, Store and Delete return an error
+// although the primary already has the new value. Reconcile reports such differences.
+type SecondaryConfig struct {
+	// Credential can be used for authentication (managed identity, etc.)
+	Credential azcore.TokenCredential
+	// AccountName is the secondary Azure Storage account name
+	AccountName string
+	// ContainerName is the secondary container name
+	ContainerName string
+	// ConnectionString is the secondary account's connection string (optional)
+	ConnectionString string
+	// Async replicates writes from a background queue instead of as part of Store and
+	// Delete, which then no longer fail when only the secondary does. The queue is only
+	// drained while Storage.RunReplication is running, which the caller must start;
+	// without it, writes are dropped once the queue is full. Every dropped write emits
+	// EventReplicationDropped.
+	Async bool
+	// QueueSize bounds the number of pending async writes (optional)
+	QueueSize int
+	// MaxAttempts is how often an async write is tried before it is dropped (optional)
+	MaxAttempts int
+	// RetryInterval is the wait between async attempts (optional)
+	RetryInterval time.Duration
+}
+
+// replicaOp is a write to apply to the secondary.
+type replicaOp struct {
+	metadata map[string]*string
+	key      string
+	name     string // blob name of key
+	value    []byte
+	delete   bool
+}
+
+// secondary is the replication target of a Storage.
+type secondary struct {
+	client blobClient
+	// config is the configuration of the secondary container and account.
+	config        Config
+	queue         chan replicaOp
+	maxAttempts   int
+	retryInterval time.Duration
+	drainTimeout  time.Duration
+}
+
+// newSecondary creates the client of primary.Secondary and makes sure its container
+// exists, creating it as primary.CreateContainer allows.
+func newSecondary(ctx context.Context, primary Config, options azcore.ClientOptions) (*secondary, error) {
+	config := primary.Secondary
+	containerConfig := Config{
+		Credential:        config.Credential,
+		AccountName:       config.AccountName,
+		ContainerName:     config.ContainerName,
+		ConnectionString:  config.ConnectionString,
+		CreateContainer:   primary.CreateContainer,
+		ContainerMetadata: primary.ContainerMetadata,
+		ContainerAccess:   primary.ContainerAccess,
+		Logger:            primary.Logger,
+	}
+	client, _, err := newContainerClient(containerConfig, options)
+	if err != nil {
+		return nil, fmt.Errorf("secondary: %w", err)
+	}
+	if err := ensureContainer(ctx, azureBlobs{client}, containerConfig); err != nil {
+		return nil, fmt.Errorf("secondary: %w", err)
+	}
+
+	sec := &secondary{
+		client:        azureBlobs{client},
+		config:        containerConfig,
+		maxAttempts:   config.MaxAttempts,
+		retryInterval: config.RetryInterval,
+		drainTimeout:  replicationDrainTimeout,
+	}
+	if sec.maxAttempts <= 0 {
+		sec.maxAttempts = DefaultSecondaryMaxAttempts
+	}
+	if sec.retryInterval <= 0 {
+		sec.retryInterval = DefaultSecondaryRetryInterval
+	}
+	if config.Async {
+		queueSize := config.QueueSize
+		if queueSize <= 0 {
+			queueSize = DefaultSecondaryQueueSize
+		}
+		sec.queue = make(chan replicaOp, queueSize)
+	}
+	return sec, nil
+}
+
+// apply performs op on the secondary. Deleting a missing key succeeds.
+func (sec *secondary) apply(ctx context.Context, op replicaOp) error {
+	if op.delete {
+		_, err := sec.client.DeleteBlob(ctx, op.name, nil)
+		var responseError *azcore.ResponseError
+		if err != nil && (!errors.As(err, &responseError) || responseError.StatusCode != 404) {
+			return fmt.Errorf("deleting secondary blob %s: %w", op.key, err)
+		}
+		return nil
+	}
+	_, err := sec.client.UploadBlob(ctx, op.name, op.value, &blockblob.UploadBufferOptions{
+		Metadata: op.metadata,
+	})
+	if err != nil {
+		return fmt.Errorf("uploading secondary blob %s: %w", op.key, err)
+	}
+	return nil
+}
+
+// replicate applies op to the secondary, or queues it in async mode. Only synchronous
+// failures are returned; a full queue drops the write.
+func (s *Storage) replicate(ctx context.Context, op replicaOp) error {
+	if s.secondary == nil {
+		return nil
+	}
+	op.name = s.blobName(op.key)
+	if s.secondary.queue == nil {
+		return s.secondary.apply(ctx, op)
+	}
+	select {
+	case s.secondary.queue <- op:
+	default:
+		s.logger.Error("secondary replication queue is full; dropping write", zap.String("key", op.key))
+		s.emit(EventReplicationDropped, map[string]any{"key": op.key, "reason": "queue full"})
+	}
+	return nil
+}
+
+// RunReplication applies queued writes to the secondary until ctx is done, retrying
+// failed writes. It must be running while SecondaryConfig.Async is set; otherwise it
+// returns immediately. Once ctx is done, the writes still queued are applied for a
+// limited time before it returns, and the rest are dropped.
+func (s *Storage) RunReplication(ctx context.Context) {
+	if s.secondary == nil || s.secondary.queue == nil {
+		return
+	}
+	for {
+		select {
+		case op := <-s.secondary.queue:
+			if !s.applyWithRetry(ctx, op) {
+				s.drainReplication(op)
+				return
+			}
+		case <-ctx.Done():
+			s.drainReplication()
+			return
+		}
+	}
+}
+
+// drainReplication applies pending and the queued writes to the secondary within the
+// drain timeout, and drops the writes it cannot apply in time.
+func (s *Storage) drainReplication(pending ...replicaOp) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.secondary.drainTimeout)
+	defer cancel()
+	drain := func(op replicaOp) {
+		if !s.applyWithRetry(ctx, op) {
+			s.logger.Error("replication to secondary stopped; dropping write", zap.String("key", op.key))
+			s.emit(EventReplicationDropped, map[string]any{"key": op.key, "reason": "stopped"})
+		}
+	}
+	for _, op := range pending {
+		drain(op)
+	}
+	for {
+		select {
+		case op := <-s.secondary.queue:
+			drain(op)
+		default:
+			return
+		}
+	}
+}
+
+// applyWithRetry applies op to the secondary, retrying until it succeeds or the
+// attempts are exhausted, in which case the write is dropped. It returns false if ctx
+// is done first, leaving op to the caller.
+func (s *Storage) applyWithRetry(ctx context.Context, op replicaOp) bool {
+	for attempt := 1; ; attempt++ {
+		err := s.secondary.apply(ctx, op)
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if attempt >= s.secondary.maxAttempts {
+			s.logger.Error("replicating to secondary failed; giving up",
+				append(errorFields(err), zap.String("key", op.key), zap.Int("attempts", attempt))...)
+			s.emit(EventReplicationDropped, map[string]any{"key": op.key, "reason": "attempts exhausted"})
+			return true
+		}
+		s.logger.Warn("replicating to secondary failed; retrying",
+			append(errorFields(err), zap.String("key", op.key), zap.Int("attempt", attempt))...)
+
+		select {
+		case <-time.After(s.secondary.retryInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// useSecondary reports whether a failed read should be retried on the secondary.
+func (s *Storage) useSecondary(ctx context.Context, err error) bool {
+	return s.secondary != nil && ctx.Err() == nil && isUnavailable(err)
+}
+
+// loadSecondary serves Load from the secondary. If the secondary cannot answer either,
+// the original error is returned so that the local mirror can still be tried.
+func (s *Storage) loadSecondary(ctx context.Context, key string, cause error) ([]byte, error) {
+	blobVal, err := s.download(ctx, s.secondary.client, key, nil)
+	if err != nil {
+		return nil, cause
+	}
+	s.warnSecondary("load", key, cause)
+	return blobVal.data, nil
+}
+
+// statSecondary serves Stat from the secondary.
+func (s *Storage) statSecondary(ctx context.Context, key string, cause error) (certmagic.KeyInfo, error) {
+	blobVal, err := s.getProperties(ctx, s.secondary.client, key, nil)
+	if err != nil {
+		return certmagic.KeyInfo{}, cause
+	}
+	s.warnSecondary("stat", key, cause)
+	return blobVal.info, nil
+}
+
+// listSecondary serves List from the secondary.
+func (s *Storage) listSecondary(ctx context.Context, prefix string, recursive bool, cause error) ([]string, error) {
+	names, err := s.list(ctx, s.secondary.client, prefix, recursive)
+	if err != nil {
+		return nil, cause
+	}
+	s.warnSecondary("list", prefix, cause)
+	return names, nil
+}
+
+func (s *Storage) warnSecondary(op, key string, cause error) {
+	s.logger.Warn("primary storage account unavailable; serving data from secondary",
+		zap.String("op", op),
+		zap.String("key", key),
+		zap.NamedError("cause", cause))
+}
+
+// ReconcileReport lists the differences between the primary and secondary containers.
+// Lock blobs and the audit journal are not compared.
+type ReconcileReport struct {
+	// MissingOnSecondary lists keys that only exist on the primary.
+	MissingOnSecondary []string
+	// OnlyOnSecondary lists keys that only exist on the secondary.
+	OnlyOnSecondary []string
+	// Different lists keys whose content differs between the two.
+	Different []string
+}
+
+// Diverged reports whether any difference was found.
+func (r ReconcileReport) Diverged() bool {
+	return len(r.MissingOnSecondary) > 0 || len(r.OnlyOnSecondary) > 0 || len(r.Different) > 0
+}
+
+// blobSummary is what Reconcile compares between the two containers.
+type blobSummary struct {
+	client blobClient
+	name   string
+	md5    []byte
+	size   int64
+}
+
+// Reconcile compares the primary and secondary containers and reports where they
+// diverge, e.g. after async writes were dropped or during an account migration.
+// Blobs are matched by their keys, and blobs in a container that their key is not
+// routed to are ignored. Content is compared by MD5 where Azure recorded one for both
+// blobs; otherwise blobs of the same size are downloaded and compared by SHA-256.
+// Reconcile does not modify either container.
+func (s *Storage) Reconcile(ctx context.Context) (report ReconcileReport, err error) {
+	ctx, span := s.startSpan(ctx, "Reconcile")
+	defer func() { endSpan(span, err) }()
+
+	if s.secondary == nil {
+		return report, errors.New("no secondary storage account configured")
+	}
+	// The secondary holds the keys of every route
+	primary := make(map[string]blobSummary)
+	for _, b := range s.backends() {
+		summaries, err := s.summarize(ctx, b.client)
+		if err != nil {
+			return report, fmt.Errorf("listing primary: %w", err)
+		}
+		for key, summary := range summaries {
+			if s.owns(b, key) {
+				primary[key] = summary
+			}
+		}
+	}
+	replica, err := s.summarize(ctx, s.secondary.client)
+	if err != nil {
+		return report, fmt.Errorf("listing secondary: %w", err)
+	}
+
+	for key, p := range primary {
+		r, ok := replica[key]
+		if !ok {
+			report.MissingOnSecondary = append(report.MissingOnSecondary, key)
+			continue
+		}
+		same, err := sameContent(ctx, p, r)
+		if err != nil {
+			return report, err
+		}
+		if !same {
+			report.Different = append(report.Different, key)
+		}
+	}
+	for key := range replica {
+		if _, ok := primary[key]; !ok {
+			report.OnlyOnSecondary = append(report.OnlyOnSecondary, key)
+		}
+	}
+	slices.Sort(report.MissingOnSecondary)
+	slices.Sort(report.OnlyOnSecondary)
+	slices.Sort(report.Different)
+	return report, nil
+}
+
+// sameContent reports whether the two blobs have the same content.
+func sameContent(ctx context.Context, p, r blobSummary) (bool, error) {
+	switch {
+	case p.size != r.size:
+		return false, nil
+	case p.md5 != nil && r.md5 != nil:
+		return bytes.Equal(p.md5, r.md5), nil
+	}
+	primaryHash, err := blobHash(ctx, p.client, p.name)
+	if err != nil {
+		return false, fmt.Errorf("reading primary blob %s: %w", p.name, err)
+	}
+	replicaHash, err := blobHash(ctx, r.client, r.name)
+	if err != nil {
+		return false, fmt.Errorf("reading secondary blob %s: %w", r.name, err)
+	}
+	return bytes.Equal(primaryHash, replicaHash), nil
+}
+
+// blobHash returns the SHA-256 of the content of the blob name in client's container.
+func blobHash(ctx context.Context, client blobClient, name string) ([]byte, error) {
+	response, err := client.DownloadBlob(ctx, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, response.Body); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// summarize lists every blob in client's container by its key, except for locks and
+// the audit journal, which are not replicated.
+func (s *Storage) summarize(ctx context.Context, client blobClient) (map[string]blobSummary, error) {
+	summaries := make(map[string]blobSummary)
+	pager := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Include: container.ListBlobsInclude{Metadata: true},
+	})
+	for pager.More() {
+		resp, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range resp.Segment.BlobItems {
+			if item.Name == nil || strings.HasSuffix(*item.Name, lockSuffix) {
+				continue
+			}
+			key, ok := s.keyName(*item.Name, item.Metadata)
+			if !ok || s.isAuditKey(key) {
+				continue
+			}
+			summary := blobSummary{client: client, name: *item.Name}
+			if props := item.Properties; props != nil {
+				summary.md5 = props.ContentMD5
+				if props.ContentLength != nil {
+					summary.size = *props.ContentLength
+				}
+			}
+			summaries[key] = summary
+		}
+	}
+	return summaries, nil
+}
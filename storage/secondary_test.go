@@ -0,0 +1,260 @@
+package storage
+
+import (
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const testSecondaryContainerName = "test-container-secondary"
+
+// setupDualWriteStorage returns a Storage that replicates to a second container of the
+// test account.
+func setupDualWriteStorage(t *testing.T, async bool) *Storage {
+	return setupTestStorageWith(t, func(config *Config) {
+		config.Secondary = &SecondaryConfig{
+			AccountName:      config.AccountName,
+			ContainerName:    testSecondaryContainerName,
+			ConnectionString: config.ConnectionString,
+			Async:            async,
+			RetryInterval:    10 * time.Millisecond,
+		}
+	})
+}
+
+// unreachableClient returns a container client whose endpoint refuses connections.
+func unreachableClient(t *testing.T) blobClient {
+	t.Helper()
+	containerClient, err := container.NewClientWithNoCredential("http://127.0.0.1:1/account/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+	return azureBlobs{containerClient}
+}
+
+func TestDualWriteSync(t *testing.T) {
+	s := setupDualWriteStorage(t, false)
+	ctx := context.Background()
+	key := "secondary/sync/" + time.Now().Format(time.RFC3339Nano) + ".txt"
+
+	require.NoError(t, s.Store(ctx, key, []byte("replicated")))
+	blobVal, err := s.download(ctx, s.secondary.client, key, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replicated"), blobVal.data)
+
+	require.NoError(t, s.Delete(ctx, key))
+	_, err = s.download(ctx, s.secondary.client, key, nil)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDualWriteAsync(t *testing.T) {
+	s := setupDualWriteStorage(t, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.RunReplication(ctx)
+	key := "secondary/async/" + time.Now().Format(time.RFC3339Nano) + ".txt"
+
+	require.NoError(t, s.Store(ctx, key, []byte("replicated")))
+	assert.Eventually(t, func() bool {
+		blobVal, err := s.download(ctx, s.secondary.client, key, nil)
+		return err == nil && string(blobVal.data) == "replicated"
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestSecondaryServesReadsWhenPrimaryIsUnreachable(t *testing.T) {
+	s := setupDualWriteStorage(t, false)
+	ctx := context.Background()
+	key := "secondary/fallback/" + time.Now().Format(time.RFC3339Nano) + ".txt"
+	require.NoError(t, s.Store(ctx, key, []byte("from secondary")))
+
+	s.setClient(unreachableClient(t))
+
+	loaded, err := s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("from secondary"), loaded)
+
+	info, err := s.Stat(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("from secondary")), info.Size)
+	assert.True(t, s.Exists(ctx, key))
+
+	names, err := s.List(ctx, "secondary/fallback/", true)
+	require.NoError(t, err)
+	assert.Contains(t, names, key)
+
+	// Writes go to the primary only and fail
+	assert.Error(t, s.Store(ctx, key, []byte("new")))
+}
+
+func TestReconcile(t *testing.T) {
+	s := setupDualWriteStorage(t, false)
+	ctx := context.Background()
+	prefix := "secondary/reconcile/" + time.Now().Format(time.RFC3339Nano) + "/"
+	same, missing, extra, different := prefix+"same", prefix+"missing", prefix+"extra", prefix+"different"
+
+	require.NoError(t, s.Store(ctx, same, []byte("same")))
+	require.NoError(t, s.Store(ctx, different, []byte("primary")))
+	_, err := s.client().UploadBlob(ctx, missing, []byte("primary only"), nil)
+	require.NoError(t, err)
+	require.NoError(t, s.secondary.apply(ctx, replicaOp{key: extra, name: extra, value: []byte("secondary only")}))
+	// Same size as the primary value, so only the content tells them apart
+	require.NoError(t, s.secondary.apply(ctx, replicaOp{key: different, name: different, value: []byte("changed")}))
+
+	report, err := s.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.True(t, report.Diverged())
+	assert.Contains(t, report.MissingOnSecondary, missing)
+	assert.Contains(t, report.OnlyOnSecondary, extra)
+	assert.Contains(t, report.Different, different)
+	assert.NotContains(t, report.Different, same)
+	assert.NotContains(t, report.MissingOnSecondary, same)
+}
+
+// Test that Reconcile matches blobs by key and ignores blobs in a container that their
+// key is not routed to.
+func TestReconcileRoutes(t *testing.T) {
+	s := setupTestStorageWith(t, func(config *Config) {
+		config.Routes = []RouteConfig{{
+			Prefixes:         []string{"reconcile-routes/*/users/"},
+			AccountName:      config.AccountName,
+			ContainerName:    testContainerName + "-keys",
+			ConnectionString: config.ConnectionString,
+		}}
+		config.Secondary = &SecondaryConfig{
+			AccountName:      config.AccountName,
+			ContainerName:    testSecondaryContainerName,
+			ConnectionString: config.ConnectionString,
+		}
+	})
+	ctx := context.Background()
+	prefix := "reconcile-routes/" + time.Now().Format("20060102150405.000000") + "/"
+	userKey, certKey, strayKey := prefix+"users/me.key", prefix+"certificates/a.crt", prefix+"stray.crt"
+	require.NoError(t, s.Store(ctx, userKey, []byte("key")))
+	require.NoError(t, s.Store(ctx, certKey, []byte("cert")))
+
+	// Blobs of keys that are not routed to the route's container
+	routeClient := s.routes[0].client
+	_, err := routeClient.UploadBlob(ctx, certKey, []byte("stale"), nil)
+	require.NoError(t, err)
+	_, err = routeClient.UploadBlob(ctx, strayKey, []byte("stray"), nil)
+	require.NoError(t, err)
+
+	report, err := s.Reconcile(ctx)
+	require.NoError(t, err)
+	for _, key := range []string{userKey, certKey, strayKey} {
+		assert.NotContains(t, report.MissingOnSecondary, key)
+		assert.NotContains(t, report.OnlyOnSecondary, key)
+		assert.NotContains(t, report.Different, key)
+	}
+}
+
+// Test that the audit journal, which is not replicated, is not reported as missing.
+func TestReconcileSkipsAuditJournal(t *testing.T) {
+	prefix := "reconcile-audit/" + time.Now().Format("20060102150405.000000") + "/"
+	s := setupTestStorageWith(t, func(config *Config) {
+		config.Audit = &AuditConfig{Prefix: prefix + "journal/"}
+		config.Secondary = &SecondaryConfig{
+			AccountName:      config.AccountName,
+			ContainerName:    testSecondaryContainerName,
+			ConnectionString: config.ConnectionString,
+		}
+	})
+	ctx := context.Background()
+	require.NoError(t, s.Store(ctx, prefix+"a.crt", []byte("cert")))
+	keys, err := s.List(ctx, prefix+"journal/", true)
+	require.NoError(t, err)
+	require.NotEmpty(t, keys, "Store should have written an audit record")
+
+	report, err := s.Reconcile(ctx)
+	require.NoError(t, err)
+	for _, key := range report.MissingOnSecondary {
+		assert.False(t, strings.HasPrefix(key, prefix), "Unexpected difference %s", key)
+	}
+}
+
+func TestReconcileWithoutSecondary(t *testing.T) {
+	s := newStorage(unreachableClient(t), Config{})
+	_, err := s.Reconcile(context.Background())
+	assert.Error(t, err)
+}
+
+func TestReplicateAsyncDropsWritesWhenQueueIsFull(t *testing.T) {
+	events := &eventRecorder{}
+	s := newStorage(unreachableClient(t), Config{Events: events.emit})
+	s.secondary = &secondary{client: unreachableClient(t), queue: make(chan replicaOp, 1)}
+	ctx := context.Background()
+
+	// Neither write fails the caller; the second one does not fit into the queue
+	require.NoError(t, s.replicate(ctx, replicaOp{key: "a"}))
+	require.NoError(t, s.replicate(ctx, replicaOp{key: "b"}))
+	require.Len(t, s.secondary.queue, 1)
+	assert.Equal(t, "a", (<-s.secondary.queue).key)
+	assert.Equal(t, []string{EventReplicationDropped}, events.names("b"))
+}
+
+// Test that the writes still queued when replication stops are applied before
+// RunReplication returns.
+func TestRunReplicationDrainsQueue(t *testing.T) {
+	s := setupDualWriteStorage(t, true)
+	key := "secondary/drain/" + time.Now().Format(time.RFC3339Nano) + ".txt"
+	require.NoError(t, s.Store(context.Background(), key, []byte("replicated")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.RunReplication(ctx)
+
+	blobVal, err := s.download(context.Background(), s.secondary.client, key, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("replicated"), blobVal.data)
+}
+
+func TestRunReplicationDropsWritesItCannotDrain(t *testing.T) {
+	events := &eventRecorder{}
+	s := newStorage(unreachableClient(t), Config{Events: events.emit})
+	s.secondary = &secondary{
+		client:        unreachableClient(t),
+		queue:         make(chan replicaOp, 2),
+		maxAttempts:   DefaultSecondaryMaxAttempts,
+		retryInterval: time.Hour,
+		drainTimeout:  10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, s.replicate(ctx, replicaOp{key: "a"}))
+	require.NoError(t, s.replicate(ctx, replicaOp{key: "b"}))
+
+	s.RunReplication(ctx)
+	assert.Empty(t, s.secondary.queue)
+	assert.Equal(t, []string{EventReplicationDropped}, events.names("a"))
+	assert.Equal(t, []string{EventReplicationDropped}, events.names("b"))
+}
+
+func TestApplyWithRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	events := &eventRecorder{}
+	s := newStorage(unreachableClient(t), Config{Logger: zap.New(core), Events: events.emit})
+	s.secondary = &secondary{client: unreachableClient(t), maxAttempts: 3, retryInterval: time.Millisecond}
+
+	assert.True(t, s.applyWithRetry(context.Background(), replicaOp{key: "a", value: []byte("a")}))
+	assert.Equal(t, []string{EventReplicationDropped}, events.names("a"))
+
+	assert.Len(t, logs.FilterMessage("replicating to secondary failed; retrying").All(), 2)
+	failed := logs.FilterMessage("replicating to secondary failed; giving up").All()
+	require.Len(t, failed, 1)
+	assert.Equal(t, "a", failed[0].ContextMap()["key"])
+}
+
+func TestRunReplicationWithoutQueueReturns(t *testing.T) {
+	s := newStorage(unreachableClient(t), Config{})
+	s.RunReplication(context.Background())
+}
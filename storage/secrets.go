@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"go.uber.org/zap"
+)
+
+// DefaultSecretReloadInterval is how often WatchSecrets re-reads secret files when no
+// interval is given.
+const DefaultSecretReloadInterval = time.Minute
+
+// secretFile returns the configured secret file, if any.
+func (c Config) secretFile() string {
+	switch {
+	case c.ConnectionStringFile != "":
+		return c.ConnectionStringFile
+	case c.AccountKeyFile != "":
+		return c.AccountKeyFile
+	default:
+		return c.SASTokenFile
+	}
+}
+
+// readSecret reads a secret file, ignoring surrounding whitespace such as the trailing
+// newline most tools write.
+func readSecret(name string) (string, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// newContainerClient creates the container client described by config. If the
+// credentials come from a secret file, the secret that was read is returned too.
+//
+//nolint:nestif // Functionally correct and readable
+func newContainerClient(config Config, options azcore.ClientOptions) (*container.Client, string, error) {
+	var secret string
+	if file := config.secretFile(); file != "" {
+		var err error
+		if secret, err = readSecret(file); err != nil {
+			return nil, "", err
+		}
+	}
+	containerURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s", config.AccountName, config.ContainerName)
+	containerOptions := &container.ClientOptions{ClientOptions: options}
+
+	switch {
+	case config.ConnectionString != "" || config.ConnectionStringFile != "":
+		// Use connection string
+		connectionString := config.ConnectionString
+		if config.ConnectionStringFile != "" {
+			connectionString = secret
+		}
+		containerClient, err := container.NewClientFromConnectionString(connectionString, config.ContainerName, containerOptions)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not initialize container client with connection string: %w", err)
+		}
+		return containerClient, secret, nil
+
+	case config.AccountKeyFile != "":
+		credential, err := container.NewSharedKeyCredential(config.AccountName, secret)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not create shared key credential: %w", err)
+		}
+		containerClient, err := container.NewClientWithSharedKeyCredential(containerURL, credential, containerOptions)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not initialize container client with account key: %w", err)
+		}
+		return containerClient, secret, nil
+
+	case config.SASTokenFile != "":
+		containerClient, err := container.NewClientWithNoCredential(containerURL+"?"+strings.TrimPrefix(secret, "?"), containerOptions)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not initialize container client with SAS token: %w", err)
+		}
+		return containerClient, secret, nil
+
+	default:
+		// Use credential (explicit or default chain)
+		credential, err := tokenCredential(config, options)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// Use managed identity or other credential
+		accountURL := fmt.Sprintf("https://%s.blob.core.windows.net/", config.AccountName)
+		serviceClient, err := azblob.NewClient(accountURL, credential, &azblob.ClientOptions{ClientOptions: options})
+		if err != nil {
+			return nil, "", fmt.Errorf("could not initialize service client: %w", err)
+		}
+		return serviceClient.ServiceClient().NewContainerClient(config.ContainerName), "", nil
+	}
+}
+
+// tokenCredential returns config.Credential, or the default Azure credential chain
+// (Azure CLI, managed identity, etc.) if it is nil.
+func tokenCredential(config Config, options azcore.ClientOptions) (azcore.TokenCredential, error) {
+	if config.Credential != nil {
+		return config.Credential, nil
+	}
+	credential, err := azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: azcore.ClientOptions{Transport: options.Transport},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create default Azure credential: %w", err)
+	}
+	return credential, nil
+}
+
+// client returns the current container client.
+func (s *Storage) client() blobClient {
+	return *s.containerClient.Load()
+}
+
+// setClient replaces the container client.
+func (s *Storage) setClient(client blobClient) {
+	s.containerClient.Store(&client)
+}
+
+// ReloadSecrets re-reads the configured secret file and, if its content changed,
+// atomically replaces the container client used by all subsequent operations. Held
+// locks keep their leases. It reports whether the client was replaced; without a
+// secret file it does nothing.
+func (s *Storage) ReloadSecrets() (bool, error) {
+	if s.config.secretFile() == "" {
+		return false, nil
+	}
+
+	s.secretMu.Lock()
+	defer s.secretMu.Unlock()
+
+	secret, err := readSecret(s.config.secretFile())
+	if err != nil {
+		return false, err
+	}
+	if secret == s.secret {
+		return false, nil
+	}
+
+	containerClient, secret, err := newContainerClient(s.config, s.clientOptions)
+	if err != nil {
+		return false, err
+	}
+	s.setClient(azureBlobs{containerClient})
+	s.secret = secret
+	s.rebindLeases(s.client())
+	return true, nil
+}
+
+// rebindLeases moves the held leases onto client, so renewing and releasing them uses
+// the new credentials.
+func (s *Storage) rebindLeases(client blobClient) {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+
+	for key, state := range s.activeLocks {
+		leaseID := state.leaseClient.LeaseID()
+		leaseClient, err := client.NewBlobLeaseClient(s.objLockName(key), &lease.BlobClientOptions{
+			LeaseID: leaseID,
+		})
+		if err != nil {
+			s.logger.Warn("rebinding lock lease to reloaded client", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		state.leaseClient = leaseClient
+		s.activeLocks[key] = state
+	}
+}
+
+// WatchSecrets calls ReloadSecrets every interval until ctx is done, so that rotated
+// secret files (e.g. Kubernetes secrets or Key Vault CSI mounts) take effect without a
+// restart. Failed reloads are logged and the previous client stays in use.
+func (s *Storage) WatchSecrets(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSecretReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := s.ReloadSecrets()
+			if err != nil {
+				s.logger.Error("reloading storage secret", zap.String("file", s.config.secretFile()), zap.Error(err))
+				continue
+			}
+			if reloaded {
+				s.logger.Info("reloaded rotated storage secret", zap.String("file", s.config.secretFile()))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
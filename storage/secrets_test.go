@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSecret(t *testing.T, name, value string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(name, []byte(value+"\n"), 0o600))
+}
+
+func TestNewContainerClientFromSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	base := Config{AccountName: "myaccount", ContainerName: "caddy"}
+
+	sasFile := filepath.Join(dir, "sas")
+	writeSecret(t, sasFile, "?sv=2024-01-01&sig=abc")
+	config := base
+	config.SASTokenFile = sasFile
+	client, secret, err := newContainerClient(config, azcore.ClientOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "?sv=2024-01-01&sig=abc", secret)
+	assert.Equal(t, "https://myaccount.blob.core.windows.net/caddy?sv=2024-01-01&sig=abc", client.URL())
+
+	keyFile := filepath.Join(dir, "key")
+	writeSecret(t, keyFile, base64.StdEncoding.EncodeToString([]byte("account-key")))
+	config = base
+	config.AccountKeyFile = keyFile
+	client, _, err = newContainerClient(config, azcore.ClientOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://myaccount.blob.core.windows.net/caddy", client.URL())
+
+	connectionStringFile := filepath.Join(dir, "connection-string")
+	writeSecret(t, connectionStringFile, "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;"+
+		"AccountKey="+base64.StdEncoding.EncodeToString([]byte("account-key"))+";"+
+		"BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;")
+	config = base
+	config.ConnectionStringFile = connectionStringFile
+	client, _, err = newContainerClient(config, azcore.ClientOptions{})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(client.URL(), "http://127.0.0.1:10000/"), client.URL())
+
+	config = base
+	config.SASTokenFile = filepath.Join(dir, "missing")
+	_, _, err = newContainerClient(config, azcore.ClientOptions{})
+	assert.Error(t, err)
+}
+
+func TestReloadSecrets(t *testing.T) {
+	sasFile := filepath.Join(t.TempDir(), "sas")
+	writeSecret(t, sasFile, "sig=first")
+	config := Config{AccountName: "myaccount", ContainerName: "caddy", SASTokenFile: sasFile}
+
+	client, secret, err := newContainerClient(config, azcore.ClientOptions{})
+	require.NoError(t, err)
+	s := newStorage(azureBlobs{client}, config)
+	s.secret = secret
+
+	reloaded, err := s.ReloadSecrets()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "An unchanged secret should not rebuild the client")
+	assert.Same(t, client, s.client().(azureBlobs).Client)
+
+	writeSecret(t, sasFile, "sig=second")
+	reloaded, err = s.ReloadSecrets()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "https://myaccount.blob.core.windows.net/caddy?sig=second", s.client().URL())
+
+	rotated := s.client().(azureBlobs).Client
+	require.NoError(t, os.Remove(sasFile))
+	_, err = s.ReloadSecrets()
+	require.Error(t, err)
+	assert.Same(t, rotated, s.client().(azureBlobs).Client, "A failed reload should keep the current client")
+}
+
+func TestReloadSecretsWithoutSecretFile(t *testing.T) {
+	s := newStorage(nil, Config{ConnectionString: "UseDevelopmentStorage=true"})
+	reloaded, err := s.ReloadSecrets()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"go.uber.org/zap"
+)
+
+// DeletedKeyInfo describes a soft-deleted key that can still be recovered with Undelete.
+type DeletedKeyInfo struct {
+	// DeletedAt is when the blob was deleted (zero if Azure did not report it).
+	DeletedAt time.Time
+	// Key is the name of the deleted blob.
+	Key string
+	// Size is the size of the deleted blob in bytes.
+	Size int64
+	// RemainingRetentionDays is the number of days until Azure permanently removes the blob.
+	RemainingRetentionDays int32
+}
+
+// ListDeleted returns all soft-deleted keys that match prefix. Keys are only listed while
+// they are within the container's soft delete retention period; if soft delete is not
+// enabled on the storage account the result is always empty. Lock blobs are not listed,
+// since restoring them would not restore a lock.
+func (s *Storage) ListDeleted(ctx context.Context, prefix string) (deleted []DeletedKeyInfo, err error) {
+	ctx, span := s.startSpan(ctx, "ListDeleted", attrPrefix.String(prefix))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.List)
+	defer cancel()
+
+	blobPrefix := s.blobPrefix(prefix)
+	for _, b := range s.backends() {
+		pager := b.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+			Prefix:  &blobPrefix,
+			Include: container.ListBlobsInclude{Deleted: true, Metadata: true},
+		})
+
+		for pager.More() {
+			resp, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("listing deleted blobs: %w", err)
+			}
+
+			for _, blob := range resp.Segment.BlobItems {
+				if blob.Name == nil || blob.Deleted == nil || !*blob.Deleted || strings.HasSuffix(*blob.Name, lockSuffix) {
+					continue
+				}
+
+				key, ok := s.keyName(*blob.Name, blob.Metadata)
+				if !ok || !strings.HasPrefix(key, prefix) || !s.owns(b, key) {
+					continue
+				}
+				info := DeletedKeyInfo{Key: key}
+				if props := blob.Properties; props != nil {
+					if props.DeletedTime != nil {
+						info.DeletedAt = *props.DeletedTime
+					}
+					if props.ContentLength != nil {
+						info.Size = *props.ContentLength
+					}
+					if props.RemainingRetentionDays != nil {
+						info.RemainingRetentionDays = *props.RemainingRetentionDays
+					}
+				}
+				deleted = append(deleted, info)
+			}
+		}
+	}
+
+	return deleted, nil
+}
+
+// Undelete restores the soft-deleted key. If no deleted blob is named exactly key, key is
+// treated as a directory and every soft-deleted blob beneath key+"/" is restored, which
+// mirrors the recursive behavior of Delete. fs.ErrNotExist is returned if nothing could be restored.
+//
+// Undelete relies on blob soft delete; on accounts with blob versioning enabled, deleted
+// blobs become previous versions instead and must be restored by promoting a version.
+func (s *Storage) Undelete(ctx context.Context, key string) (err error) {
+	ctx, span := s.startSpan(ctx, "Undelete", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	if err := s.checkWritable("undelete", key); err != nil {
+		return err
+	}
+	var keysToRestore []string
+	defer func() {
+		record := AuditRecord{Op: AuditUndelete, Key: key}
+		if len(keysToRestore) != 1 || keysToRestore[0] != key {
+			record.Keys = keysToRestore
+		}
+		s.record(ctx, record, err)
+	}()
+
+	deleted, err := s.ListDeleted(ctx, key)
+	if err != nil {
+		return fmt.Errorf("finding deleted blobs for %s: %w", key, err)
+	}
+
+	// Only restore beneath key as a directory, so undeleting "a/b" keeps "a/bc" deleted.
+	dir := key
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	for _, info := range deleted {
+		if info.Key == key {
+			keysToRestore = []string{key}
+			break
+		}
+		if strings.HasPrefix(info.Key, dir) {
+			keysToRestore = append(keysToRestore, info.Key)
+		}
+	}
+	if len(keysToRestore) == 0 {
+		return fs.ErrNotExist
+	}
+
+	var undeleteErrs []string
+	for _, restoreKey := range keysToRestore {
+		_, err := s.clientFor(restoreKey).UndeleteBlob(ctx, s.blobName(restoreKey))
+		s.invalidateCached(restoreKey)
+		if err != nil {
+			var responseError *azcore.ResponseError
+			if errors.As(err, &responseError) && responseError.StatusCode == 404 {
+				s.logger.Debug("deleted blob was permanently removed before it could be restored",
+					zap.String("key", restoreKey))
+				continue
+			}
+			undeleteErrs = append(undeleteErrs, fmt.Sprintf("%s: %v", restoreKey, err))
+			continue
+		}
+		if err := s.restored(ctx, restoreKey); err != nil {
+			undeleteErrs = append(undeleteErrs, fmt.Sprintf("%s: %v", restoreKey, err))
+		}
+	}
+	if len(undeleteErrs) > 0 {
+		return fmt.Errorf("errors undeleting blobs: %s", strings.Join(undeleteErrs, "; "))
+	}
+	return nil
+}
+
+// restored brings the mirror and the secondary up to date with the restored blob of key,
+// as Store does with a stored one, and emits EventUndeleted. Audit blobs stay out of
+// both, as they do when written.
+func (s *Storage) restored(ctx context.Context, key string) error {
+	if (s.mirror != nil || s.secondary != nil) && !s.isAuditKey(key) {
+		response, err := s.clientFor(key).DownloadBlob(ctx, s.blobName(key), nil)
+		if err != nil {
+			return fmt.Errorf("reading restored blob: %w", err)
+		}
+		defer response.Body.Close()
+		value, err := io.ReadAll(response.Body)
+		if err != nil {
+			return fmt.Errorf("reading restored blob: %w", err)
+		}
+		s.writeMirror(ctx, key, value)
+		if err := s.replicate(ctx, replicaOp{key: key, value: value, metadata: response.Metadata}); err != nil {
+			return fmt.Errorf("replicating to secondary: %w", err)
+		}
+	}
+	s.emit(EventUndeleted, map[string]any{"key": key})
+	return nil
+}
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findDeleted reports whether key is among the soft-deleted keys under prefix.
+func findDeleted(t *testing.T, s *Storage, prefix, key string) bool {
+	t.Helper()
+	deleted, err := s.ListDeleted(context.Background(), prefix)
+	require.NoError(t, err)
+	for _, info := range deleted {
+		if info.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Test that a deleted key shows up in ListDeleted and can be restored with Undelete.
+// Skipped when the account does not have blob soft delete enabled (e.g. Azurite).
+func TestUndeleteRestoresDeletedKey(t *testing.T) {
+	s := setupTestStorage(t)
+	ctx := context.Background()
+
+	key := "undelete-test/file.txt"
+	content := []byte("recover me")
+
+	require.NoError(t, s.Store(ctx, key, content))
+	t.Cleanup(func() { _ = s.Delete(context.Background(), key) })
+
+	require.NoError(t, s.Delete(ctx, key))
+	if !findDeleted(t, s, "undelete-test/", key) {
+		t.Skip("blob soft delete is not enabled on this storage account")
+	}
+
+	err := s.Undelete(ctx, key)
+	require.NoError(t, err)
+
+	loaded, err := s.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, content, loaded)
+}
+
+// Test that Undelete on a prefix restores every deleted child key.
+func TestUndeletePrefixRestoresChildren(t *testing.T) {
+	s := setupTestStorage(t)
+	ctx := context.Background()
+
+	prefix := "undelete-prefix-test/"
+	childKeys := []string{
+		prefix + "file1.txt",
+		prefix + "sub/file2.txt",
+	}
+
+	for _, key := range childKeys {
+		require.NoError(t, s.Store(ctx, key, []byte("child content")))
+	}
+	t.Cleanup(func() { _ = s.Delete(context.Background(), prefix) })
+
+	require.NoError(t, s.Delete(ctx, prefix))
+	if !findDeleted(t, s, prefix, childKeys[0]) {
+		t.Skip("blob soft delete is not enabled on this storage account")
+	}
+
+	require.NoError(t, s.Undelete(ctx, prefix))
+
+	for _, key := range childKeys {
+		assert.True(t, s.Exists(ctx, key), "Child key should be restored by prefix undelete: %s", key)
+	}
+}
+
+// Test Undelete on a key that was never deleted (should return fs.ErrNotExist)
+func TestUndeleteNonExistentKey(t *testing.T) {
+	s := setupTestStorage(t)
+	ctx := context.Background()
+
+	err := s.Undelete(ctx, "never-deleted-prefix/definitely-not-a-real-key.txt")
+	assert.ErrorIs(t, err, fs.ErrNotExist, "Undelete with nothing to restore should return fs.ErrNotExist")
+}
+
+// deletedTransport fakes a container whose blobs are all soft-deleted, and records the
+// blobs that are undeleted and the blocks appended to the audit journal. Downloads
+// return the blob's entry in contents.
+type deletedTransport struct {
+	deleted   []string
+	undeleted []string
+	contents  map[string]string
+	blocks    []string
+}
+
+func (t *deletedTransport) Do(req *http.Request) (*http.Response, error) {
+	query := req.URL.Query()
+	header := http.Header{}
+	status, body := http.StatusOK, ""
+	switch {
+	case req.Method == http.MethodGet && query.Get("comp") == "list":
+		var blobs string
+		for _, name := range t.deleted {
+			if strings.HasPrefix(name, query.Get("prefix")) {
+				blobs += fmt.Sprintf("<Blob><Name>%s</Name><Deleted>true</Deleted><Properties></Properties></Blob>", name)
+			}
+		}
+		body = `<?xml version="1.0" encoding="utf-8"?><EnumerationResults><Blobs>` + blobs + `</Blobs><NextMarker/></EnumerationResults>`
+		header.Set("Content-Type", "application/xml")
+	case req.Method == http.MethodPut && query.Get("comp") == "undelete":
+		t.undeleted = append(t.undeleted, strings.TrimPrefix(req.URL.Path, "/container/"))
+	case req.Method == http.MethodGet && query.Get("comp") == "":
+		body = t.contents[strings.TrimPrefix(req.URL.Path, "/container/")]
+	case query.Get("comp") == "appendblock":
+		block, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		t.blocks = append(t.blocks, string(block))
+		status = http.StatusCreated
+	// The SDK does not canonicalize its x-ms-* header names
+	case slices.Contains(req.Header["x-ms-blob-type"], "AppendBlob"):
+		status = http.StatusCreated
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// Test that Undelete restores a directory without sibling keys sharing its name as a
+// prefix, and that deleted lock blobs are neither listed nor restored.
+func TestUndeleteDirectoryKeepsSiblingKeys(t *testing.T) {
+	transport := &deletedTransport{deleted: []string{
+		"certificates/foo/site.crt",
+		"certificates/foo/site.crt.lock",
+		"certificates/foobar/site.crt",
+	}}
+	s := newStorage(fakeContainer(t, "container", transport), Config{})
+	ctx := context.Background()
+
+	deleted, err := s.ListDeleted(ctx, "certificates/")
+	require.NoError(t, err)
+	var keys []string
+	for _, info := range deleted {
+		keys = append(keys, info.Key)
+	}
+	assert.Equal(t, []string{"certificates/foo/site.crt", "certificates/foobar/site.crt"}, keys)
+
+	require.NoError(t, s.Undelete(ctx, "certificates/foo"))
+	assert.Equal(t, []string{"certificates/foo/site.crt"}, transport.undeleted)
+}
+
+// Test that Undelete has the side effects of Store: the restored key is written to the
+// mirror and the secondary, recorded in the audit journal and announced by an event.
+func TestUndeleteUpdatesMirrorAndSecondary(t *testing.T) {
+	key := "certificates/foo/site.crt"
+	transport := &deletedTransport{deleted: []string{key}, contents: map[string]string{key: "cert"}}
+	replica := &pathTransport{}
+	events := &eventRecorder{}
+	s := newStorage(fakeContainer(t, "container", transport), Config{
+		MirrorDir: t.TempDir(),
+		Audit:     &AuditConfig{Node: "edge-1"},
+		Events:    events.emit,
+	})
+	s.secondary = &secondary{client: fakeContainer(t, "replica", replica)}
+	ctx := context.Background()
+
+	require.NoError(t, s.Undelete(ctx, "certificates/foo"))
+	mirrored, err := s.mirror.Load(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("cert"), mirrored)
+	assert.Equal(t, []string{"/replica/certificates%2Ffoo%2Fsite.crt"}, replica.paths)
+	assert.Equal(t, []string{EventUndeleted}, events.names(key))
+
+	records := decodeRecords(t, strings.Join(transport.blocks, ""))
+	require.Len(t, records, 1)
+	assert.Equal(t, AuditUndelete, records[0].Op)
+	assert.Equal(t, "certificates/foo", records[0].Key)
+	assert.Equal(t, []string{key}, records[0].Keys)
+	assert.Equal(t, "success", records[0].Outcome)
+}
@@ -8,14 +8,28 @@ import (
 	"io/fs"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
 	"github.com/caddyserver/certmagic"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// lockSuffix is appended to a key to name the blob that holds its lock lease.
+	lockSuffix = ".lock"
+	// modifiedMetadataKey is the blob metadata entry holding a key's original
+	// modification time when it was copied from another storage by Migrate.
+	modifiedMetadataKey = "certmagicmodified"
 )
 
 var (
@@ -26,11 +40,16 @@ var (
 	// LockPollInterval is the interval between lease acquisition retries
 	LockPollInterval = 1 * time.Second
 
+	// ErrArchived is returned by Load when the key is stored in the Archive access tier
+	// and must be rehydrated to an online tier before it can be read.
+	ErrArchived = errors.New("blob is in the archive access tier")
+
 	errNoActiveLease = errors.New("no active lock lease")
+	errNotModified   = errors.New("blob not modified")
 )
 
 type activeLease struct {
-	leaseClient      *lease.BlobClient
+	leaseClient      leaseClient
 	renewCancel      context.CancelFunc
 	acquiredAt       time.Time
 	lastRenewedAt    time.Time
@@ -39,7 +58,29 @@ type activeLease struct {
 
 // Storage is a certmagic.Storage backed by an Azure Blob Storage container
 type Storage struct {
-	containerClient *container.Client
+	// containerClient is replaced when rotated secrets are reloaded; use client().
+	containerClient atomic.Pointer[blobClient]
+	// cache is the optional read-through cache for Load, Stat and Exists.
+	cache *keyCache
+	// mirror is the optional local copy served while Azure is unreachable.
+	mirror *certmagic.FileStorage
+	// secondary is the optional second account that writes are replicated to.
+	secondary *secondary
+	// routes are the containers that keys matching their prefixes are stored in.
+	routes []route
+	// audit is the optional journal that mutations are recorded in.
+	audit    *audit
+	codec    KeyCodec
+	timeouts Timeouts
+	logger   *zap.Logger
+	metrics  Metrics
+	tracer   trace.Tracer
+	events   func(name string, data map[string]any)
+	// config, clientOptions and secret are used to rebuild the client on secret rotation.
+	config        Config
+	clientOptions azcore.ClientOptions
+	secret        string
+	secretMu      sync.Mutex
 	// activeLocks tracks active lease state per logical lock key.
 	activeLocks map[string]activeLease
 	locksMu     sync.Mutex
@@ -61,112 +102,363 @@ type Config struct {
 	ContainerName string
 	// ConnectionString is the Azure Storage connection string (optional)
 	ConnectionString string
+	// ConnectionStringFile is a file holding the connection string (optional)
+	ConnectionStringFile string
+	// AccountKeyFile is a file holding the storage account key (optional)
+	AccountKeyFile string
+	// SASTokenFile is a file holding a SAS token for the container (optional)
+	SASTokenFile string
+	// Cache enables an in-memory read-through cache for Load, Stat and Exists (optional)
+	Cache *CacheConfig
+	// MirrorDir is a local directory kept in sync with stored and loaded keys. When Azure
+	// is unreachable, Load, Stat, Exists and List are served from it (optional)
+	MirrorDir string
+	// Secondary replicates Store and Delete to a second account, which Load, Stat and List
+	// fall back to while the primary is unreachable (optional)
+	Secondary *SecondaryConfig
+	// ReadSecondaryEndpoint retries reads against the account's read-access geo-redundant
+	// endpoint (<account>-secondary) when the primary endpoint fails. Once reads keep
+	// failing, new writes and locks fail with ErrPrimaryUnavailable for a while (optional)
+	ReadSecondaryEndpoint bool
+	// Retry configures the SDK's retries of failed requests (optional)
+	Retry RetryConfig
+	// Timeouts are deadlines for Storage operations whose context has none (optional)
+	Timeouts Timeouts
+	// Throttle limits concurrent requests to the account, adapting to throttling (optional)
+	Throttle *ThrottleConfig
+	// Transport customizes the HTTP client, e.g. to use a proxy (optional)
+	Transport *TransportConfig
+	// KeyCodec maps keys to blob names and back. Defaults to PlainKeys (optional)
+	KeyCodec KeyCodec
+	// Audit appends a record of every mutation to an audit journal (optional)
+	Audit *AuditConfig
+	// Routes send keys matching their prefixes to separate containers or accounts (optional)
+	Routes []RouteConfig
+	// ReadOnly makes Store, Delete and Lock fail with ErrReadOnly, e.g. on edge nodes
+	// that only serve certificates. The container is not created, as with
+	// CreateContainerNever (optional)
+	ReadOnly bool
+	// CreateContainer controls whether NewStorage creates the container. Defaults to
+	// CreateContainerAuto (optional)
+	CreateContainer ContainerCreation
+	// ContainerMetadata is set on the container when NewStorage creates it (optional)
+	ContainerMetadata map[string]string
+	// ContainerAccess is the public access level of the container when NewStorage creates
+	// it. Defaults to private (optional)
+	ContainerAccess container.PublicAccessType
+	// RequireEntraAuth makes CheckPosture require that the storage authenticates with
+	// Microsoft Entra ID and that the account rejects shared key authorization (optional)
+	RequireEntraAuth bool
+	// PostureWriteProbe lets CheckPosture write and delete a scratch blob under
+	// .azureblob-posture/ to detect versioning and soft delete where the container's
+	// listing shows neither. Versioning and soft delete keep the deleted blob for their
+	// retention period (optional)
+	PostureWriteProbe bool
+	// ProtectedPrefixes are key prefixes, optionally with path.Match wildcards such as
+	// "acme/*/users/", whose keys can be created but not overwritten or deleted unless
+	// the context comes from OverrideProtection. Denied calls fail with ErrProtectedKey
+	// (optional)
+	ProtectedPrefixes []string
+	// Logger receives the storage's log output (optional)
+	Logger *zap.Logger
+	// Metrics receives operation and lock measurements (optional)
+	Metrics Metrics
+	// TracerProvider creates the tracing spans for storage operations and Azure requests.
+	// Defaults to the global OpenTelemetry TracerProvider (optional)
+	TracerProvider trace.TracerProvider
+	// Events is called for storage mutations and lock changes; see the Event constants.
+	// It must not block (optional)
+	Events func(name string, data map[string]any)
 }
 
-//nolint:nestif // Functionally correct and readable
 func NewStorage(ctx context.Context, config Config) (*Storage, error) {
-	var containerClient *container.Client
-	var err error
+	if config.Logger == nil {
+		config.Logger = zap.NewNop()
+	}
+	if config.TracerProvider == nil {
+		config.TracerProvider = otel.GetTracerProvider()
+	}
+	logger := config.Logger
+	clientOptions := azcore.ClientOptions{
+		Retry: config.Retry.options(),
+		PerRetryPolicies: []policy.Policy{
+			requestLogPolicy{logger: logger},
+			requestTracePolicy{tracer: config.TracerProvider.Tracer(tracerName)},
+		},
+	}
 
-	if config.ConnectionString != "" {
-		// Use connection string
-		containerClient, err = container.NewClientFromConnectionString(config.ConnectionString, config.ContainerName, nil)
+	if config.Transport != nil {
+		httpClient, err := newHTTPClient(config.Transport)
 		if err != nil {
-			return nil, fmt.Errorf("could not initialize container client with connection string: %w", err)
-		}
-	} else {
-		// Use credential (explicit or default chain)
-		var credential azcore.TokenCredential
-		if config.Credential != nil {
-			credential = config.Credential
-		} else {
-			// Use default Azure credential chain (Azure CLI, managed identity, etc.)
-			credential, err = azidentity.NewDefaultAzureCredential(nil)
-			if err != nil {
-				return nil, fmt.Errorf("could not create default Azure credential: %w", err)
-			}
+			return nil, err
 		}
-
-		// Use managed identity or other credential
-		accountURL := fmt.Sprintf("https://%s.blob.core.windows.net/", config.AccountName)
-		serviceClient, clientErr := azblob.NewClient(accountURL, credential, nil)
-		if clientErr != nil {
-			return nil, fmt.Errorf("could not initialize service client: %w", clientErr)
-		}
-		containerClient = serviceClient.ServiceClient().NewContainerClient(config.ContainerName)
+		clientOptions.Transport = httpClient
 	}
 
-	// Ensure the container exists (create if it doesn't)
-	_, err = containerClient.Create(ctx, nil)
+	primaryOptions := accountOptions(config, clientOptions)
+
+	containerClient, secret, err := newContainerClient(config, primaryOptions)
 	if err != nil {
-		// Check if error is because container already exists (which is fine)
-		var respErr *azcore.ResponseError
-		if !errors.As(err, &respErr) || respErr.ErrorCode != "ContainerAlreadyExists" {
-			return nil, fmt.Errorf("could not create container: %w", err)
+		return nil, err
+	}
+
+	// Ensure the container exists, creating it as config.CreateContainer allows
+	if err := ensureContainer(ctx, azureBlobs{containerClient}, config); err != nil {
+		return nil, err
+	}
+
+	stor := newStorage(azureBlobs{containerClient}, config)
+	if stor.routes, err = newRoutes(ctx, config, clientOptions); err != nil {
+		return nil, err
+	}
+	if config.Secondary != nil {
+		if stor.secondary, err = newSecondary(ctx, config, clientOptions); err != nil {
+			return nil, err
 		}
-		// Container already exists, which is fine - continue
 	}
+	stor.clientOptions = primaryOptions
+	stor.secret = secret
+	return stor, nil
+}
 
-	return &Storage{
-		containerClient: containerClient,
-		activeLocks:     make(map[string]activeLease),
-	}, nil
+// accountOptions returns the client options of the primary account or of a route's
+// account, based on options. The throttle and the geo-redundant endpoint only apply to
+// these accounts, not to Secondary. Every account gets its own throttle and geo-redundant
+// endpoint policy, since they track the throttling and the outages of that account.
+func accountOptions(config Config, options azcore.ClientOptions) azcore.ClientOptions {
+	var policies []policy.Policy
+	if config.Throttle != nil {
+		policies = append(policies, throttlePolicy{throttle: newThrottle(config.Throttle, config.Logger)})
+	}
+	if config.ReadSecondaryEndpoint {
+		policies = append(policies, newSecondaryEndpointPolicy(config.Logger))
+		options.PerCallPolicies = append([]policy.Policy{secondaryEndpointCallPolicy{}}, options.PerCallPolicies...)
+	}
+	options.PerRetryPolicies = append(policies, options.PerRetryPolicies...)
+	return options
+}
+
+// newStorage returns a Storage that uses client, applying the optional parts of config.
+func newStorage(client blobClient, config Config) *Storage {
+	stor := &Storage{
+		cache:       newKeyCache(config.Cache),
+		codec:       config.KeyCodec,
+		timeouts:    config.Timeouts,
+		logger:      config.Logger,
+		metrics:     config.Metrics,
+		events:      config.Events,
+		config:      config,
+		activeLocks: make(map[string]activeLease),
+	}
+	stor.setClient(client)
+	if stor.logger == nil {
+		stor.logger = zap.NewNop()
+	}
+	if stor.metrics == nil {
+		stor.metrics = nopMetrics{}
+	}
+	if stor.codec == nil {
+		stor.codec = PlainKeys{}
+	}
+	tracerProvider := config.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	stor.tracer = tracerProvider.Tracer(tracerName)
+	if config.MirrorDir != "" {
+		stor.mirror = &certmagic.FileStorage{Path: config.MirrorDir}
+	}
+	if config.Audit != nil {
+		stor.audit = newAudit(config.Audit)
+	}
+	return stor
 }
 
 // Store puts value at key.
 func (s *Storage) Store(ctx context.Context, key string, value []byte) error {
-	blockBlobClient := s.containerClient.NewBlockBlobClient(key)
+	return s.store(ctx, key, value, nil)
+}
+
+// storeModified puts value at key and records modified as the key's modification time,
+// which Stat reports instead of the blob's last-modified time.
+func (s *Storage) storeModified(ctx context.Context, key string, value []byte, modified time.Time) error {
+	modifiedValue := modified.UTC().Format(time.RFC3339Nano)
+	return s.store(ctx, key, value, map[string]*string{modifiedMetadataKey: &modifiedValue})
+}
+
+func (s *Storage) store(ctx context.Context, key string, value []byte, metadata map[string]*string) (err error) {
+	ctx, span := s.startSpan(ctx, "Store", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	if err := s.checkWritable("store", key); err != nil {
+		return err
+	}
+	if err := s.checkAuditKey("store", key); err != nil {
+		return err
+	}
+	defer func() {
+		if s.audit != nil {
+			s.record(ctx, AuditRecord{Op: AuditStore, Key: key, Size: len(value), SHA256: contentHash(value)}, err)
+		}
+	}()
+	name := s.blobName(key)
+	metadata = s.keyMetadata(key, name, metadata)
+	options := &blockblob.UploadBufferOptions{Metadata: metadata}
+	prefix, protected := s.protectedPrefix(ctx, key)
+	if protected {
+		etagAny := azcore.ETagAny
+		// Protected keys may be created but not overwritten; the condition makes the
+		// check and the upload atomic
+		options.AccessConditions = &blob.AccessConditions{
+			ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etagAny},
+		}
+	}
 
 	// Upload the blob data directly from bytes
-	_, err := blockBlobClient.UploadBuffer(ctx, value, nil)
+	start := time.Now()
+	_, err = s.clientFor(key).UploadBlob(ctx, name, value, options)
+	s.observe(OpStore, start, err)
+	s.invalidateCached(key)
 	if err != nil {
+		var responseError *azcore.ResponseError
+		if protected && errors.As(err, &responseError) && responseError.ErrorCode == string(bloberror.BlobAlreadyExists) {
+			return &WriteProtectedError{Op: "store", Key: key, Prefix: prefix}
+		}
 		return fmt.Errorf("uploading blob %s: %w", key, err)
 	}
+	s.writeMirror(ctx, key, value)
+	if err := s.replicate(ctx, replicaOp{key: key, value: value, metadata: metadata}); err != nil {
+		return fmt.Errorf("replicating %s to secondary: %w", key, err)
+	}
+	s.emit(EventStored, map[string]any{"key": key, "size": len(value)})
 	return nil
 }
 
 // Load retrieves the value at key.
-func (s *Storage) Load(ctx context.Context, key string) ([]byte, error) {
-	blobClient := s.containerClient.NewBlobClient(key)
+func (s *Storage) Load(ctx context.Context, key string) (data []byte, err error) {
+	ctx, span := s.startSpan(ctx, "Load", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	// The fallbacks below use ctx: they depend on the caller giving up, not on the
+	// operation deadline
+	opCtx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	start := time.Now()
+	data, err = s.load(opCtx, key)
+	s.observe(OpLoad, start, err)
+	if err != nil && s.useSecondary(ctx, err) {
+		data, err = s.loadSecondary(ctx, key, err)
+	}
+	if err != nil && s.useMirror(ctx, err) {
+		return s.loadMirror(ctx, key, err)
+	}
+	return data, err
+}
 
-	response, err := blobClient.DownloadStream(ctx, nil)
+// load retrieves the value at key through the cache, if enabled.
+func (s *Storage) load(ctx context.Context, key string) ([]byte, error) {
+	if s.cache != nil {
+		return s.loadCached(ctx, key)
+	}
+	blobVal, err := s.download(ctx, s.clientFor(key), key, nil)
+	if err != nil {
+		return nil, err
+	}
+	return blobVal.data, nil
+}
+
+// blobValue holds what download and getProperties learned about a blob.
+type blobValue struct {
+	etag azcore.ETag
+	info certmagic.KeyInfo
+	data []byte
+}
+
+// download fetches the value and properties of key from client. If ifNoneMatch is set
+// the request is conditional and errNotModified is returned while the blob still has
+// that ETag.
+func (s *Storage) download(ctx context.Context, client blobClient, key string, ifNoneMatch *azcore.ETag) (blobValue, error) {
+	options := &blob.DownloadStreamOptions{AccessConditions: ifNoneMatchConditions(ifNoneMatch)}
+	response, err := client.DownloadBlob(ctx, s.blobName(key), options)
+	var notFound *azcore.ResponseError
+	if legacy, ok := s.legacyName(key); ok && errors.As(err, &notFound) && notFound.StatusCode == 404 {
+		response, err = client.DownloadBlob(ctx, legacy, options)
+	}
 	if err != nil {
 		// Check if blob doesn't exist
 		var responseError *azcore.ResponseError
 		if errors.As(err, &responseError) && responseError.StatusCode == 404 {
-			return nil, fs.ErrNotExist
+			return blobValue{}, fs.ErrNotExist
+		}
+		if errors.As(err, &responseError) && responseError.StatusCode == 304 {
+			return blobValue{}, errNotModified
+		}
+		if errors.As(err, &responseError) && responseError.ErrorCode == string(bloberror.BlobArchived) {
+			return blobValue{}, fmt.Errorf("downloading blob %s: %w", key, ErrArchived)
 		}
-		return nil, fmt.Errorf("downloading blob %s: %w", key, err)
+		return blobValue{}, fmt.Errorf("downloading blob %s: %w", key, err)
 	}
 	defer response.Body.Close()
+	// The SDK treats 304 as success, with an empty body; the ETag shows the blob is unchanged
+	if ifNoneMatch != nil && response.ETag != nil && *response.ETag == *ifNoneMatch {
+		return blobValue{}, errNotModified
+	}
 
 	data, err := io.ReadAll(response.Body)
 	if err != nil {
-		return nil, fmt.Errorf("reading blob %s: %w", key, err)
+		return blobValue{}, fmt.Errorf("reading blob %s: %w", key, err)
 	}
+	s.writeMirror(ctx, key, data)
 
-	return data, nil
+	return blobValue{
+		etag: derefETag(response.ETag),
+		info: newKeyInfo(key, response.LastModified, int64(len(data)), response.Metadata),
+		data: data,
+	}, nil
 }
 
 // Delete deletes key. An error should be returned only if the key still exists when the method returns.
-func (s *Storage) Delete(ctx context.Context, key string) error {
-	// Check if key is a file or directory
-	info, err := s.Stat(ctx, key)
+func (s *Storage) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := s.startSpan(ctx, "Delete", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	if err := s.checkWritable("delete", key); err != nil {
+		return err
+	}
 	var keysToDelete []string
+	defer func() {
+		record := AuditRecord{Op: AuditDelete, Key: key}
+		if len(keysToDelete) != 1 || keysToDelete[0] != key {
+			record.Keys = keysToDelete
+		}
+		s.record(ctx, record, err)
+	}()
+
+	// Check if key is a file or directory
+	info, statErr := s.Stat(ctx, key)
 	switch {
-	case err == nil:
+	case statErr == nil:
 		// Assume that this is a file and it exists, so delete it directly
 		keysToDelete = []string{key}
-	case errors.Is(err, fs.ErrNotExist):
+	case errors.Is(statErr, fs.ErrNotExist):
 		// If Stat returns not exist, treat as already deleted for files (terminal=true)
 		if info.IsTerminal {
 			// File does not exist, idempotent
 			return nil
 		}
-		// If it's not terminal, it could be a directory that doesn't exist or is empty - try listing
-		childKeys, listErr := s.List(ctx, key, true)
+		// If it's not terminal, it could be a directory that doesn't exist or is empty - try listing.
+		// Only list beneath key as a directory, so deleting "a/b" keeps "a/bc".
+		dir := key
+		if dir != "" && !strings.HasSuffix(dir, "/") {
+			dir += "/"
+		}
+		childKeys, listErr := s.List(ctx, dir, true)
 		if listErr != nil {
 			// If listing fails, treat as already deleted
+			s.logger.Warn("listing keys to delete failed; treating as already deleted",
+				append(errorFields(listErr), zap.String("key", key))...)
 			return nil
 		}
 		if len(childKeys) == 0 {
@@ -175,19 +467,43 @@ func (s *Storage) Delete(ctx context.Context, key string) error {
 		keysToDelete = childKeys
 
 	default:
-		return fmt.Errorf("stat for delete %s: %w", key, err)
+		return fmt.Errorf("stat for delete %s: %w", key, statErr)
+	}
+	// Delete nothing if any key is protected, rather than part of a directory
+	for _, delKey := range keysToDelete {
+		if err := s.checkAuditKey("delete", delKey); err != nil {
+			return err
+		}
+		if prefix, protected := s.protectedPrefix(ctx, delKey); protected {
+			return &WriteProtectedError{Op: "delete", Key: delKey, Prefix: prefix}
+		}
 	}
 
 	var deleteErrs []string
 	for _, delKey := range keysToDelete {
-		blobClient := s.containerClient.NewBlobClient(delKey)
-		_, err := blobClient.Delete(ctx, nil)
+		start := time.Now()
+		client := s.clientFor(delKey)
+		_, err := client.DeleteBlob(ctx, s.blobName(delKey), nil)
+		var notFound *azcore.ResponseError
+		if legacy, ok := s.legacyName(delKey); ok && errors.As(err, &notFound) && notFound.StatusCode == 404 {
+			_, err = client.DeleteBlob(ctx, legacy, nil)
+		}
+		s.observe(OpDelete, start, err)
+		s.invalidateCached(delKey)
 		if err != nil {
 			var responseError *azcore.ResponseError
-			if errors.As(err, &responseError) && responseError.StatusCode == 404 {
-				continue // Already deleted
+			if !errors.As(err, &responseError) || responseError.StatusCode != 404 {
+				deleteErrs = append(deleteErrs, fmt.Sprintf("%s: %v", delKey, err))
+				continue
 			}
-			deleteErrs = append(deleteErrs, fmt.Sprintf("%s: %v", delKey, err))
+			// Already deleted
+		}
+		s.removeMirror(ctx, delKey)
+		if replicateErr := s.replicate(ctx, replicaOp{key: delKey, delete: true}); replicateErr != nil {
+			deleteErrs = append(deleteErrs, fmt.Sprintf("%s: %v", delKey, replicateErr))
+		}
+		if err == nil {
+			s.emit(EventDeleted, map[string]any{"key": delKey})
 		}
 	}
 	if len(deleteErrs) > 0 {
@@ -198,19 +514,51 @@ func (s *Storage) Delete(ctx context.Context, key string) error {
 
 // Exists returns true if the key exists
 func (s *Storage) Exists(ctx context.Context, key string) bool {
-	blobClient := s.containerClient.NewBlobClient(key)
+	ctx, span := s.startSpan(ctx, "Exists", attrKey.String(key))
+	defer span.End()
+	opCtx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
 
-	_, err := blobClient.GetProperties(ctx, nil)
+	start := time.Now()
+	_, err := s.stat(opCtx, key)
+	s.observe(OpExists, start, err)
+	if err != nil && s.useSecondary(ctx, err) {
+		_, err = s.statSecondary(ctx, key, err)
+	}
+	if err != nil && s.useMirror(ctx, err) {
+		_, err = s.statMirror(ctx, key, err)
+	}
 	return err == nil
 }
 
 // List returns all keys that match prefix. If recursive is true, non-terminal keys will be enumerated
 // otherwise, only keys prefixed exactly by prefix will be listed.
-func (s *Storage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+func (s *Storage) List(ctx context.Context, prefix string, recursive bool) (names []string, err error) {
+	ctx, span := s.startSpan(ctx, "List", attrPrefix.String(prefix), attrRecursive.Bool(recursive))
+	defer func() { endSpan(span, err) }()
+	opCtx, cancel := withTimeout(ctx, s.timeouts.List)
+	defer cancel()
+
+	start := time.Now()
+	names, err = s.listRouted(opCtx, prefix, recursive)
+	s.observe(OpList, start, err)
+	if err != nil && s.useSecondary(ctx, err) {
+		names, err = s.listSecondary(ctx, prefix, recursive, err)
+	}
+	if err != nil && s.useMirror(ctx, err) {
+		return s.listMirror(ctx, prefix, recursive, err)
+	}
+	return names, err
+}
+
+// list returns the keys in client's container that match prefix.
+func (s *Storage) list(ctx context.Context, client blobClient, prefix string, recursive bool) ([]string, error) {
 	var names []string
 
-	pager := s.containerClient.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
-		Prefix: &prefix,
+	blobPrefix := s.blobPrefix(prefix)
+	pager := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+		Prefix:  &blobPrefix,
+		Include: container.ListBlobsInclude{Metadata: true},
 	})
 
 	for pager.More() {
@@ -221,14 +569,17 @@ func (s *Storage) List(ctx context.Context, prefix string, recursive bool) ([]st
 
 		for _, blob := range resp.Segment.BlobItems {
 			if blob.Name != nil {
-				blobName := *blob.Name
+				key, ok := s.keyName(*blob.Name, blob.Metadata)
+				if !ok || !strings.HasPrefix(key, prefix) {
+					continue
+				}
 
 				// For non-recursive listing, filter out deeper nested paths
-				if !recursive && strings.Contains(blobName[len(prefix):], "/") {
+				if !recursive && strings.Contains(key[len(prefix):], "/") {
 					continue
 				}
 
-				names = append(names, blobName)
+				names = append(names, key)
 			}
 		}
 	}
@@ -237,35 +588,113 @@ func (s *Storage) List(ctx context.Context, prefix string, recursive bool) ([]st
 }
 
 // Stat returns information about key.
-func (s *Storage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
-	var keyInfo certmagic.KeyInfo
-	blobClient := s.containerClient.NewBlobClient(key)
+func (s *Storage) Stat(ctx context.Context, key string) (keyInfo certmagic.KeyInfo, err error) {
+	ctx, span := s.startSpan(ctx, "Stat", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	opCtx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
 
-	props, err := blobClient.GetProperties(ctx, nil)
+	start := time.Now()
+	keyInfo, err = s.stat(opCtx, key)
+	s.observe(OpStat, start, err)
+	if err != nil && s.useSecondary(ctx, err) {
+		keyInfo, err = s.statSecondary(ctx, key, err)
+	}
+	if err != nil && s.useMirror(ctx, err) {
+		return s.statMirror(ctx, key, err)
+	}
+	return keyInfo, err
+}
+
+// stat returns information about key through the cache, if enabled.
+func (s *Storage) stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	if s.cache != nil {
+		return s.statCached(ctx, key)
+	}
+	blobVal, err := s.getProperties(ctx, s.clientFor(key), key, nil)
+	return blobVal.info, err
+}
+
+// getProperties fetches the properties of key from client. If ifNoneMatch is set the
+// request is conditional and errNotModified is returned while the blob still has that
+// ETag.
+func (s *Storage) getProperties(ctx context.Context, client blobClient, key string, ifNoneMatch *azcore.ETag) (blobValue, error) {
+	options := &blob.GetPropertiesOptions{AccessConditions: ifNoneMatchConditions(ifNoneMatch)}
+	props, err := client.GetBlobProperties(ctx, s.blobName(key), options)
+	var notFound *azcore.ResponseError
+	if legacy, ok := s.legacyName(key); ok && errors.As(err, &notFound) && notFound.StatusCode == 404 {
+		props, err = client.GetBlobProperties(ctx, legacy, options)
+	}
 	if err != nil {
 		// Check if blob doesn't exist
 		var responseError *azcore.ResponseError
 		if errors.As(err, &responseError) && responseError.StatusCode == 404 {
 			// This will return a KeyInfo with IsTerminal=false
 			// which is appropriate for non-existent keys on Azure blob (could be a directory or a missing file)
-			return keyInfo, fs.ErrNotExist
+			return blobValue{}, fs.ErrNotExist
+		}
+		if errors.As(err, &responseError) && responseError.StatusCode == 304 {
+			return blobValue{}, errNotModified
 		}
-		return keyInfo, fmt.Errorf("getting properties for %s: %w", key, err)
+		return blobValue{}, fmt.Errorf("getting properties for %s: %w", key, err)
+	}
+
+	var size int64
+	if props.ContentLength != nil {
+		size = *props.ContentLength
+	}
+	return blobValue{
+		etag: derefETag(props.ETag),
+		info: newKeyInfo(key, props.LastModified, size, props.Metadata),
+	}, nil
+}
+
+// newKeyInfo builds the KeyInfo of an existing blob, preferring a modification time
+// preserved in metadata over the blob's own last-modified time.
+func newKeyInfo(key string, lastModified *time.Time, size int64, metadata map[string]*string) certmagic.KeyInfo {
+	keyInfo := certmagic.KeyInfo{
+		Key:        key,
+		Size:       size,
+		IsTerminal: true,
+	}
+	if lastModified != nil {
+		keyInfo.Modified = *lastModified
+	}
+	if modified, ok := metadataModified(metadata); ok {
+		keyInfo.Modified = modified
+	}
+	return keyInfo
+}
+
+// ifNoneMatchConditions returns access conditions for a conditional read, or nil.
+func ifNoneMatchConditions(etag *azcore.ETag) *blob.AccessConditions {
+	if etag == nil {
+		return nil
 	}
+	return &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: etag},
+	}
+}
 
-	keyInfo.Key = key
-	keyInfo.Modified = *props.LastModified
-	keyInfo.Size = *props.ContentLength
-	keyInfo.IsTerminal = true
-	return keyInfo, nil
+func derefETag(etag *azcore.ETag) azcore.ETag {
+	if etag == nil {
+		return ""
+	}
+	return *etag
 }
 
 // Lock acquires the lock for key, blocking until the lock can be obtained or an error is returned.
-func (s *Storage) Lock(ctx context.Context, key string) error {
-	lockKey := s.objLockName(key)
+func (s *Storage) Lock(ctx context.Context, key string) (err error) {
+	ctx, span := s.startSpan(ctx, "Lock", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.Lock)
+	defer cancel()
+	if err := s.checkWritable("lock", key); err != nil {
+		return err
+	}
+	defer func() { s.record(ctx, AuditRecord{Op: AuditLock, Key: key}, err) }()
 
-	// Create blob client for the lock blob
-	blobClient := s.containerClient.NewBlobClient(lockKey)
+	lockKey := s.objLockName(key)
 
 	// Ensure the lock blob exists. Always attempt creation unconditionally to avoid a
 	// TOCTOU race. Two response codes are expected and safe to ignore:
@@ -273,8 +702,9 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 	// 	 412 Precondition  — blob exists and is currently leased; we cannot overwrite
 	// 			it without a lease ID, but it already exists so we proceed.
 	// Any other error is a genuine failure and is returned to the caller.
-	blockBlobClient := s.containerClient.NewBlockBlobClient(lockKey)
-	_, uploadErr := blockBlobClient.UploadBuffer(ctx, []byte(""), nil)
+	_, uploadErr := s.clientFor(key).UploadBlob(ctx, lockKey, []byte(""), &blockblob.UploadBufferOptions{
+		Metadata: s.keyMetadata(key, strings.TrimSuffix(lockKey, lockSuffix), nil),
+	})
 	if uploadErr != nil {
 		var respErr *azcore.ResponseError
 		if !errors.As(uploadErr, &respErr) || (respErr.StatusCode != 409 && respErr.StatusCode != 412) {
@@ -284,7 +714,7 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 	}
 
 	// Create lease client
-	leaseClient, err := lease.NewBlobClient(blobClient, nil)
+	leaseClient, err := s.clientFor(key).NewBlobLeaseClient(lockKey, nil)
 	if err != nil {
 		return fmt.Errorf("creating lease client for %s: %w", lockKey, err)
 	}
@@ -294,12 +724,14 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 	lockExp := LockExpiration
 
 	// Try to acquire the lease with retries
-	for {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
 		// Attempt to acquire a lease
+		span.SetAttributes(attrAttempts.Int(attempt))
 		_, err := leaseClient.AcquireLease(ctx, lockExp, nil)
 		if err == nil {
 			// Successfully acquired the lease. Start a background goroutine to keep it alive.
-			renewCancel := s.startBackgroundRenewal(leaseClient, lockExp)
+			renewCancel := s.startBackgroundRenewal(key, leaseClient, lockExp)
 			s.locksMu.Lock()
 			s.activeLocks[key] = activeLease{
 				leaseClient: leaseClient,
@@ -307,6 +739,10 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 				renewCancel: renewCancel,
 			}
 			s.locksMu.Unlock()
+			s.metrics.AddLocksHeld(1)
+			s.metrics.ObserveLockAcquired(time.Since(start))
+			s.logger.Debug("acquired lock", zap.String("key", key), zap.Duration("wait", time.Since(start)))
+			s.emit(EventLockAcquired, map[string]any{"key": key, "wait": time.Since(start)})
 			return nil
 		}
 
@@ -314,6 +750,8 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 		var respErr *azcore.ResponseError
 		if errors.As(err, &respErr) && respErr.ErrorCode == "LeaseAlreadyPresent" {
 			// Wait and retry
+			s.metrics.LockContended()
+			s.logger.Debug("lock is held elsewhere; retrying", zap.String("key", key))
 			select {
 			case <-time.After(LockPollInterval):
 				continue
@@ -321,6 +759,14 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 				return ctx.Err()
 			}
 		} else {
+			if ctx.Err() != nil {
+				// The context ended during the request, which Azure may still have granted.
+				// Release the lease so that the lock is not held until it expires.
+				releaseCtx, releaseCancel := withTimeout(context.WithoutCancel(ctx), s.timeouts.Lock)
+				_, _ = leaseClient.ReleaseLease(releaseCtx, nil)
+				releaseCancel()
+				return ctx.Err()
+			}
 			// Some other error occurred
 			return fmt.Errorf("acquiring lease on %s: %w", lockKey, err)
 		}
@@ -329,7 +775,12 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 
 // RenewLockLease renews an active lease for the given logical lock key.
 // This only succeeds for a currently-held lock in this process.
-func (s *Storage) RenewLockLease(ctx context.Context, lockKey string, leaseDuration time.Duration) error {
+func (s *Storage) RenewLockLease(ctx context.Context, lockKey string, leaseDuration time.Duration) (err error) {
+	ctx, span := s.startSpan(ctx, "RenewLockLease", attrKey.String(lockKey))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.Lock)
+	defer cancel()
+
 	s.locksMu.Lock()
 	state, exists := s.activeLocks[lockKey]
 	s.locksMu.Unlock()
@@ -337,15 +788,17 @@ func (s *Storage) RenewLockLease(ctx context.Context, lockKey string, leaseDurat
 		return fmt.Errorf("renewing lease for %s: %w", lockKey, errNoActiveLease)
 	}
 
-	_, err := state.leaseClient.RenewLease(ctx, nil)
+	_, err = state.leaseClient.RenewLease(withPriority(ctx), nil)
+	s.metrics.ObserveLockRenewal(err != nil)
 	if err != nil {
+		s.logger.Warn("renewing lock lease failed", append(errorFields(err), zap.String("key", lockKey))...)
 		return fmt.Errorf("renewing lease for %s: %w", lockKey, err)
 	}
 
 	// Cancel the old background goroutine and start a fresh one to reset the renewal timer.
 	// Capture LockExpiration for the new goroutine to avoid a data race on the global.
 	state.renewCancel()
-	renewCancel := s.startBackgroundRenewal(state.leaseClient, LockExpiration)
+	renewCancel := s.startBackgroundRenewal(lockKey, state.leaseClient, LockExpiration)
 
 	s.locksMu.Lock()
 	current, ok := s.activeLocks[lockKey]
@@ -364,7 +817,12 @@ func (s *Storage) RenewLockLease(ctx context.Context, lockKey string, leaseDurat
 }
 
 // Unlock releases the lock for key by releasing the Azure Blob lease.
-func (s *Storage) Unlock(ctx context.Context, key string) error {
+func (s *Storage) Unlock(ctx context.Context, key string) (err error) {
+	ctx, span := s.startSpan(ctx, "Unlock", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.Lock)
+	defer cancel()
+
 	s.locksMu.Lock()
 	state, exists := s.activeLocks[key]
 	s.locksMu.Unlock()
@@ -372,20 +830,67 @@ func (s *Storage) Unlock(ctx context.Context, key string) error {
 		// Lock was not acquired or already released
 		return nil
 	}
+	defer func() { s.record(ctx, AuditRecord{Op: AuditUnlock, Key: key}, err) }()
 
 	// Stop the background renewal goroutine before releasing the lease.
 	state.renewCancel()
 
 	// Release the lease
-	_, err := state.leaseClient.ReleaseLease(ctx, nil)
+	_, err = state.leaseClient.ReleaseLease(ctx, nil)
 	if err != nil {
+		s.logger.Warn("releasing lock lease failed", append(errorFields(err), zap.String("key", key))...)
 		return fmt.Errorf("releasing lease for %s: %w", key, err)
 	}
 
 	s.locksMu.Lock()
 	delete(s.activeLocks, key)
 	s.locksMu.Unlock()
+	s.metrics.AddLocksHeld(-1)
+	s.logger.Debug("released lock", zap.String("key", key), zap.Duration("held", time.Since(state.acquiredAt)))
+	s.emit(EventLockReleased, map[string]any{"key": key, "held": time.Since(state.acquiredAt)})
+
+	return nil
+}
+
+// ForceUnlock breaks the lease on the lock for key, whichever instance holds it, e.g. to
+// recover from a lock that a crashed instance keeps renewing. Breaking a lock that is
+// not held succeeds.
+func (s *Storage) ForceUnlock(ctx context.Context, key string) (err error) {
+	ctx, span := s.startSpan(ctx, "ForceUnlock", attrKey.String(key))
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.Lock)
+	defer cancel()
+	if err := s.checkWritable("force unlock", key); err != nil {
+		return err
+	}
+	defer func() { s.record(ctx, AuditRecord{Op: AuditForceUnlock, Key: key}, err) }()
+
+	s.locksMu.Lock()
+	state, exists := s.activeLocks[key]
+	delete(s.activeLocks, key)
+	s.locksMu.Unlock()
+	if exists {
+		state.renewCancel()
+		s.metrics.AddLocksHeld(-1)
+	}
 
+	lockKey := s.objLockName(key)
+	leaseClient, err := s.clientFor(key).NewBlobLeaseClient(lockKey, nil)
+	if err != nil {
+		return fmt.Errorf("creating lease client for %s: %w", lockKey, err)
+	}
+	breakPeriod := int32(0)
+	_, err = leaseClient.BreakLease(ctx, &lease.BlobBreakOptions{BreakPeriod: &breakPeriod})
+	if err != nil {
+		var respErr *azcore.ResponseError
+		if errors.As(err, &respErr) && (respErr.StatusCode == 404 || respErr.ErrorCode == string(bloberror.LeaseNotPresentWithLeaseOperation)) {
+			// Not locked
+			return nil
+		}
+		return fmt.Errorf("breaking lease on %s: %w", lockKey, err)
+	}
+	s.logger.Warn("force-unlocked lock", zap.String("key", key))
+	s.emit(EventLockReleased, map[string]any{"key": key, "forced": true})
 	return nil
 }
 
@@ -393,9 +898,9 @@ func (s *Storage) Unlock(ctx context.Context, key string) error {
 // periodically renews the Azure blob lease, and returns the cancel function.
 // Isolating context.Background() here avoids gosec G118 warnings in callers that
 // have a request-scoped context in scope.
-func (s *Storage) startBackgroundRenewal(leaseClient *lease.BlobClient, lockExpiration int32) context.CancelFunc {
+func (s *Storage) startBackgroundRenewal(key string, leaseClient leaseClient, lockExpiration int32) context.CancelFunc {
 	renewCtx, renewCancel := context.WithCancel(context.Background())
-	go s.runLeaseRenewer(renewCtx, leaseClient, lockExpiration)
+	go s.runLeaseRenewer(renewCtx, key, leaseClient, lockExpiration)
 	return renewCancel
 }
 
@@ -403,7 +908,7 @@ func (s *Storage) startBackgroundRenewal(leaseClient *lease.BlobClient, lockExpi
 // at a safe interval (roughly 2/3 of the lease duration) to prevent it from expiring.
 // It stops when ctx is cancelled (e.g., on Unlock or RenewLockLease restart) or on
 // renewal error.
-func (s *Storage) runLeaseRenewer(ctx context.Context, leaseClient *lease.BlobClient, lockExpiration int32) {
+func (s *Storage) runLeaseRenewer(ctx context.Context, key string, leaseClient leaseClient, lockExpiration int32) {
 	renewInterval := time.Duration(lockExpiration) * time.Second * 2 / 3
 	ticker := time.NewTicker(renewInterval)
 	defer ticker.Stop()
@@ -411,9 +916,15 @@ func (s *Storage) runLeaseRenewer(ctx context.Context, leaseClient *lease.BlobCl
 	for {
 		select {
 		case <-ticker.C:
-			_, err := leaseClient.RenewLease(context.Background(), nil)
+			renewCtx, cancel := withTimeout(withPriority(context.Background()), s.timeouts.Lock)
+			_, err := s.currentLeaseClient(key, leaseClient).RenewLease(renewCtx, nil)
+			cancel()
+			s.metrics.ObserveLockRenewal(err != nil)
 			if err != nil {
 				// Stop renewing on error to avoid spinning on a broken lease.
+				s.logger.Error("renewing lock lease failed; lock may be lost",
+					append(errorFields(err), zap.String("key", key))...)
+				s.emit(EventLockLost, map[string]any{"key": key, "error": err.Error()})
 				return
 			}
 		case <-ctx.Done():
@@ -422,6 +933,97 @@ func (s *Storage) runLeaseRenewer(ctx context.Context, leaseClient *lease.BlobCl
 	}
 }
 
+// currentLeaseClient returns the lease client of the held lock on key, which changes
+// when secrets are reloaded, or fallback if the lock is not registered.
+func (s *Storage) currentLeaseClient(key string, fallback leaseClient) leaseClient {
+	s.locksMu.Lock()
+	defer s.locksMu.Unlock()
+	if state, ok := s.activeLocks[key]; ok {
+		return state.leaseClient
+	}
+	return fallback
+}
+
+// LockInfo describes a lock blob and the state of its lease.
+type LockInfo struct {
+	// Modified is when the lock blob was last written.
+	Modified time.Time
+	// Key is the logical key the lock protects (without the lock suffix).
+	Key string
+	// LeaseState is the Azure lease state of the lock blob (available, leased, expired, breaking or broken).
+	LeaseState string
+	// Held reports whether the lock is currently held by any process.
+	Held bool
+}
+
+// ListLocks returns every lock blob in the container along with its lease state.
+func (s *Storage) ListLocks(ctx context.Context) (locks []LockInfo, err error) {
+	ctx, span := s.startSpan(ctx, "ListLocks")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := withTimeout(ctx, s.timeouts.List)
+	defer cancel()
+
+	for _, b := range s.backends() {
+		pager := b.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+			Include: container.ListBlobsInclude{Metadata: true},
+		})
+		for pager.More() {
+			resp, err := pager.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("listing lock blobs: %w", err)
+			}
+
+			for _, item := range resp.Segment.BlobItems {
+				if item.Name == nil || !strings.HasSuffix(*item.Name, lockSuffix) {
+					continue
+				}
+
+				key, ok := s.keyName(strings.TrimSuffix(*item.Name, lockSuffix), item.Metadata)
+				if !ok || !s.owns(b, key) {
+					continue
+				}
+				info := LockInfo{Key: key}
+				if props := item.Properties; props != nil {
+					if props.LastModified != nil {
+						info.Modified = *props.LastModified
+					}
+					if props.LeaseState != nil {
+						info.LeaseState = string(*props.LeaseState)
+					}
+					info.Held = props.LeaseStatus != nil && *props.LeaseStatus == lease.StatusTypeLocked
+				}
+				locks = append(locks, info)
+			}
+		}
+	}
+
+	return locks, nil
+}
+
+// metadataModified returns the modification time recorded in blob metadata, if any.
+func metadataModified(metadata map[string]*string) (time.Time, bool) {
+	value, ok := metadataValue(metadata, modifiedMetadataKey)
+	if !ok {
+		return time.Time{}, false
+	}
+	modified, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return modified, true
+}
+
+// metadataValue returns the blob metadata entry key, if any. Metadata names are
+// case-insensitive in Azure, so the lookup is too.
+func metadataValue(metadata map[string]*string, key string) (string, bool) {
+	for name, value := range metadata {
+		if strings.EqualFold(name, key) && value != nil {
+			return *value, true
+		}
+	}
+	return "", false
+}
+
 func (s *Storage) objLockName(key string) string {
-	return key + ".lock"
+	return s.encodeName(key, MaxBlobNameLength-len(lockSuffix)) + lockSuffix
 }
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/webedmj/certmagic-azureblob/storage/storagetest"
 )
 
 func init() {
@@ -23,7 +27,18 @@ const (
 	testContainerName = "test-container"
 )
 
+// fakeServer is the in-memory Blob service used when AZURE_STORAGE_ACCOUNT is not set.
+// Like a real account it is shared by all tests, so storages created by one test see
+// each other's blobs.
+var fakeServer = sync.OnceValue(storagetest.NewServer)
+
 func setupTestStorage(t *testing.T) *Storage {
+	return setupTestStorageWith(t, func(*Config) {})
+}
+
+// setupTestStorageWith is setupTestStorage with a hook to adjust the Config before
+// the storage is created.
+func setupTestStorageWith(t *testing.T, configure func(*Config)) *Storage {
 	ctx := context.Background()
 
 	// Check if we should skip Azurite tests, this currently all tests but could be useful in the future
@@ -34,9 +49,11 @@ func setupTestStorage(t *testing.T) *Storage {
 	// Get connection string from environment (optional)
 	connectionString := os.Getenv("AZURE_STORAGE_CONNECTION_STRING")
 
-	// Get account name from environment (required)
+	// Get account name from environment, or use the in-memory Blob service without one
 	accountName := os.Getenv("AZURE_STORAGE_ACCOUNT")
-	require.NotEmpty(t, accountName, "AZURE_STORAGE_ACCOUNT is required")
+	if accountName == "" {
+		accountName, connectionString = storagetest.AccountName, fakeServer().ConnectionString()
+	}
 
 	config := Config{
 		AccountName:      accountName,
@@ -44,6 +61,7 @@ func setupTestStorage(t *testing.T) *Storage {
 		ConnectionString: connectionString,
 		// Credential will use default Azure credential chain if ConnectionString is empty
 	}
+	configure(&config)
 
 	s, err := NewStorage(ctx, config)
 	require.NoError(t, err, "Azure storage or Azurite must be available")
@@ -199,6 +217,24 @@ func TestLockingOperations(t *testing.T) {
 	_ = s.Delete(ctx, lockKey)
 }
 
+// Test that ForceUnlock breaks a lock held by another instance, so it can be acquired
+// right away, and that breaking a lock nobody holds succeeds.
+func TestForceUnlock(t *testing.T) {
+	holder, other := setupTestStorage(t), setupTestStorage(t)
+	ctx := context.Background()
+	key := fmt.Sprintf("force-unlock/%d", time.Now().UnixNano())
+
+	require.NoError(t, holder.Lock(ctx, key))
+	require.NoError(t, other.ForceUnlock(ctx, key))
+	lockCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, other.Lock(lockCtx, key), "A broken lock should be free")
+	require.NoError(t, other.Unlock(ctx, key))
+
+	require.NoError(t, other.ForceUnlock(ctx, key))
+	require.NoError(t, other.ForceUnlock(ctx, key+"-never-locked"))
+}
+
 func TestRenewLockLeaseActiveLeaseSucceeds(t *testing.T) {
 	s := setupTestStorage(t)
 	ctx := context.Background()
@@ -286,6 +322,40 @@ func TestRenewLockLeaseAfterLeaseExpiry(t *testing.T) {
 		"Contender should be blocked by the renewed lease — Azure blob must be in Leased(A) state")
 }
 
+// abandonedLeaseTransport accepts lock blob uploads and holds lease acquisitions until
+// the request is canceled, as if Azure granted a lease the client no longer waits for.
+// It records the lease actions it receives.
+type abandonedLeaseTransport struct {
+	mu      sync.Mutex
+	actions []string
+}
+
+func (t *abandonedLeaseTransport) Do(req *http.Request) (*http.Response, error) {
+	// The SDK sets x-ms-* headers without canonicalizing their names
+	action := strings.Join(req.Header["x-ms-lease-action"], ",")
+	t.mu.Lock()
+	t.actions = append(t.actions, action)
+	t.mu.Unlock()
+	if action == "acquire" {
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	}
+	return statusTransport{status: http.StatusCreated}.Do(req)
+}
+
+// Test that a lease acquisition interrupted by the end of the context is released, so
+// that a lease Azure granted anyway does not hold the lock until it expires.
+func TestLockReleasesLeaseAcquiredAfterContextEnds(t *testing.T) {
+	transport := &abandonedLeaseTransport{}
+	s := newStorage(fakeContainer(t, "container", transport), Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := s.Lock(ctx, "abandoned")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{"", "acquire", "release"}, transport.actions)
+}
+
 func TestRenewLockLeaseContextCancellation(t *testing.T) {
 	s := setupTestStorage(t)
 	ctx := context.Background()
@@ -825,6 +895,23 @@ func TestDeleteDirectoryPrefixDeletesChildren(t *testing.T) {
 	}
 }
 
+// Test Delete on a directory without a trailing slash keeps keys that only share its
+// name as a prefix
+func TestDeleteDirectoryKeepsSiblingKeys(t *testing.T) {
+	s := setupTestStorage(t)
+	ctx := context.Background()
+
+	prefix := fmt.Sprintf("delete-sibling-test/%d/", time.Now().UnixNano())
+	child, sibling := prefix+"dir/file.txt", prefix+"dirsibling.txt"
+	require.NoError(t, s.Store(ctx, child, []byte("child content")))
+	require.NoError(t, s.Store(ctx, sibling, []byte("sibling content")))
+	t.Cleanup(func() { _ = s.Delete(context.Background(), sibling) })
+
+	require.NoError(t, s.Delete(ctx, prefix+"dir"))
+	assert.False(t, s.Exists(ctx, child), "Child key should be deleted by directory delete")
+	assert.True(t, s.Exists(ctx, sibling), "Sibling key sharing the directory name as a prefix should be kept")
+}
+
 // Test context cancellation for Store, Delete, Stat
 func TestContextCancellationForStoreDeleteStat(t *testing.T) {
 	s := setupTestStorage(t)
@@ -0,0 +1,148 @@
+package storagetest
+
+import (
+	"context"
+	"io/fs"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/certmagic"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockWait bounds how long RunConformance waits for a released lock to be acquired,
+// which takes up to one poll interval of the storage.
+const lockWait = 10 * time.Second
+
+// RunConformance runs subtests checking that s behaves as certmagic expects of a
+// certmagic.Storage: stored values can be loaded, listed, described and deleted,
+// missing keys are reported with fs.ErrNotExist, deleting a directory deletes the keys
+// beneath it but not siblings sharing its name as a prefix, and a lock excludes other
+// lockers until it is unlocked. Non-recursive listings use a prefix ending in "/". All
+// keys are created under a unique prefix, which is deleted when the suite is done, so s
+// may be shared with other tests.
+func RunConformance(t *testing.T, s certmagic.Storage) {
+	ctx := context.Background()
+	prefix := "conformance-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	t.Cleanup(func() { _ = s.Delete(ctx, prefix) })
+	key := func(name string) string { return prefix + "/" + name }
+
+	t.Run("StoreLoad", func(t *testing.T) {
+		require.NoError(t, s.Store(ctx, key("store/value"), []byte("first")))
+		value, err := s.Load(ctx, key("store/value"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("first"), value)
+
+		require.NoError(t, s.Store(ctx, key("store/value"), []byte("second")))
+		value, err = s.Load(ctx, key("store/value"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("second"), value, "Store should overwrite an existing key")
+
+		require.NoError(t, s.Store(ctx, key("store/empty"), []byte{}))
+		value, err = s.Load(ctx, key("store/empty"))
+		require.NoError(t, err)
+		assert.Empty(t, value)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := s.Load(ctx, key("missing"))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		_, err = s.Stat(ctx, key("missing"))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		assert.False(t, s.Exists(ctx, key("missing")))
+	})
+
+	t.Run("Stat", func(t *testing.T) {
+		before := time.Now().Add(-time.Minute)
+		require.NoError(t, s.Store(ctx, key("stat/value"), []byte("12345")))
+		assert.True(t, s.Exists(ctx, key("stat/value")))
+
+		info, err := s.Stat(ctx, key("stat/value"))
+		require.NoError(t, err)
+		assert.Equal(t, key("stat/value"), info.Key)
+		assert.Equal(t, int64(5), info.Size)
+		assert.True(t, info.IsTerminal)
+		assert.True(t, info.Modified.After(before), "Modified should be the time of the Store")
+	})
+
+	t.Run("List", func(t *testing.T) {
+		for _, name := range []string{"list/a", "list/b", "list/dir/c", "list/dir/deeper/d"} {
+			require.NoError(t, s.Store(ctx, key(name), []byte(name)))
+		}
+
+		// Implementations may list directories too, like certmagic.FileStorage
+		recursive, err := s.List(ctx, key("list"), true)
+		require.NoError(t, err)
+		assert.Subset(t, recursive, []string{key("list/a"), key("list/b"), key("list/dir/c"), key("list/dir/deeper/d")})
+
+		direct, err := s.List(ctx, key("list")+"/", false)
+		require.NoError(t, err)
+		assert.Contains(t, direct, key("list/a"))
+		assert.Contains(t, direct, key("list/b"))
+		assert.NotContains(t, direct, key("list/dir/c"), "A non-recursive list should not contain nested keys")
+		assert.NotContains(t, direct, key("list/dir/deeper/d"), "A non-recursive list should not contain nested keys")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		require.NoError(t, s.Store(ctx, key("delete/value"), []byte("value")))
+		require.NoError(t, s.Delete(ctx, key("delete/value")))
+		assert.False(t, s.Exists(ctx, key("delete/value")))
+		_, err := s.Load(ctx, key("delete/value"))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("DeleteDirectory", func(t *testing.T) {
+		for _, name := range []string{"tree/a", "tree/sub/b", "tree/sub/deeper/c"} {
+			require.NoError(t, s.Store(ctx, key(name), []byte(name)))
+		}
+		require.NoError(t, s.Store(ctx, key("treeline"), []byte("sibling")))
+
+		require.NoError(t, s.Delete(ctx, key("tree")))
+		for _, name := range []string{"tree/a", "tree/sub/b", "tree/sub/deeper/c"} {
+			assert.False(t, s.Exists(ctx, key(name)), "%s should be deleted with its directory", name)
+		}
+		assert.True(t, s.Exists(ctx, key("treeline")), "A key sharing the directory's name as a prefix should be kept")
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		require.NoError(t, s.Lock(ctx, key("lock")))
+
+		acquired := make(chan error, 1)
+		go func() { acquired <- s.Lock(ctx, key("lock")) }()
+		select {
+		case err := <-acquired:
+			t.Fatalf("A held lock should not be acquired again (err: %v)", err)
+		case <-time.After(500 * time.Millisecond):
+		}
+
+		require.NoError(t, s.Unlock(ctx, key("lock")))
+		select {
+		case err := <-acquired:
+			require.NoError(t, err)
+		case <-time.After(lockWait):
+			t.Fatal("An unlocked lock should be acquired by the waiting locker")
+		}
+		require.NoError(t, s.Unlock(ctx, key("lock")))
+	})
+
+	t.Run("LockIndependentKeys", func(t *testing.T) {
+		require.NoError(t, s.Lock(ctx, key("lock-a")))
+		defer func() { assert.NoError(t, s.Unlock(ctx, key("lock-a"))) }()
+
+		lockCtx, cancel := context.WithTimeout(ctx, lockWait)
+		defer cancel()
+		require.NoError(t, s.Lock(lockCtx, key("lock-b")), "Locks on different keys should not exclude each other")
+		require.NoError(t, s.Unlock(ctx, key("lock-b")))
+	})
+
+	t.Run("LockCanceled", func(t *testing.T) {
+		require.NoError(t, s.Lock(ctx, key("lock-canceled")))
+		defer func() { assert.NoError(t, s.Unlock(ctx, key("lock-canceled"))) }()
+
+		lockCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		assert.Error(t, s.Lock(lockCtx, key("lock-canceled")), "Waiting for a held lock should end with the context")
+	})
+}
@@ -0,0 +1,11 @@
+package storagetest
+
+import (
+	"testing"
+
+	"github.com/caddyserver/certmagic"
+)
+
+func TestConformanceFileStorage(t *testing.T) {
+	RunConformance(t, &certmagic.FileStorage{Path: t.TempDir()})
+}
@@ -0,0 +1,766 @@
+// Package storagetest provides an in-memory fake of Azure Blob Storage and a
+// conformance suite for certmagic.Storage implementations, so that storage code can be
+// tested without Azurite or an Azure account.
+package storagetest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// AccountName is the storage account served by Server.
+	AccountName = "devstoreaccount1"
+	// AccountKey is the well-known development storage account key. Server does not
+	// check request signatures, so any key works.
+	AccountKey = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+// Lease states and statuses reported for blobs.
+const (
+	leaseAvailable = "available"
+	leaseLeased    = "leased"
+	leaseExpired   = "expired"
+	leaseBreaking  = "breaking"
+	leaseBroken    = "broken"
+)
+
+// Server is an in-memory fake of the Blob service of a single storage account. It
+// implements the requests that the Azure SDK sends for containers, block and append
+// blobs, listing and leases, with the status codes and error codes of Azure: 404 for
+// missing containers and blobs, 409 for conflicting creates and lease operations, 412
+// for failed conditions and missing lease IDs, and 304 for conditional reads of an
+// unchanged blob. Signatures are not checked, and soft delete, versioning, snapshots
+// and page blobs are not supported. Server is safe for concurrent use.
+type Server struct {
+	// URL is the base URL of the server, e.g. http://127.0.0.1:1234
+	URL string
+
+	server     *httptest.Server
+	mu         sync.Mutex
+	containers map[string]*fakeContainer
+	etags      uint64
+}
+
+type fakeContainer struct {
+	etag     string
+	modified time.Time
+	access   string
+	metadata map[string]string
+	blobs    map[string]*fakeBlob
+}
+
+type fakeBlob struct {
+	data        []byte
+	blobType    string
+	contentType string
+	tier        string
+	metadata    map[string]string
+	etag        string
+	created     time.Time
+	modified    time.Time
+
+	leaseID       string
+	leaseState    string
+	leaseInfinite bool
+	leaseDuration time.Duration
+	leaseExpires  time.Time
+	breakUntil    time.Time
+}
+
+// NewServer starts a Server on a local port. Close it when done.
+func NewServer() *Server {
+	s := &Server{containers: map[string]*fakeContainer{}}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// ConnectionString returns a connection string for the server's account.
+func (s *Server) ConnectionString() string {
+	return fmt.Sprintf("DefaultEndpointsProtocol=http;AccountName=%s;AccountKey=%s;BlobEndpoint=%s/%s;",
+		AccountName, AccountKey, s.URL, AccountName)
+}
+
+// ServeHTTP serves a Blob service request for AccountName, whose URLs have the path
+// /<account>/<container>/<blob>.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-ms-request-id", randomID())
+	w.Header().Set("x-ms-version", r.Header.Get("x-ms-version"))
+	w.Header().Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 3)
+	if parts[0] != AccountName || len(parts) < 2 || parts[1] == "" {
+		writeError(w, r, http.StatusBadRequest, "UnsupportedOperation")
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidInput")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(parts) == 2 || parts[2] == "" {
+		s.serveContainer(w, r, parts[1])
+		return
+	}
+	s.serveBlob(w, r, parts[1], parts[2], body)
+}
+
+func (s *Server) serveContainer(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+	if query.Get("restype") != "container" {
+		writeError(w, r, http.StatusBadRequest, "UnsupportedQueryParameter")
+		return
+	}
+	c := s.containers[name]
+	if r.Method == http.MethodPut && query.Get("comp") == "" {
+		if c != nil {
+			writeError(w, r, http.StatusConflict, "ContainerAlreadyExists")
+			return
+		}
+		c = &fakeContainer{
+			etag:     s.nextETag(),
+			modified: time.Now().UTC(),
+			access:   r.Header.Get("x-ms-blob-public-access"),
+			metadata: requestMetadata(r),
+			blobs:    map[string]*fakeBlob{},
+		}
+		s.containers[name] = c
+		setModified(w, c.etag, c.modified)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	if c == nil {
+		writeError(w, r, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodDelete:
+		delete(s.containers, name)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		s.listBlobs(w, r, name, c)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		setModified(w, c.etag, c.modified)
+		setMetadata(w, c.metadata)
+		if c.access != "" {
+			w.Header().Set("x-ms-blob-public-access", c.access)
+		}
+		w.Header().Set("x-ms-lease-state", leaseAvailable)
+		w.Header().Set("x-ms-lease-status", "unlocked")
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, r, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, containerName, name string, body []byte) {
+	c := s.containers[containerName]
+	if c == nil {
+		writeError(w, r, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	b := c.blobs[name]
+	if b != nil {
+		b.updateLease(time.Now())
+	}
+
+	switch comp := r.URL.Query().Get("comp"); {
+	case r.Method == http.MethodPut && comp == "":
+		s.putBlob(w, r, c, name, b, body)
+	case r.Method == http.MethodPut && comp == "appendblock":
+		s.appendBlock(w, r, b, body)
+	case r.Method == http.MethodPut && comp == "lease":
+		s.lease(w, r, b)
+	case r.Method == http.MethodPut && comp == "tier":
+		if b == nil {
+			writeError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		b.tier = r.Header.Get("x-ms-access-tier")
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut && comp == "undelete":
+		// Without soft delete, only existing blobs can be undeleted, which does nothing
+		if b == nil {
+			writeError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case (r.Method == http.MethodGet || r.Method == http.MethodHead) && comp == "":
+		getBlob(w, r, b)
+	case r.Method == http.MethodDelete && comp == "":
+		if b == nil {
+			writeError(w, r, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		if !checkConditions(w, r, b) || !checkLease(w, r, b) {
+			return
+		}
+		delete(c.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		writeError(w, r, http.StatusBadRequest, "UnsupportedQueryParameter")
+	}
+}
+
+func (s *Server) putBlob(w http.ResponseWriter, r *http.Request, c *fakeContainer, name string, b *fakeBlob, body []byte) {
+	blobType := r.Header.Get("x-ms-blob-type")
+	if blobType != "BlockBlob" && blobType != "AppendBlob" {
+		writeError(w, r, http.StatusBadRequest, "InvalidBlobType")
+		return
+	}
+	if !checkConditions(w, r, b) {
+		return
+	}
+	now := time.Now().UTC()
+	if b == nil {
+		b = &fakeBlob{created: now, leaseState: leaseAvailable}
+		c.blobs[name] = b
+	} else if !checkLease(w, r, b) {
+		return
+	}
+	if blobType == "AppendBlob" {
+		body = nil
+	}
+	b.data = body
+	b.blobType = blobType
+	b.contentType = r.Header.Get("x-ms-blob-content-type")
+	if b.contentType == "" {
+		b.contentType = "application/octet-stream"
+	}
+	b.tier = r.Header.Get("x-ms-access-tier")
+	if b.tier == "" && blobType == "BlockBlob" {
+		b.tier = "Hot"
+	}
+	b.metadata = requestMetadata(r)
+	b.etag = s.nextETag()
+	b.modified = now
+	setModified(w, b.etag, b.modified)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) appendBlock(w http.ResponseWriter, r *http.Request, b *fakeBlob, body []byte) {
+	if b == nil {
+		writeError(w, r, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	if b.blobType != "AppendBlob" {
+		writeError(w, r, http.StatusConflict, "InvalidBlobType")
+		return
+	}
+	if !checkConditions(w, r, b) || !checkLease(w, r, b) {
+		return
+	}
+	w.Header().Set("x-ms-blob-append-offset", strconv.Itoa(len(b.data)))
+	b.data = append(b.data, body...)
+	b.etag = s.nextETag()
+	b.modified = time.Now().UTC()
+	setModified(w, b.etag, b.modified)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// lease performs the lease action of r on b.
+func (s *Server) lease(w http.ResponseWriter, r *http.Request, b *fakeBlob) {
+	if b == nil {
+		writeError(w, r, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	now := time.Now()
+	id := r.Header.Get("x-ms-lease-id")
+	active := b.leaseState == leaseLeased || b.leaseState == leaseBreaking
+
+	switch r.Header.Get("x-ms-lease-action") {
+	case "acquire":
+		duration, err := strconv.Atoi(r.Header.Get("x-ms-lease-duration"))
+		if err != nil || (duration != -1 && (duration < 15 || duration > 60)) {
+			writeError(w, r, http.StatusBadRequest, "InvalidHeaderValue")
+			return
+		}
+		proposed := r.Header.Get("x-ms-proposed-lease-id")
+		switch {
+		case b.leaseState == leaseBreaking:
+			writeError(w, r, http.StatusConflict, "LeaseIsBreakingAndCannotBeAcquired")
+			return
+		case active && proposed != b.leaseID:
+			writeError(w, r, http.StatusConflict, "LeaseAlreadyPresent")
+			return
+		}
+		if proposed == "" {
+			proposed = randomID()
+		}
+		b.leaseID = proposed
+		b.leaseState = leaseLeased
+		b.leaseInfinite = duration == -1
+		b.leaseDuration = time.Duration(duration) * time.Second
+		b.leaseExpires = now.Add(b.leaseDuration)
+		w.Header().Set("x-ms-lease-id", b.leaseID)
+		setModified(w, b.etag, b.modified)
+		w.WriteHeader(http.StatusCreated)
+
+	case "renew":
+		switch {
+		case b.leaseID == "" || b.leaseState == leaseAvailable:
+			writeError(w, r, http.StatusConflict, "LeaseNotPresentWithLeaseOperation")
+			return
+		case id != b.leaseID:
+			writeError(w, r, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation")
+			return
+		case b.leaseState == leaseBreaking || b.leaseState == leaseBroken:
+			writeError(w, r, http.StatusConflict, "LeaseIsBrokenAndCannotBeRenewed")
+			return
+		}
+		// An expired lease can be renewed while nobody else acquired the blob
+		b.leaseState = leaseLeased
+		b.leaseExpires = now.Add(b.leaseDuration)
+		w.Header().Set("x-ms-lease-id", b.leaseID)
+		setModified(w, b.etag, b.modified)
+		w.WriteHeader(http.StatusOK)
+
+	case "release":
+		switch {
+		case b.leaseID == "" || b.leaseState == leaseAvailable:
+			writeError(w, r, http.StatusConflict, "LeaseNotPresentWithLeaseOperation")
+			return
+		case id != b.leaseID:
+			writeError(w, r, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation")
+			return
+		}
+		b.clearLease()
+		setModified(w, b.etag, b.modified)
+		w.WriteHeader(http.StatusOK)
+
+	case "break":
+		if b.leaseID == "" || b.leaseState == leaseAvailable {
+			writeError(w, r, http.StatusConflict, "LeaseNotPresentWithLeaseOperation")
+			return
+		}
+		// Expired and broken leases break immediately
+		remaining := time.Duration(0)
+		switch {
+		case b.leaseState == leaseBreaking:
+			remaining = time.Until(b.breakUntil)
+		case b.leaseState == leaseLeased && !b.leaseInfinite:
+			remaining = time.Until(b.leaseExpires)
+		}
+		if period := r.Header.Get("x-ms-lease-break-period"); period != "" {
+			seconds, err := strconv.Atoi(period)
+			if err != nil || seconds < 0 || seconds > 60 {
+				writeError(w, r, http.StatusBadRequest, "InvalidHeaderValue")
+				return
+			}
+			if requested := time.Duration(seconds) * time.Second; active && (b.leaseInfinite || requested < remaining) {
+				remaining = requested
+			}
+		}
+		if remaining <= 0 {
+			b.leaseState = leaseBroken
+		} else {
+			b.leaseState = leaseBreaking
+			b.breakUntil = now.Add(remaining)
+		}
+		w.Header().Set("x-ms-lease-time", strconv.Itoa(int(remaining.Seconds())))
+		setModified(w, b.etag, b.modified)
+		w.WriteHeader(http.StatusAccepted)
+
+	case "change":
+		if !active || id != b.leaseID {
+			writeError(w, r, http.StatusConflict, "LeaseIdMismatchWithLeaseOperation")
+			return
+		}
+		b.leaseID = r.Header.Get("x-ms-proposed-lease-id")
+		w.Header().Set("x-ms-lease-id", b.leaseID)
+		setModified(w, b.etag, b.modified)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		writeError(w, r, http.StatusBadRequest, "InvalidHeaderValue")
+	}
+}
+
+func getBlob(w http.ResponseWriter, r *http.Request, b *fakeBlob) {
+	if b == nil {
+		writeError(w, r, http.StatusNotFound, "BlobNotFound")
+		return
+	}
+	if !checkLeaseID(w, r, b) {
+		return
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, b.etag) {
+		w.Header().Set("x-ms-error-code", "ConditionNotMet")
+		setModified(w, b.etag, b.modified)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && !etagMatches(match, b.etag) {
+		writeError(w, r, http.StatusPreconditionFailed, "ConditionNotMet")
+		return
+	}
+
+	header := w.Header()
+	setModified(w, b.etag, b.modified)
+	setMetadata(w, b.metadata)
+	header.Set("Content-Type", b.contentType)
+	header.Set("x-ms-blob-type", b.blobType)
+	header.Set("x-ms-creation-time", b.created.Format(http.TimeFormat))
+	header.Set("x-ms-lease-state", b.leaseState)
+	header.Set("x-ms-lease-status", b.leaseStatus())
+	if b.leaseState == leaseLeased {
+		header.Set("x-ms-lease-duration", b.leaseDurationType())
+	}
+	if b.tier != "" {
+		header.Set("x-ms-access-tier", b.tier)
+	}
+	header.Set("Accept-Ranges", "bytes")
+
+	data, status := b.data, http.StatusOK
+	rangeHeader := r.Header.Get("x-ms-range")
+	if rangeHeader == "" {
+		rangeHeader = r.Header.Get("Range")
+	}
+	if rangeHeader != "" && len(b.data) > 0 {
+		start, end, ok := parseRange(rangeHeader, len(b.data))
+		if !ok {
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		data, status = b.data[start:end+1], http.StatusPartialContent
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(b.data)))
+	}
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+// parseRange parses a "bytes=start-end" or "bytes=start-" range of a blob of size bytes.
+func parseRange(value string, size int) (start, end int, ok bool) {
+	spec, found := strings.CutPrefix(value, "bytes=")
+	if !found {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if last != "" {
+		if end, err = strconv.Atoi(last); err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+func (s *Server) listBlobs(w http.ResponseWriter, r *http.Request, containerName string, c *fakeContainer) {
+	query := r.URL.Query()
+	prefix, delimiter, marker := query.Get("prefix"), query.Get("delimiter"), query.Get("marker")
+	maxResults := 5000
+	if value := query.Get("maxresults"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, "OutOfRangeQueryParameterValue")
+			return
+		}
+		maxResults = min(n, maxResults)
+	}
+	include := strings.Split(query.Get("include"), ",")
+
+	names := make([]string, 0, len(c.blobs))
+	for name := range c.blobs {
+		if strings.HasPrefix(name, prefix) && name >= marker {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	result := listResult{
+		ServiceEndpoint: s.URL + "/" + AccountName,
+		ContainerName:   containerName,
+		Prefix:          prefix,
+		Marker:          marker,
+		MaxResults:      maxResults,
+		Delimiter:       delimiter,
+	}
+	now := time.Now()
+	var count int
+	for _, name := range names {
+		if count == maxResults {
+			result.NextMarker = name
+			break
+		}
+		if delimiter != "" {
+			if j := strings.Index(name[len(prefix):], delimiter); j >= 0 {
+				blobPrefix := name[:len(prefix)+j+len(delimiter)]
+				if n := len(result.Blobs.Prefixes); n == 0 || result.Blobs.Prefixes[n-1].Name != blobPrefix {
+					result.Blobs.Prefixes = append(result.Blobs.Prefixes, listPrefix{Name: blobPrefix})
+					count++
+				}
+				continue
+			}
+		}
+		b := c.blobs[name]
+		b.updateLease(now)
+		item := listBlob{Name: name, Properties: listProperties{
+			CreationTime:  b.created.Format(http.TimeFormat),
+			LastModified:  b.modified.Format(http.TimeFormat),
+			ETag:          strings.Trim(b.etag, `"`),
+			ContentLength: len(b.data),
+			ContentType:   b.contentType,
+			BlobType:      b.blobType,
+			AccessTier:    b.tier,
+			LeaseStatus:   b.leaseStatus(),
+			LeaseState:    b.leaseState,
+		}}
+		if b.leaseState == leaseLeased {
+			item.Properties.LeaseDuration = b.leaseDurationType()
+		}
+		if slices.Contains(include, "metadata") {
+			item.Metadata = xmlMetadata(b.metadata)
+		}
+		result.Blobs.Blobs = append(result.Blobs.Blobs, item)
+		count++
+	}
+
+	out, err := xml.Marshal(result)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "InternalError")
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(out)
+}
+
+type listResult struct {
+	XMLName         xml.Name  `xml:"EnumerationResults"`
+	ServiceEndpoint string    `xml:"ServiceEndpoint,attr"`
+	ContainerName   string    `xml:"ContainerName,attr"`
+	Prefix          string    `xml:"Prefix,omitempty"`
+	Marker          string    `xml:"Marker,omitempty"`
+	MaxResults      int       `xml:"MaxResults"`
+	Delimiter       string    `xml:"Delimiter,omitempty"`
+	Blobs           listBlobs `xml:"Blobs"`
+	NextMarker      string    `xml:"NextMarker"`
+}
+
+type listBlobs struct {
+	Blobs    []listBlob   `xml:"Blob"`
+	Prefixes []listPrefix `xml:"BlobPrefix"`
+}
+
+type listBlob struct {
+	Name       string         `xml:"Name"`
+	Properties listProperties `xml:"Properties"`
+	Metadata   xmlMetadata    `xml:"Metadata,omitempty"`
+}
+
+type listProperties struct {
+	CreationTime  string `xml:"Creation-Time"`
+	LastModified  string `xml:"Last-Modified"`
+	ETag          string `xml:"Etag"`
+	ContentLength int    `xml:"Content-Length"`
+	ContentType   string `xml:"Content-Type"`
+	BlobType      string `xml:"BlobType"`
+	AccessTier    string `xml:"AccessTier,omitempty"`
+	LeaseStatus   string `xml:"LeaseStatus"`
+	LeaseState    string `xml:"LeaseState"`
+	LeaseDuration string `xml:"LeaseDuration,omitempty"`
+}
+
+type listPrefix struct {
+	Name string `xml:"Name"`
+}
+
+// xmlMetadata marshals metadata as one element per name, the way Azure lists it.
+type xmlMetadata map[string]string
+
+func (m xmlMetadata) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if len(m) == 0 {
+		return nil
+	}
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if err := e.EncodeElement(m[name], xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// updateLease moves an expired or broken lease of b to its state at now.
+func (b *fakeBlob) updateLease(now time.Time) {
+	switch {
+	case b.leaseState == leaseLeased && !b.leaseInfinite && !now.Before(b.leaseExpires):
+		b.leaseState = leaseExpired
+	case b.leaseState == leaseBreaking && !now.Before(b.breakUntil):
+		b.leaseState = leaseBroken
+	}
+}
+
+func (b *fakeBlob) clearLease() {
+	b.leaseID = ""
+	b.leaseState = leaseAvailable
+	b.leaseInfinite = false
+}
+
+func (b *fakeBlob) leaseStatus() string {
+	if b.leaseState == leaseLeased || b.leaseState == leaseBreaking {
+		return "locked"
+	}
+	return "unlocked"
+}
+
+func (b *fakeBlob) leaseDurationType() string {
+	if b.leaseInfinite {
+		return "infinite"
+	}
+	return "fixed"
+}
+
+// checkLease writes an error and returns false unless r may modify b: a blob with an
+// active lease can only be modified with its lease ID, and a lease ID requires one.
+func checkLease(w http.ResponseWriter, r *http.Request, b *fakeBlob) bool {
+	id := r.Header.Get("x-ms-lease-id")
+	active := b.leaseState == leaseLeased || b.leaseState == leaseBreaking
+	switch {
+	case active && id == "":
+		writeError(w, r, http.StatusPreconditionFailed, "LeaseIdMissing")
+		return false
+	case active && id != b.leaseID:
+		writeError(w, r, http.StatusPreconditionFailed, "LeaseIdMismatchWithBlobOperation")
+		return false
+	case !active && id != "":
+		writeError(w, r, http.StatusPreconditionFailed, "LeaseNotPresentWithBlobOperation")
+		return false
+	}
+	return true
+}
+
+// checkLeaseID writes an error and returns false if r names a lease that b does not have.
+func checkLeaseID(w http.ResponseWriter, r *http.Request, b *fakeBlob) bool {
+	id := r.Header.Get("x-ms-lease-id")
+	if id == "" {
+		return true
+	}
+	if b.leaseState != leaseLeased && b.leaseState != leaseBreaking {
+		writeError(w, r, http.StatusPreconditionFailed, "LeaseNotPresentWithBlobOperation")
+		return false
+	}
+	if id != b.leaseID {
+		writeError(w, r, http.StatusPreconditionFailed, "LeaseIdMismatchWithBlobOperation")
+		return false
+	}
+	return true
+}
+
+// checkConditions writes an error and returns false unless the If-Match and
+// If-None-Match conditions of the write request r hold for b, which may be nil.
+func checkConditions(w http.ResponseWriter, r *http.Request, b *fakeBlob) bool {
+	if match := r.Header.Get("If-Match"); match != "" && (b == nil || !etagMatches(match, b.etag)) {
+		writeError(w, r, http.StatusPreconditionFailed, "ConditionNotMet")
+		return false
+	}
+	match := r.Header.Get("If-None-Match")
+	switch {
+	case match == "" || b == nil:
+		return true
+	case match == "*":
+		writeError(w, r, http.StatusConflict, "BlobAlreadyExists")
+		return false
+	case etagMatches(match, b.etag):
+		writeError(w, r, http.StatusPreconditionFailed, "ConditionNotMet")
+		return false
+	}
+	return true
+}
+
+// etagMatches reports whether the condition header value matches etag.
+func etagMatches(condition, etag string) bool {
+	for _, candidate := range strings.Split(condition, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestMetadata returns the x-ms-meta-* headers of r by lower-case name.
+func requestMetadata(r *http.Request) map[string]string {
+	metadata := map[string]string{}
+	for name, values := range r.Header {
+		if meta, ok := strings.CutPrefix(strings.ToLower(name), "x-ms-meta-"); ok && len(values) > 0 {
+			metadata[meta] = values[0]
+		}
+	}
+	return metadata
+}
+
+func setMetadata(w http.ResponseWriter, metadata map[string]string) {
+	for name, value := range metadata {
+		w.Header().Set("x-ms-meta-"+name, value)
+	}
+}
+
+func setModified(w http.ResponseWriter, etag string, modified time.Time) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+}
+
+// writeError answers r with status and an Azure error code.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, http.StatusText(status))
+}
+
+// nextETag returns a new quoted ETag. The caller holds s.mu.
+func (s *Server) nextETag() string {
+	s.etags++
+	return fmt.Sprintf(`"0x8DC%013X"`, s.etags)
+}
+
+func randomID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	id := hex.EncodeToString(b[:])
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
@@ -0,0 +1,151 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/lease"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newContainer starts a Server and creates a container on it.
+func newContainer(t *testing.T) *container.Client {
+	t.Helper()
+	server := NewServer()
+	t.Cleanup(server.Close)
+	client, err := container.NewClientFromConnectionString(server.ConnectionString(), "test", nil)
+	require.NoError(t, err)
+	_, err = client.Create(context.Background(), nil)
+	require.NoError(t, err)
+	return client
+}
+
+// errorCode returns the status and Azure error code of err.
+func errorCode(t *testing.T, err error) (int, string) {
+	t.Helper()
+	var respErr *azcore.ResponseError
+	require.True(t, errors.As(err, &respErr), "expected a response error, got %v", err)
+	return respErr.StatusCode, respErr.ErrorCode
+}
+
+func TestServerContainer(t *testing.T) {
+	client := newContainer(t)
+	ctx := context.Background()
+
+	status, code := errorCode(t, func() error { _, err := client.Create(ctx, nil); return err }())
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "ContainerAlreadyExists", code)
+
+	_, err := client.NewBlobClient("missing").GetProperties(ctx, nil)
+	status, code = errorCode(t, err)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "BlobNotFound", code)
+}
+
+func TestServerConditions(t *testing.T) {
+	client := newContainer(t)
+	ctx := context.Background()
+	blobClient := client.NewBlockBlobClient("dir/key")
+
+	resp, err := blobClient.UploadBuffer(ctx, []byte("value"), nil)
+	require.NoError(t, err)
+	etagAny := azcore.ETagAny
+	_, err = blobClient.UploadBuffer(ctx, []byte("other"), &blockblob.UploadBufferOptions{AccessConditions: &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: &etagAny},
+	}})
+	status, code := errorCode(t, err)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "BlobAlreadyExists", code)
+
+	_, err = blobClient.GetProperties(ctx, &blob.GetPropertiesOptions{AccessConditions: &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfNoneMatch: resp.ETag},
+	}})
+	status, _ = errorCode(t, err)
+	assert.Equal(t, http.StatusNotModified, status)
+
+	stale := azcore.ETag(`"0x0"`)
+	_, err = blobClient.Delete(ctx, &blob.DeleteOptions{AccessConditions: &blob.AccessConditions{
+		ModifiedAccessConditions: &blob.ModifiedAccessConditions{IfMatch: &stale},
+	}})
+	status, code = errorCode(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	assert.Equal(t, "ConditionNotMet", code)
+}
+
+func TestServerLeases(t *testing.T) {
+	client := newContainer(t)
+	ctx := context.Background()
+	blobClient := client.NewBlockBlobClient("key.lock")
+	_, err := blobClient.UploadBuffer(ctx, nil, nil)
+	require.NoError(t, err)
+
+	first, err := lease.NewBlobClient(blobClient.BlobClient(), nil)
+	require.NoError(t, err)
+	second, err := lease.NewBlobClient(blobClient.BlobClient(), nil)
+	require.NoError(t, err)
+
+	_, err = first.AcquireLease(ctx, 15, nil)
+	require.NoError(t, err)
+	_, err = second.AcquireLease(ctx, 15, nil)
+	status, code := errorCode(t, err)
+	assert.Equal(t, http.StatusConflict, status)
+	assert.Equal(t, "LeaseAlreadyPresent", code)
+
+	_, err = blobClient.UploadBuffer(ctx, nil, nil)
+	status, code = errorCode(t, err)
+	assert.Equal(t, http.StatusPreconditionFailed, status)
+	assert.Equal(t, "LeaseIdMissing", code)
+
+	_, err = first.RenewLease(ctx, nil)
+	require.NoError(t, err)
+	_, err = second.ReleaseLease(ctx, nil)
+	_, code = errorCode(t, err)
+	assert.Equal(t, "LeaseIdMismatchWithLeaseOperation", code)
+
+	breakPeriod := int32(0)
+	_, err = second.BreakLease(ctx, &lease.BlobBreakOptions{BreakPeriod: &breakPeriod})
+	require.NoError(t, err)
+	_, err = second.AcquireLease(ctx, -1, nil)
+	require.NoError(t, err, "A broken lease should be acquirable")
+
+	pager := client.NewListBlobsFlatPager(nil)
+	page, err := pager.NextPage(ctx)
+	require.NoError(t, err)
+	require.Len(t, page.Segment.BlobItems, 1)
+	assert.Equal(t, lease.StatusTypeLocked, *page.Segment.BlobItems[0].Properties.LeaseStatus)
+}
+
+func TestServerListing(t *testing.T) {
+	client := newContainer(t)
+	ctx := context.Background()
+	for _, name := range []string{"a/1", "a/2", "a/b/3", "c"} {
+		_, err := client.NewBlockBlobClient(name).UploadBuffer(ctx, []byte(name), nil)
+		require.NoError(t, err)
+	}
+
+	prefix, maxResults := "a/", int32(2)
+	pager := client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{Prefix: &prefix, MaxResults: &maxResults})
+	var names []string
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		require.NoError(t, err)
+		for _, item := range page.Segment.BlobItems {
+			names = append(names, *item.Name)
+		}
+	}
+	assert.Equal(t, []string{"a/1", "a/2", "a/b/3"}, names, "Pages should continue at the marker")
+
+	hierarchy := client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{Prefix: &prefix})
+	page, err := hierarchy.NextPage(ctx)
+	require.NoError(t, err)
+	require.Len(t, page.Segment.BlobPrefixes, 1)
+	assert.Equal(t, "a/b/", *page.Segment.BlobPrefixes[0].Name)
+	assert.Len(t, page.Segment.BlobItems, 2)
+}
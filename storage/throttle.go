@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.uber.org/zap"
+)
+
+// maxRetryAfter caps how long a Retry-After response pauses new requests.
+const maxRetryAfter = time.Minute
+
+// ThrottleConfig limits the number of concurrent Azure requests. The limit adapts to
+// throttling (AIMD): every 429 or 503 response halves it, down to MinConcurrency, and
+// Retry-After pauses new requests; successful responses raise it again, additively,
+// up to MaxConcurrency. Lock renewals bypass the limit so that held locks are not lost
+// while the account is throttled. The primary account and every route's account are
+// limited separately.
+type ThrottleConfig struct {
+	// MaxConcurrency is the initial and largest number of concurrent requests
+	MaxConcurrency int
+	// MinConcurrency is the smallest number of concurrent requests. Defaults to 1 (optional)
+	MinConcurrency int
+}
+
+// priorityKey marks a request context as bypassing the throttle.
+type priorityKey struct{}
+
+// withPriority marks ctx so that its requests bypass the throttle.
+func withPriority(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityKey{}, true)
+}
+
+// throttle is an adaptive limit on concurrent requests.
+type throttle struct {
+	logger   *zap.Logger
+	minLimit float64
+	maxLimit float64
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	// pausedUntil holds back new requests after a Retry-After response.
+	pausedUntil time.Time
+	// decreasedAt is when the limit was last decreased. Throttled responses to requests
+	// sent before then were sent under the old limit and do not decrease it again.
+	decreasedAt time.Time
+	// released is closed and replaced whenever a slot is freed or the pause changes.
+	released chan struct{}
+}
+
+func newThrottle(config *ThrottleConfig, logger *zap.Logger) *throttle {
+	t := &throttle{
+		logger:   logger,
+		minLimit: float64(max(config.MinConcurrency, 1)),
+		maxLimit: float64(max(config.MaxConcurrency, 1)),
+		released: make(chan struct{}),
+	}
+	t.minLimit = min(t.minLimit, t.maxLimit)
+	t.limit = t.maxLimit
+	return t
+}
+
+// acquire waits for a request slot and returns when it was granted.
+func (t *throttle) acquire(ctx context.Context, priority bool) (time.Time, error) {
+	for {
+		t.mu.Lock()
+		now := time.Now()
+		if priority || (!now.Before(t.pausedUntil) && t.inFlight < int(t.limit)) {
+			t.inFlight++
+			t.mu.Unlock()
+			return now, nil
+		}
+		released := t.released
+		var pause <-chan time.Time
+		var timer *time.Timer
+		if now.Before(t.pausedUntil) {
+			timer = time.NewTimer(t.pausedUntil.Sub(now))
+			pause = timer.C
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-pause:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err := ctx.Err(); err != nil {
+			return time.Time{}, err
+		}
+	}
+}
+
+// release frees the slot of a request granted at start and adapts the limit to its
+// response.
+func (t *throttle) release(start time.Time, resp *http.Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.inFlight--
+	switch {
+	case resp == nil:
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		if start.After(t.decreasedAt) {
+			t.limit = max(t.limit/2, t.minLimit)
+			t.decreasedAt = time.Now()
+			t.logger.Debug("azure requests throttled; reducing concurrency", zap.Int("limit", int(t.limit)))
+		}
+		if wait := retryAfter(resp); wait > 0 {
+			t.pausedUntil = time.Now().Add(min(wait, maxRetryAfter))
+		}
+	case resp.StatusCode < 500:
+		t.limit = min(t.limit+1/t.limit, t.maxLimit)
+	}
+	close(t.released)
+	t.released = make(chan struct{})
+}
+
+// currentLimit returns the current concurrency limit.
+func (t *throttle) currentLimit() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return int(t.limit)
+}
+
+// retryAfter returns how long resp asks clients to wait before retrying.
+func retryAfter(resp *http.Response) time.Duration {
+	for _, header := range []string{"x-ms-retry-after-ms", "retry-after-ms"} {
+		if ms, err := strconv.Atoi(resp.Header.Get(header)); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// throttlePolicy holds every try of a request to the throttle's limit.
+type throttlePolicy struct {
+	throttle *throttle
+}
+
+func (p throttlePolicy) Do(req *policy.Request) (*http.Response, error) {
+	ctx := req.Raw().Context()
+	priority, _ := ctx.Value(priorityKey{}).(bool)
+	start, err := p.throttle.acquire(ctx, priority)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := req.Next()
+	p.throttle.release(start, resp)
+	return resp, err
+}
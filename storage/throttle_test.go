@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// throttledResponse returns a 503 response with the given Retry-After header.
+func throttledResponse(retryAfter string) *http.Response {
+	header := http.Header{}
+	if retryAfter != "" {
+		header.Set("Retry-After", retryAfter)
+	}
+	return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: header}
+}
+
+// acquireWithin tries to acquire a slot within timeout.
+func acquireWithin(t *throttle, timeout time.Duration, priority bool) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return t.acquire(ctx, priority)
+}
+
+func TestThrottleLimitsConcurrency(t *testing.T) {
+	th := newThrottle(&ThrottleConfig{MaxConcurrency: 2}, zap.NewNop())
+
+	first, err := acquireWithin(th, time.Second, false)
+	require.NoError(t, err)
+	_, err = acquireWithin(th, time.Second, false)
+	require.NoError(t, err)
+	_, err = acquireWithin(th, 20*time.Millisecond, false)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "A third request should wait for a free slot")
+
+	_, err = acquireWithin(th, 20*time.Millisecond, true)
+	require.NoError(t, err, "Priority requests should bypass the limit")
+
+	done := make(chan error)
+	go func() {
+		_, err := acquireWithin(th, time.Second, false)
+		done <- err
+	}()
+	th.release(first, &http.Response{StatusCode: http.StatusOK})
+	th.release(first, &http.Response{StatusCode: http.StatusOK})
+	require.NoError(t, <-done)
+}
+
+func TestThrottleAdaptsLimit(t *testing.T) {
+	th := newThrottle(&ThrottleConfig{MaxConcurrency: 8, MinConcurrency: 2}, zap.NewNop())
+
+	before, err := acquireWithin(th, time.Second, false)
+	require.NoError(t, err)
+	start, err := acquireWithin(th, time.Second, false)
+	require.NoError(t, err)
+	th.release(start, throttledResponse(""))
+	assert.Equal(t, 4, th.currentLimit())
+
+	// Requests sent before the decrease do not decrease the limit again
+	th.release(before, throttledResponse(""))
+	assert.Equal(t, 4, th.currentLimit())
+
+	for range 2 {
+		start, err := acquireWithin(th, time.Second, false)
+		require.NoError(t, err)
+		th.release(start, throttledResponse(""))
+	}
+	assert.Equal(t, 2, th.currentLimit(), "The limit should not drop below MinConcurrency")
+
+	for range 60 {
+		start, err := acquireWithin(th, time.Second, false)
+		require.NoError(t, err)
+		th.release(start, &http.Response{StatusCode: http.StatusOK})
+	}
+	assert.Equal(t, 8, th.currentLimit(), "Successful responses should restore the limit")
+}
+
+func TestThrottleHonorsRetryAfter(t *testing.T) {
+	th := newThrottle(&ThrottleConfig{MaxConcurrency: 8}, zap.NewNop())
+	start, err := acquireWithin(th, time.Second, false)
+	require.NoError(t, err)
+	th.release(start, throttledResponse("1"))
+
+	_, err = acquireWithin(th, 50*time.Millisecond, false)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "Requests should pause for Retry-After")
+	_, err = acquireWithin(th, 50*time.Millisecond, true)
+	require.NoError(t, err, "Priority requests should not pause")
+
+	_, err = acquireWithin(th, 2*time.Second, false)
+	require.NoError(t, err, "Requests should resume after Retry-After")
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := throttledResponse("2")
+	assert.Equal(t, 2*time.Second, retryAfter(resp))
+	resp.Header.Set("x-ms-retry-after-ms", "250")
+	assert.Equal(t, 250*time.Millisecond, retryAfter(resp))
+	assert.Zero(t, retryAfter(throttledResponse("")))
+	assert.InDelta(t, float64(time.Minute), float64(retryAfter(throttledResponse(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)))), float64(2*time.Second))
+}
+
+func TestThrottlePolicy(t *testing.T) {
+	th := newThrottle(&ThrottleConfig{MaxConcurrency: 4}, zap.NewNop())
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport:        statusTransport{status: http.StatusServiceUnavailable, errorCode: "ServerBusy"},
+			Retry:            policy.RetryOptions{MaxRetries: -1},
+			PerRetryPolicies: []policy.Policy{throttlePolicy{throttle: th}},
+		},
+	})
+	require.NoError(t, err)
+
+	s := newStorage(azureBlobs{containerClient}, Config{})
+	assert.False(t, s.Exists(context.Background(), "key.txt"))
+	assert.Equal(t, 2, th.currentLimit())
+}
+
+// Test that every account is throttled separately.
+func TestAccountOptionsThrottleEachAccount(t *testing.T) {
+	config := Config{Throttle: &ThrottleConfig{MaxConcurrency: 4}, Logger: zap.NewNop()}
+	accountThrottle := func(options azcore.ClientOptions) *throttle {
+		for _, p := range options.PerRetryPolicies {
+			if p, ok := p.(throttlePolicy); ok {
+				return p.throttle
+			}
+		}
+		require.FailNow(t, "no throttle policy")
+		return nil
+	}
+	primary := accountThrottle(accountOptions(config, azcore.ClientOptions{}))
+	route := accountThrottle(accountOptions(config, azcore.ClientOptions{}))
+	assert.NotSame(t, primary, route)
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+)
+
+// TierPolicy describes which blobs ApplyTierPolicy moves to a different access tier.
+type TierPolicy struct {
+	// Tier is the access tier matching blobs are moved to. Only online tiers
+	// (Hot, Cool and Cold) are accepted, because Load cannot read archived blobs.
+	Tier blob.AccessTier
+	// Prefix limits the policy to keys that start with Prefix. Empty matches every key.
+	Prefix string
+	// MinAge is how long a blob must have gone unmodified before it is moved.
+	MinAge time.Duration
+}
+
+// TierReport summarizes a single ApplyTierPolicy run.
+type TierReport struct {
+	// Moved lists the keys whose access tier was changed.
+	Moved []string
+	// Archived lists keys found in the Archive tier. Load returns ErrArchived for
+	// these keys until they are rehydrated to an online tier.
+	Archived []string
+}
+
+// Validate checks that the policy can be applied safely.
+func (p TierPolicy) Validate() error {
+	switch p.Tier {
+	case blob.AccessTierHot, blob.AccessTierCool, blob.AccessTierCold:
+	case blob.AccessTierArchive:
+		return fmt.Errorf("tier policy cannot target the %s tier: archived blobs cannot be loaded", p.Tier)
+	default:
+		return fmt.Errorf("unsupported access tier '%s'", p.Tier)
+	}
+	if p.MinAge <= 0 {
+		return fmt.Errorf("tier policy minimum age must be positive")
+	}
+	return nil
+}
+
+// ApplyTierPolicy moves every blob under policy.Prefix that has not been modified for
+// at least policy.MinAge to policy.Tier. Lock blobs are never moved. Blobs already in
+// the Archive tier are left alone and reported in TierReport.Archived.
+func (s *Storage) ApplyTierPolicy(ctx context.Context, policy TierPolicy) (report TierReport, err error) {
+	ctx, span := s.startSpan(ctx, "ApplyTierPolicy", attrPrefix.String(policy.Prefix))
+	defer func() { endSpan(span, err) }()
+
+	if err := policy.Validate(); err != nil {
+		return report, err
+	}
+	if err := s.checkWritable("set tier", policy.Prefix); err != nil {
+		return report, err
+	}
+
+	cutoff := time.Now().Add(-policy.MinAge)
+	prefix := s.blobPrefix(policy.Prefix)
+	var tierErrs []string
+	for _, b := range s.backends() {
+		pager := b.client.NewListBlobsFlatPager(&container.ListBlobsFlatOptions{
+			Prefix:  &prefix,
+			Include: container.ListBlobsInclude{Metadata: true},
+		})
+
+		for pager.More() {
+			resp, err := pager.NextPage(ctx)
+			if err != nil {
+				return report, fmt.Errorf("listing blobs: %w", err)
+			}
+
+			for _, item := range resp.Segment.BlobItems {
+				if item.Name == nil || item.Properties == nil || strings.HasSuffix(*item.Name, lockSuffix) {
+					continue
+				}
+				key, ok := s.keyName(*item.Name, item.Metadata)
+				if !ok || !strings.HasPrefix(key, policy.Prefix) || !s.owns(b, key) {
+					continue
+				}
+				props := item.Properties
+
+				if props.AccessTier != nil && *props.AccessTier == blob.AccessTierArchive {
+					report.Archived = append(report.Archived, key)
+					continue
+				}
+				if props.AccessTier != nil && *props.AccessTier == policy.Tier {
+					continue
+				}
+				if props.LastModified == nil || props.LastModified.After(cutoff) {
+					continue
+				}
+
+				if _, err := b.client.SetBlobTier(ctx, *item.Name, policy.Tier); err != nil {
+					tierErrs = append(tierErrs, fmt.Sprintf("%s: %v", key, err))
+					continue
+				}
+				report.Moved = append(report.Moved, key)
+			}
+		}
+	}
+
+	if len(tierErrs) > 0 {
+		return report, fmt.Errorf("errors setting blob tiers: %s", strings.Join(tierErrs, "; "))
+	}
+	return report, nil
+}
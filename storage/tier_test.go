@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTierPolicyValidate(t *testing.T) {
+	valid := TierPolicy{Tier: blob.AccessTierCool, MinAge: 24 * time.Hour}
+	assert.NoError(t, valid.Validate())
+
+	archive := TierPolicy{Tier: blob.AccessTierArchive, MinAge: 24 * time.Hour}
+	assert.Error(t, archive.Validate(), "Archive tier must be rejected because Load cannot read archived blobs")
+
+	unknown := TierPolicy{Tier: blob.AccessTier("Frozen"), MinAge: 24 * time.Hour}
+	assert.Error(t, unknown.Validate())
+
+	noAge := TierPolicy{Tier: blob.AccessTierCold}
+	assert.Error(t, noAge.Validate(), "A policy without a minimum age would move freshly written blobs")
+}
+
+// Test that ApplyTierPolicy moves inactive blobs and that they can still be loaded.
+func TestApplyTierPolicyMovesInactiveBlobs(t *testing.T) {
+	s := setupTestStorage(t)
+	ctx := context.Background()
+
+	prefix := "tier-policy-test/"
+	key := prefix + "file.txt"
+	content := []byte("tier me")
+
+	require.NoError(t, s.Store(ctx, key, content))
+	t.Cleanup(func() { _ = s.Delete(context.Background(), key) })
+
+	// Make sure the blob is older than the policy's minimum age.
+	time.Sleep(2 * time.Second)
+
+	report, err := s.ApplyTierPolicy(ctx, TierPolicy{
+		Tier:   blob.AccessTierCool,
+		Prefix: prefix,
+		MinAge: time.Second,
+	})
+	require.NoError(t, err)
+	assert.Contains(t, report.Moved, key)
+	assert.Empty(t, report.Archived)
+
+	loaded, err := s.Load(ctx, key)
+	require.NoError(t, err, "Load must succeed for blobs in the Cool tier")
+	assert.Equal(t, content, loaded)
+
+	// A second run should find nothing left to move.
+	report, err = s.ApplyTierPolicy(ctx, TierPolicy{
+		Tier:   blob.AccessTierCool,
+		Prefix: prefix,
+		MinAge: time.Second,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, report.Moved)
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// RetryConfig configures how the Azure SDK retries failed requests. Zero values use the
+// SDK defaults.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first try. Zero uses the SDK
+	// default of 3; a negative value disables retries.
+	MaxRetries int32
+	// RetryDelay is the initial backoff between tries (SDK default 800ms).
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the backoff between tries (SDK default 60s).
+	MaxRetryDelay time.Duration
+	// TryTimeout bounds a single try of a request (SDK default 1m per MiB).
+	TryTimeout time.Duration
+}
+
+// options converts c to the SDK's retry options.
+func (c RetryConfig) options() policy.RetryOptions {
+	return policy.RetryOptions{
+		MaxRetries:    c.MaxRetries,
+		RetryDelay:    c.RetryDelay,
+		MaxRetryDelay: c.MaxRetryDelay,
+		TryTimeout:    c.TryTimeout,
+	}
+}
+
+// Timeouts are deadlines for whole Storage operations, including all retries. They are
+// only applied when the caller's context has no deadline of its own. Zero means no
+// deadline.
+type Timeouts struct {
+	// Read bounds Load, Stat and Exists.
+	Read time.Duration
+	// Write bounds Store, Delete and Undelete.
+	Write time.Duration
+	// List bounds List, ListLocks and ListDeleted.
+	List time.Duration
+	// Lock bounds Lock (including waiting for another holder to release the lock),
+	// Unlock, RenewLockLease and each background lease renewal.
+	Lock time.Duration
+}
+
+// withTimeout returns ctx with the given deadline, unless timeout is zero or ctx
+// already has a deadline.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
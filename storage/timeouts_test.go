@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingTransport never answers; requests end when their context does.
+type hangingTransport struct{}
+
+func (hangingTransport) Do(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func newHangingStorage(t *testing.T, timeouts Timeouts) *Storage {
+	t.Helper()
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: hangingTransport{}, Retry: policy.RetryOptions{MaxRetries: -1}},
+	})
+	require.NoError(t, err)
+	return newStorage(azureBlobs{containerClient}, Config{Timeouts: timeouts})
+}
+
+func TestWithTimeout(t *testing.T) {
+	ctx, cancel := withTimeout(context.Background(), 0)
+	defer cancel()
+	_, ok := ctx.Deadline()
+	assert.False(t, ok, "A zero timeout should not set a deadline")
+
+	ctx, cancel = withTimeout(context.Background(), time.Minute)
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, time.Second)
+
+	caller, cancelCaller := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelCaller()
+	ctx, cancel = withTimeout(caller, time.Minute)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	assert.WithinDuration(t, time.Now().Add(time.Hour), deadline, time.Second, "The caller's deadline should win")
+}
+
+func TestOperationTimeouts(t *testing.T) {
+	s := newHangingStorage(t, Timeouts{
+		Read:  10 * time.Millisecond,
+		Write: 10 * time.Millisecond,
+		List:  10 * time.Millisecond,
+		Lock:  10 * time.Millisecond,
+	})
+	ctx := context.Background()
+
+	_, err := s.Load(ctx, "key.txt")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = s.Stat(ctx, "key.txt")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, s.Exists(ctx, "key.txt"))
+	_, err = s.List(ctx, "", true)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, s.Store(ctx, "key.txt", []byte("value")), context.DeadlineExceeded)
+	assert.ErrorIs(t, s.Lock(ctx, "key.txt"), context.DeadlineExceeded)
+}
+
+func TestRetryConfigOptions(t *testing.T) {
+	options := RetryConfig{
+		MaxRetries:    -1,
+		RetryDelay:    time.Second,
+		MaxRetryDelay: time.Minute,
+		TryTimeout:    10 * time.Second,
+	}.options()
+	assert.Equal(t, int32(-1), options.MaxRetries)
+	assert.Equal(t, time.Second, options.RetryDelay)
+	assert.Equal(t, time.Minute, options.MaxRetryDelay)
+	assert.Equal(t, 10*time.Second, options.TryTimeout)
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans created by Storage.
+const tracerName = "github.com/webedmj/certmagic-azureblob/storage"
+
+// Span attribute keys.
+const (
+	attrKey       = attribute.Key("azureblob.key")
+	attrPrefix    = attribute.Key("azureblob.prefix")
+	attrRecursive = attribute.Key("azureblob.recursive")
+	attrAttempts  = attribute.Key("azureblob.lock.attempts")
+	attrRequestID = attribute.Key("az.service_request_id")
+)
+
+// operationSpanKey is the context key under which startSpan stores the operation span,
+// so that requestTracePolicy can annotate it rather than one of its own HTTP spans.
+type operationSpanKey struct{}
+
+// startSpan starts a span for a Storage operation.
+func (s *Storage) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx, span := s.tracer.Start(ctx, "azureblob."+name, trace.WithAttributes(attrs...))
+	return context.WithValue(ctx, operationSpanKey{}, span), span
+}
+
+// endSpan records err on span and ends it. Missing keys are an expected answer rather
+// than a failure, so they do not mark the span as failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// requestTracePolicy creates a client span for every try of an Azure request beneath
+// the current span, and records the Azure request ID on the operation span.
+//
+// The SDK can trace requests itself when azcore.ClientOptions.TracingProvider is set
+// through the azotel adapter, but it then adds a span per SDK method between the
+// operation span and the HTTP spans, and does not annotate the operation span. This
+// policy gives the same HTTP spans without that extra layer or another dependency, so
+// TracingProvider is left unset.
+type requestTracePolicy struct {
+	tracer trace.Tracer
+}
+
+func (p requestTracePolicy) Do(req *policy.Request) (*http.Response, error) {
+	raw := req.Raw()
+	ctx, span := p.tracer.Start(raw.Context(), "HTTP "+raw.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", raw.Method),
+			attribute.String("server.address", raw.URL.Host),
+			attribute.String("url.path", raw.URL.Path)))
+	defer span.End()
+
+	resp, err := req.Clone(ctx).Next()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+
+	requestID := resp.Header.Get(headerRequestID)
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", resp.StatusCode),
+		attrRequestID.String(requestID))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Header.Get(headerErrorCode))
+	}
+	if operationSpan, ok := raw.Context().Value(operationSpanKey{}).(trace.Span); ok {
+		operationSpan.SetAttributes(attrRequestID.String(requestID))
+	}
+	return resp, err
+}
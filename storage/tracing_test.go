@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttribute returns the value of attribute key on span, if present.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestSpansCarryRequestIDAndHTTPSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport:        statusTransport{status: http.StatusForbidden, errorCode: "AuthorizationFailure"},
+			Retry:            policy.RetryOptions{MaxRetries: -1},
+			PerRetryPolicies: []policy.Policy{requestTracePolicy{tracer: tp.Tracer(tracerName)}},
+		},
+	})
+	require.NoError(t, err)
+	s := newStorage(azureBlobs{containerClient}, Config{TracerProvider: tp})
+
+	_, err = s.Stat(context.Background(), "certificates/example.com.crt")
+	require.Error(t, err)
+
+	var statSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "azureblob.Stat" {
+			statSpan = span
+		}
+	}
+	require.NotNil(t, statSpan, "Stat should create a span")
+	assert.Equal(t, codes.Error, statSpan.Status().Code)
+
+	key, ok := spanAttribute(statSpan, attrKey)
+	require.True(t, ok)
+	assert.Equal(t, "certificates/example.com.crt", key.AsString())
+	requestID, ok := spanAttribute(statSpan, attrRequestID)
+	require.True(t, ok)
+	assert.Equal(t, "test-request-id", requestID.AsString())
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2, "Each try should add an HTTP span beneath the operation span")
+	httpSpan := spans[0]
+	assert.Equal(t, "HTTP HEAD", httpSpan.Name())
+	assert.Equal(t, statSpan.SpanContext().SpanID(), httpSpan.Parent().SpanID())
+	status, ok := spanAttribute(httpSpan, "http.response.status_code")
+	require.True(t, ok)
+	assert.Equal(t, int64(http.StatusForbidden), status.AsInt64())
+}
+
+func TestMissingKeysDoNotFailSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	containerClient, err := container.NewClientWithNoCredential("https://account.blob.core.windows.net/container", &container.ClientOptions{
+		ClientOptions: azcore.ClientOptions{
+			Transport: statusTransport{status: http.StatusNotFound, errorCode: "BlobNotFound"},
+			Retry:     policy.RetryOptions{MaxRetries: -1},
+		},
+	})
+	require.NoError(t, err)
+	s := newStorage(azureBlobs{containerClient}, Config{TracerProvider: tp})
+
+	assert.False(t, s.Exists(context.Background(), "missing.txt"))
+	_, err = s.Load(context.Background(), "missing.txt")
+	require.Error(t, err)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	for _, span := range spans {
+		assert.NotEqual(t, codes.Error, span.Status().Code, span.Name())
+	}
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TransportConfig customizes the HTTP client used for all Azure requests, including
+// token requests of the default credential.
+type TransportConfig struct {
+	// ProxyURL is the HTTP(S) proxy to use. Defaults to the HTTPS_PROXY and NO_PROXY
+	// environment variables (optional)
+	ProxyURL string
+	// CAFile is a PEM file of CA certificates to trust in addition to the system pool,
+	// e.g. for a TLS-intercepting proxy (optional)
+	CAFile string
+	// ClientCertFile and ClientKeyFile are a PEM certificate and key presented to servers
+	// (or proxies) that require mutual TLS (optional)
+	ClientCertFile string
+	ClientKeyFile  string
+	// MaxIdleConns bounds the idle connections kept open. Defaults to 100 (optional)
+	MaxIdleConns int
+	// IdleConnTimeout is how long idle connections are kept open. Defaults to 90s (optional)
+	IdleConnTimeout time.Duration
+	// DisableHTTP2 restricts requests to HTTP/1.1 (optional)
+	DisableHTTP2 bool
+}
+
+// newHTTPClient creates the HTTP client described by config.
+func newHTTPClient(config *TransportConfig) (*http.Client, error) {
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, errors.New("unexpected default HTTP transport")
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing proxy URL: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CAFile != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", config.CAFile)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if config.MaxIdleConns > 0 {
+		// All requests go to the same account host, so the per-host limit matters most
+		transport.MaxIdleConns = config.MaxIdleConns
+		transport.MaxIdleConnsPerHost = config.MaxIdleConns
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+	if config.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePEM writes a single PEM block to a file in dir and returns its name.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return file
+}
+
+func TestHTTPClientTrustsCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+	caFile := writePEM(t, t.TempDir(), "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+
+	client, err := newHTTPClient(&TransportConfig{})
+	require.NoError(t, err)
+	_, err = client.Get(server.URL)
+	require.Error(t, err, "The test server's certificate should not be trusted by default")
+
+	client, err = newHTTPClient(&TransportConfig{CAFile: caFile})
+	require.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestHTTPClientUsesProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(&TransportConfig{ProxyURL: proxy.URL})
+	require.NoError(t, err)
+	resp, err := client.Get("http://account.blob.core.windows.net/container")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "http://account.blob.core.windows.net/container", proxied)
+}
+
+func TestHTTPClientSettings(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(server.TLS.Certificates[0].PrivateKey)
+	require.NoError(t, err)
+	certFile := writePEM(t, dir, "cert.pem", "CERTIFICATE", server.Certificate().Raw)
+	keyFile := writePEM(t, dir, "key.pem", "PRIVATE KEY", keyDER)
+
+	client, err := newHTTPClient(&TransportConfig{
+		ClientCertFile:  certFile,
+		ClientKeyFile:   keyFile,
+		MaxIdleConns:    10,
+		IdleConnTimeout: time.Minute,
+		DisableHTTP2:    true,
+	})
+	require.NoError(t, err)
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.Len(t, transport.TLSClientConfig.Certificates, 1)
+	assert.Equal(t, 10, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.TLSNextProto)
+}
+
+func TestHTTPClientInvalidConfig(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+
+	for name, config := range map[string]TransportConfig{
+		"proxy":    {ProxyURL: "://proxy"},
+		"missing":  {CAFile: filepath.Join(dir, "missing.pem")},
+		"empty CA": {CAFile: empty},
+		"no key":   {ClientCertFile: empty},
+	} {
+		_, err := newHTTPClient(&config)
+		assert.Error(t, err, name)
+	}
+}